@@ -0,0 +1,52 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "log"
+    "os"
+
+    "github.com/unixsysdev/nano-go-vllm/internal/sampling"
+    "github.com/unixsysdev/nano-go-vllm/pkg/tokenizer"
+)
+
+// runDetect implements `nanovllm detect`: tokenize text with the model's
+// tokenizer and report the watermark z-score for the given key
+func runDetect(args []string) {
+    fs := flag.NewFlagSet("nanovllm detect", flag.ExitOnError)
+    key := fs.Uint64("watermark-key", 0, "watermark key used at generation time (required)")
+    gamma := fs.Float64("watermark-gamma", 0.25, "green-list fraction used at generation time")
+    threshold := fs.Float64("z", 4.0, "z-score above which the text is reported as watermarked")
+    _ = fs.Parse(args)
+
+    rest := fs.Args()
+    if len(rest) < 2 {
+        fmt.Println("Usage: nanovllm detect [flags] <model_path> <text | @file>")
+        fs.PrintDefaults()
+        os.Exit(1)
+    }
+    if !flagSet(fs, "watermark-key") { log.Fatal("detect needs the --watermark-key used at generation time") }
+    text := rest[1]
+    if len(text) > 1 && text[0] == '@' {
+        b, err := os.ReadFile(text[1:])
+        if err != nil { log.Fatalf("read text: %v", err) }
+        text = string(b)
+    }
+
+    tok, err := tokenizer.NewTokenizer(rest[0])
+    if err != nil { log.Fatalf("tokenizer: %v", err) }
+    ids, err := tok.Encode(text)
+    if err != nil { log.Fatalf("encode: %v", err) }
+
+    wm := sampling.Watermark{Key: *key, Gamma: float32(*gamma)}
+    score := wm.Detect(ids)
+    fmt.Printf("Tokens scored: %d\n", score.Scored)
+    fmt.Printf("Green tokens:  %d (%.1f%%, expected %.1f%%)\n", score.Green,
+        100*float64(score.Green)/float64(max(score.Scored, 1)), 100**gamma)
+    fmt.Printf("z-score:       %.2f\n", score.Z)
+    if score.Z > *threshold {
+        fmt.Println("Watermark detected")
+    } else {
+        fmt.Println("No watermark detected")
+    }
+}
@@ -6,6 +6,8 @@ import (
     "log"
     "os"
     "sort"
+    "strconv"
+    "strings"
 
     "github.com/unixsysdev/nano-go-vllm/internal/config"
     "github.com/unixsysdev/nano-go-vllm/internal/engine"
@@ -16,6 +18,10 @@ import (
 )
 
 func main() {
+    if len(os.Args) > 1 && os.Args[1] == "detect" {
+        runDetect(os.Args[2:])
+        return
+    }
     fs := flag.NewFlagSet("nanovllm", flag.ExitOnError)
     maxTokens := fs.Int("max-tokens", 64, "maximum new tokens to generate")
     temperature := fs.Float64("temperature", 0.7, "sampling temperature")
@@ -24,17 +30,50 @@ func main() {
     repPenalty := fs.Float64("repetition-penalty", 1.1, "repetition penalty (>1 to penalize repeats)")
     presencePenalty := fs.Float64("presence-penalty", 0.0, "presence penalty (penalize seen tokens)")
     frequencyPenalty := fs.Float64("frequency-penalty", 0.0, "frequency penalty (per occurrence)")
+    penaltyIncludePrompt := fs.Bool("penalty-include-prompt", false, "apply repetition/presence/frequency penalties to prompt tokens too (HF behaviour)")
+    penaltyLastN := fs.Int("penalty-last-n", 0, "only penalize tokens among the last N (0 = whole history)")
+    minP := fs.Float64("min-p", 0.0, "min-p: drop tokens below min-p * top prob (0 = disabled)")
+    typicalP := fs.Float64("typical-p", 0.0, "locally typical sampling mass (0 or 1 = disabled)")
+    epsilonCutoff := fs.Float64("epsilon-cutoff", 0.0, "epsilon sampling: drop tokens with prob below this (0 = disabled)")
+    etaCutoff := fs.Float64("eta-cutoff", 0.0, "eta sampling: entropy-adaptive cutoff (0 = disabled)")
+    mirostat := fs.Int("mirostat", 0, "mirostat mode (0 = off, 1 = v1, 2 = v2)")
+    mirostatTau := fs.Float64("mirostat-tau", 5.0, "mirostat target surprise (tau)")
+    mirostatEta := fs.Float64("mirostat-eta", 0.1, "mirostat learning rate (eta)")
+    dryMultiplier := fs.Float64("dry-multiplier", 0.0, "DRY repeated-sequence penalty strength (0 = disabled)")
+    dryBase := fs.Float64("dry-base", 1.75, "DRY exponential base per extra repeated token")
+    dryAllowedLength := fs.Int("dry-allowed-length", 2, "DRY: repeats up to this length are not penalized")
+    penaltyAlpha := fs.Float64("penalty-alpha", 0.0, "contrastive search degeneration penalty (0 = off; needs top-k > 1)")
+    negativePrompt := fs.String("negative-prompt", "", "classifier-free guidance: prompt to steer away from")
+    guidanceScale := fs.Float64("guidance-scale", 1.0, "classifier-free guidance scale (1 = off)")
+    watermark := fs.Bool("watermark", false, "watermark the output with a green-list bias (detect with: nanovllm detect)")
+    watermarkKey := fs.Uint64("watermark-key", 0, "watermark key, required with --watermark (keep private; needed for detection)")
+    watermarkGamma := fs.Float64("watermark-gamma", 0.25, "watermark green-list fraction")
+    watermarkDelta := fs.Float64("watermark-delta", 2.0, "watermark logit bias for green tokens")
+    grammarFile := fs.String("grammar", "", "path to a GBNF grammar constraining the output")
+    schemaFile := fs.String("json-schema", "", "path to a JSON Schema the output must validate against")
+    jsonObject := fs.Bool("json", false, "constrain the output to a syntactically valid JSON object")
+    regex := fs.String("regex", "", "regular expression the whole output must match")
+    choices := fs.String("choices", "", "comma-separated list; the output must be exactly one of them")
+    logitBias := fs.String("logit-bias", "", "comma-separated id:bias pairs added to token logits (e.g. 13:-100)")
+    allowedTokens := fs.String("allowed-tokens", "", "comma-separated token ids; only these may be sampled")
+    badWords := fs.String("bad-words", "", "comma-separated words/phrases that must not be generated")
     stream := fs.Bool("stream", false, "stream tokens as they are generated")
     verify := fs.Bool("verify", false, "print top logits for the last token (no sampling)")
+    expertStats := fs.Bool("expert-stats", false, "print per-layer expert load after generation (MoE models)")
+    promptLogprobs := fs.Bool("prompt-logprobs", false, "print the log-probability of each prompt token given the ones before it")
     _ = fs.Parse(os.Args[1:])
 
     args := fs.Args()
     if len(args) < 1 {
         fmt.Println("Usage: nanovllm [flags] <model_path> [prompt]")
+        fmt.Println("       nanovllm detect [flags] <model_path> <text | @file>")
         fs.PrintDefaults()
         os.Exit(1)
     }
 
+    // a default key would let anyone detect (or strip) the watermark
+    if *watermark && !flagSet(fs, "watermark-key") { log.Fatal("--watermark needs an explicit --watermark-key") }
+
     modelPath := args[0]
     prompt := "Hello, how are you?"
     if len(args) > 1 {
@@ -59,14 +98,65 @@ func main() {
         RepetitionPenalty: float32(*repPenalty),
         PresencePenalty:   float32(*presencePenalty),
         FrequencyPenalty:  float32(*frequencyPenalty),
+        PenaltyIncludePrompt: *penaltyIncludePrompt,
+        PenaltyLastN:      *penaltyLastN,
+        MinP:              float32(*minP),
+        TypicalP:          float32(*typicalP),
+        EpsilonCutoff:     float32(*epsilonCutoff),
+        EtaCutoff:         float32(*etaCutoff),
+        Mirostat:          *mirostat,
+        MirostatTau:       float32(*mirostatTau),
+        MirostatEta:       float32(*mirostatEta),
+        DRYMultiplier:     float32(*dryMultiplier),
+        DRYBase:           float32(*dryBase),
+        DRYAllowedLength:  *dryAllowedLength,
+        PenaltyAlpha:      float32(*penaltyAlpha),
+        NegativePrompt:    *negativePrompt,
+        GuidanceScale:     float32(*guidanceScale),
+        PromptLogprobs:    *promptLogprobs,
+    }
+
+    if *watermark {
+        params.Watermark = &sampling.Watermark{Key: *watermarkKey, Gamma: float32(*watermarkGamma), Delta: float32(*watermarkDelta)}
+    }
+    if *grammarFile != "" {
+        src, err := os.ReadFile(*grammarFile)
+        if err != nil { log.Fatalf("grammar: %v", err) }
+        params.Grammar = string(src)
+    }
+    if *schemaFile != "" {
+        src, err := os.ReadFile(*schemaFile)
+        if err != nil { log.Fatalf("json schema: %v", err) }
+        params.JSONSchema = string(src)
+    }
+    params.JSONObject = *jsonObject
+    params.Regex = *regex
+    if *choices != "" { params.Choices = strings.Split(*choices, ",") }
+    if *logitBias != "" {
+        params.LogitBias = make(map[int]float32)
+        for _, kv := range strings.Split(*logitBias, ",") {
+            id, b, ok := strings.Cut(kv, ":")
+            tid, err1 := strconv.Atoi(strings.TrimSpace(id))
+            bias, err2 := strconv.ParseFloat(strings.TrimSpace(b), 32)
+            if !ok || err1 != nil || err2 != nil { log.Fatalf("logit-bias: bad entry %q (want id:bias)", kv) }
+            params.LogitBias[tid] = float32(bias)
+        }
+    }
+    if *allowedTokens != "" {
+        for _, f := range strings.Split(*allowedTokens, ",") {
+            id, err := strconv.Atoi(strings.TrimSpace(f))
+            if err != nil { log.Fatalf("allowed-tokens: bad id %q", f) }
+            params.AllowedTokenIDs = append(params.AllowedTokenIDs, id)
+        }
     }
+    if *badWords != "" { params.BadWords = strings.Split(*badWords, ",") }
 
     tok, _ := tokenizer.NewTokenizer(modelPath)
     if *verify {
         // Build model directly and print last-token top logits
         cfg, err := config.LoadConfig(modelPath)
         if err != nil { log.Fatalf("config: %v", err) }
-        mdl, err := models.NewQwenModel(cfg)
+        mdl, err := models.New(cfg)
         if err != nil { log.Fatalf("model: %v", err) }
         ids, err := tok.Encode(prompt)
         if err != nil { log.Fatalf("encode: %v", err) }
@@ -77,13 +167,12 @@ func main() {
         idBuf := idT.Data().Data().([]int64)
         posBuf := posT.Data().Data().([]int64)
         for i, v := range ids { idBuf[i] = int64(v); posBuf[i] = int64(i) }
-        logits, err := mdl.Forward(idT, posT)
+        logits, err := mdl.Forward(idT, posT, nil)
         if err != nil { log.Fatalf("forward: %v", err) }
         shape := logits.Shape()
-        if len(shape) != 2 { log.Fatalf("unexpected logits shape: %v", shape) }
+        if len(shape) != 2 || shape[0] != 1 { log.Fatalf("unexpected logits shape: %v", shape) }
         V := shape[1]
-        data := logits.Data().Data().([]float32)
-        last := data[(T-1)*V : T*V]
+        last := logits.Data().Data().([]float32)
         // find top-10
         type kv struct{ id int; logit float32 }
         top := make([]kv, V)
@@ -124,5 +213,45 @@ func main() {
         fmt.Printf("Prompt: %s\n", prompt)
         fmt.Printf("Output: %s\n", outputs[0].Text)
         fmt.Printf("Token IDs: %v\n", outputs[0].TokenIDs)
+        if *promptLogprobs { printPromptLogprobs(tok, prompt, outputs[0].PromptLogprobs) }
     }
+    if *expertStats {
+        printExpertLoad(llmEngine.ExpertLoad())
+    }
+}
+
+// printPromptLogprobs prints each prompt token after the first with its
+// log-probability
+func printPromptLogprobs(tok tokenizer.Tokenizer, prompt string, logprobs []float32) {
+    ids, err := tok.Encode(prompt)
+    if err != nil || len(ids) != len(logprobs)+1 { return }
+    fmt.Println("Prompt logprobs (id, logprob, token):")
+    for i, lp := range logprobs {
+        s, _ := tok.Decode(ids[i+1 : i+2])
+        fmt.Printf("%d\t%.4f\t%q\n", ids[i+1], lp, s)
+    }
+}
+
+// printExpertLoad prints each sparse layer's token count per expert and its
+// imbalance (busiest expert over the mean)
+func printExpertLoad(load [][]int64) {
+    fmt.Println("Expert load (tokens per expert):")
+    for i, counts := range load {
+        if len(counts) == 0 { continue }
+        var total, max int64
+        for _, c := range counts {
+            total += c
+            if c > max { max = c }
+        }
+        imbalance := 0.0
+        if total > 0 { imbalance = float64(max) * float64(len(counts)) / float64(total) }
+        fmt.Printf("layer %d: %v (max/mean %.2f)\n", i, counts, imbalance)
+    }
+}
+
+// flagSet reports whether the named flag was given on the command line
+func flagSet(fs *flag.FlagSet, name string) bool {
+    set := false
+    fs.Visit(func(f *flag.Flag) { set = set || f.Name == name })
+    return set
 }
@@ -19,6 +19,8 @@ type Config struct {
 	NumKVCacheBlocks        int     `json:"num_kvcache_blocks"`
 	
 	// Model-specific config
+    Architectures           []string `json:"architectures"`
+    ModelType               string   `json:"model_type"`
 	VocabSize               int     `json:"vocab_size"`
 	HiddenSize              int     `json:"hidden_size"`
 	NumHiddenLayers         int     `json:"num_hidden_layers"`
@@ -29,10 +31,50 @@ type Config struct {
 	MaxPositionEmbeddings   int     `json:"max_position_embeddings"`
 	RMSNormEps              float64 `json:"rms_norm_eps"`
     HeadDim                 int     `json:"head_dim"`
-    EOSTokenID              int     `json:"eos_token_id"`
+    EOSTokenID              int     `json:"eos_token_id"` // first eos_token_id (-1 = unknown)
+    EOSTokenIDs             []int   `json:"-"`            // every id that ends a sequence (Llama 3 lists several)
     RoPETheta               float64 `json:"rope_theta"`
     RopeScalingType         string  `json:"-"`
     RopeScalingFactor       float64 `json:"-"`
+    RopeLowFreqFactor       float64 `json:"-"` // llama3
+    RopeHighFreqFactor      float64 `json:"-"` // llama3
+    RopeOriginalMaxPosition int     `json:"-"` // original_max_position_embeddings
+    RopeShortFactor         []float64 `json:"-"` // longrope
+    RopeLongFactor          []float64 `json:"-"` // longrope
+    RopeAttentionFactor     float64   `json:"-"` // longrope, yarn
+    RopeBetaFast            float64   `json:"-"` // yarn
+    RopeBetaSlow            float64   `json:"-"` // yarn
+    RopeMscale              float64   `json:"-"` // yarn
+    RopeMscaleAllDim        float64   `json:"-"` // yarn
+    PartialRotaryFactor     float64 `json:"partial_rotary_factor"` // fraction of head_dim rotated (0 = all)
+    RotaryDim               int     `json:"rotary_dim"`            // rotated dims (GPT-J); overrides PartialRotaryFactor
+    RopeInterleaved         bool    `json:"-"`                     // adjacent-pair RoPE (GPT-J style) instead of rotate-half
+    TieWordEmbeddings       bool    `json:"tie_word_embeddings"`
+    SlidingWindow           int     `json:"sliding_window"` // 0 = full attention
+    AttentionBias           bool    `json:"attention_bias"` // biased q/k/v projections (Qwen2)
+    QKNorm                  bool    `json:"-"`              // per-head q_norm/k_norm (Qwen3)
+
+    LayerNormEps            float64 `json:"layer_norm_epsilon"` // LayerNorm models (GPT-2)
+    ParallelResidual        bool    `json:"use_parallel_residual"` // x + attn(ln1(x)) + mlp(ln2(x)) (GPT-NeoX)
+
+    // Mixture-of-experts (Mixtral, Qwen2-MoE); NumExperts 0 = dense
+    NumExperts                   int   `json:"num_experts"`
+    NumExpertsPerTok             int   `json:"num_experts_per_tok"`
+    MoEIntermediateSize          int   `json:"moe_intermediate_size"`
+    SharedExpertIntermediateSize int   `json:"shared_expert_intermediate_size"`
+    NormTopKProb                 bool  `json:"norm_topk_prob"`
+    DecoderSparseStep            int   `json:"decoder_sparse_step"` // every n-th layer is sparse
+    MLPOnlyLayers                []int `json:"mlp_only_layers"`     // dense layers in a sparse model
+
+    // Gemma-style options
+    LayerTypes              []string `json:"layer_types"` // per layer: "sliding_attention" or "full_attention"
+    RopeLocalTheta          float64  `json:"rope_local_base_freq"` // RoPE base of sliding layers (0 = RoPETheta)
+    QueryPreAttnScalar      float64  `json:"query_pre_attn_scalar"` // scores scaled by its -1/2 power (0 = head_dim)
+    AttnLogitSoftcapping    float64  `json:"attn_logit_softcapping"`
+    FinalLogitSoftcapping   float64  `json:"final_logit_softcapping"`
+    ScaleEmbeddings         bool     `json:"-"` // multiply embeddings by sqrt(hidden_size)
+    NormWeightOffset        float64  `json:"-"` // RMSNorm scales by (offset + w)
+    SandwichNorm            bool     `json:"-"` // extra norms on the attention and MLP outputs
 }
 
 // LoadConfig loads configuration from model path
@@ -47,6 +89,7 @@ func LoadConfig(modelPath string, opts ...Option) (*Config, error) {
         EnforceEager:          false,
         KVCacheBlockSize:      256,
         NumKVCacheBlocks:      -1,
+        EOSTokenID:            -1,
     }
 
 	for _, opt := range opts {
@@ -65,8 +108,17 @@ func LoadConfig(modelPath string, opts ...Option) (*Config, error) {
         if err := json.Unmarshal(data, &modelConfig); err != nil {
             return nil, err
         }
+        aliasKeys(modelConfig)
 		
 		// Extract relevant fields
+        if v, ok := modelConfig["architectures"].([]interface{}); ok {
+            for _, a := range v {
+                if s, ok := a.(string); ok { cfg.Architectures = append(cfg.Architectures, s) }
+            }
+        }
+        if v, ok := modelConfig["model_type"].(string); ok {
+            cfg.ModelType = v
+        }
 		if v, ok := modelConfig["vocab_size"].(float64); ok {
 			cfg.VocabSize = int(v)
 		}
@@ -106,9 +158,82 @@ func LoadConfig(modelPath string, opts ...Option) (*Config, error) {
         } else {
             cfg.RoPETheta = 10000.0
         }
+        // Phi-3 keeps the original context length at the top level
+        if f, ok := modelConfig["original_max_position_embeddings"].(float64); ok { cfg.RopeOriginalMaxPosition = int(f) }
         if rs, ok := modelConfig["rope_scaling"].(map[string]interface{}); ok {
             if t, ok := rs["type"].(string); ok { cfg.RopeScalingType = t }
+            if t, ok := rs["rope_type"].(string); ok { cfg.RopeScalingType = t }
             if f, ok := rs["factor"].(float64); ok { cfg.RopeScalingFactor = f }
+            if f, ok := rs["low_freq_factor"].(float64); ok { cfg.RopeLowFreqFactor = f }
+            if f, ok := rs["high_freq_factor"].(float64); ok { cfg.RopeHighFreqFactor = f }
+            if f, ok := rs["original_max_position_embeddings"].(float64); ok { cfg.RopeOriginalMaxPosition = int(f) }
+            if f, ok := rs["attention_factor"].(float64); ok { cfg.RopeAttentionFactor = f }
+            if f, ok := rs["beta_fast"].(float64); ok { cfg.RopeBetaFast = f }
+            if f, ok := rs["beta_slow"].(float64); ok { cfg.RopeBetaSlow = f }
+            if f, ok := rs["mscale"].(float64); ok { cfg.RopeMscale = f }
+            if f, ok := rs["mscale_all_dim"].(float64); ok { cfg.RopeMscaleAllDim = f }
+            cfg.RopeShortFactor = floats(rs["short_factor"])
+            cfg.RopeLongFactor = floats(rs["long_factor"])
+        }
+        // NeoX calls the partial rotary factor rotary_pct
+        if v, ok := modelConfig["rotary_pct"].(float64); ok { cfg.PartialRotaryFactor = v }
+        if v, ok := modelConfig["partial_rotary_factor"].(float64); ok { cfg.PartialRotaryFactor = v }
+        // GPT-J and CodeGen give the rotated dims as rotary_dim and pair
+        // adjacent dims; other configs opt in with rotary_emb_interleaved
+        if v, ok := modelConfig["rotary_dim"].(float64); ok { cfg.RotaryDim = int(v) }
+        switch cfg.ModelType {
+        case "gptj", "codegen":
+            cfg.RopeInterleaved = true
+        }
+        if v, ok := modelConfig["rotary_emb_interleaved"].(bool); ok { cfg.RopeInterleaved = v }
+        // Absent tie_word_embeddings takes the HF per-architecture default:
+        // Gemma and GPT-2 tie, the Llama-style configs don't
+        if v, ok := modelConfig["tie_word_embeddings"].(bool); ok {
+            cfg.TieWordEmbeddings = v
+        } else {
+            switch cfg.ModelType {
+            case "gemma", "gemma2", "gemma3_text", "gpt2":
+                cfg.TieWordEmbeddings = true
+            }
+        }
+        // Qwen2 ships sliding_window with use_sliding_window=false
+        if v, ok := modelConfig["sliding_window"].(float64); ok {
+            if use, ok := modelConfig["use_sliding_window"].(bool); !ok || use {
+                cfg.SlidingWindow = int(v)
+            }
+        }
+        // eos_token_id is an id or a list of ids
+        switch v := modelConfig["eos_token_id"].(type) {
+        case float64:
+            cfg.EOSTokenIDs = []int{int(v)}
+        case []interface{}:
+            for _, id := range v {
+                if f, ok := id.(float64); ok { cfg.EOSTokenIDs = append(cfg.EOSTokenIDs, int(f)) }
+            }
+        }
+        if len(cfg.EOSTokenIDs) > 0 { cfg.EOSTokenID = cfg.EOSTokenIDs[0] }
+        // Qwen2 always has q/k/v biases but doesn't say so; Llama-style
+        // configs carry attention_bias explicitly
+        if v, ok := modelConfig["attention_bias"].(bool); ok {
+            cfg.AttentionBias = v
+        } else {
+            cfg.AttentionBias = cfg.ModelType == "qwen2" || cfg.ModelType == "qwen2_moe"
+        }
+        cfg.QKNorm = cfg.ModelType == "qwen3" || cfg.ModelType == "qwen3_moe"
+        parseGemma(cfg, modelConfig)
+        parseMoE(cfg, modelConfig)
+        if v, ok := modelConfig["layer_norm_epsilon"].(float64); ok {
+            cfg.LayerNormEps = v
+        }
+        // GPT-NeoX defaults to the parallel residual
+        if v, ok := modelConfig["use_parallel_residual"].(bool); ok {
+            cfg.ParallelResidual = v
+        } else {
+            cfg.ParallelResidual = cfg.ModelType == "gpt_neox"
+        }
+        if cfg.ModelType == "gpt2" {
+            // n_inner: null means 4*n_embd
+            if cfg.IntermediateSize == 0 { cfg.IntermediateSize = 4 * cfg.HiddenSize }
         }
     }
 
@@ -123,6 +248,104 @@ func LoadConfig(modelPath string, opts ...Option) (*Config, error) {
     return cfg, nil
 }
 
+// floats converts a JSON number array (nil if v is not one)
+func floats(v interface{}) []float64 {
+    arr, ok := v.([]interface{})
+    if !ok { return nil }
+    out := make([]float64, 0, len(arr))
+    for _, x := range arr {
+        if f, ok := x.(float64); ok { out = append(out, f) }
+    }
+    return out
+}
+
+// aliasKeys maps the GPT-2 / GPT-NeoX style config names onto the Llama-style ones
+// the parser reads, unless the latter are present
+func aliasKeys(modelConfig map[string]interface{}) {
+    for legacy, key := range map[string]string{
+        "n_embd":              "hidden_size",
+        "n_layer":             "num_hidden_layers",
+        "n_head":              "num_attention_heads",
+        "n_positions":         "max_position_embeddings",
+        "n_inner":             "intermediate_size",
+        "activation_function": "hidden_act",
+        "layer_norm_eps":      "layer_norm_epsilon",
+        "rotary_emb_base":     "rope_theta",
+    } {
+        if v, ok := modelConfig[legacy]; ok {
+            if _, ok := modelConfig[key]; !ok { modelConfig[key] = v }
+        }
+    }
+}
+
+// parseGemma reads the Gemma family options. The family is recognised by
+// model_type; everything else comes from config.json.
+func parseGemma(cfg *Config, modelConfig map[string]interface{}) {
+    gemma := cfg.ModelType == "gemma" || cfg.ModelType == "gemma2" || cfg.ModelType == "gemma3_text"
+    if v, ok := modelConfig["hidden_activation"].(string); ok {
+        cfg.HiddenAct = v
+    } else if gemma {
+        // HF Gemma ignores hidden_act and uses the tanh approximation
+        cfg.HiddenAct = "gelu_pytorch_tanh"
+    }
+    if v, ok := modelConfig["query_pre_attn_scalar"].(float64); ok { cfg.QueryPreAttnScalar = v }
+    if v, ok := modelConfig["attn_logit_softcapping"].(float64); ok { cfg.AttnLogitSoftcapping = v }
+    if v, ok := modelConfig["final_logit_softcapping"].(float64); ok { cfg.FinalLogitSoftcapping = v }
+    if v, ok := modelConfig["rope_local_base_freq"].(float64); ok { cfg.RopeLocalTheta = v }
+
+    // Alternating local/global layers: explicit layer_types, or every
+    // pattern-th layer global (Gemma 2 alternates, Gemma 3 uses 6)
+    if v, ok := modelConfig["layer_types"].([]interface{}); ok {
+        for _, t := range v {
+            if s, ok := t.(string); ok { cfg.LayerTypes = append(cfg.LayerTypes, s) }
+        }
+    } else {
+        pattern := 0
+        if v, ok := modelConfig["sliding_window_pattern"].(float64); ok {
+            pattern = int(v)
+        } else if cfg.ModelType == "gemma2" {
+            pattern = 2
+        }
+        if pattern > 0 {
+            for i := 0; i < cfg.NumHiddenLayers; i++ {
+                if (i+1)%pattern == 0 {
+                    cfg.LayerTypes = append(cfg.LayerTypes, "full_attention")
+                } else {
+                    cfg.LayerTypes = append(cfg.LayerTypes, "sliding_attention")
+                }
+            }
+        }
+    }
+
+    if !gemma { return }
+    cfg.ScaleEmbeddings = true
+    cfg.NormWeightOffset = 1
+    cfg.SandwichNorm = cfg.ModelType != "gemma"
+    cfg.QKNorm = cfg.QKNorm || cfg.ModelType == "gemma3_text"
+}
+
+// parseMoE reads the mixture-of-experts options (Mixtral names the expert
+// count num_local_experts and always renormalizes the top-k weights)
+func parseMoE(cfg *Config, modelConfig map[string]interface{}) {
+    if v, ok := modelConfig["num_local_experts"].(float64); ok { cfg.NumExperts = int(v) }
+    if v, ok := modelConfig["num_experts"].(float64); ok { cfg.NumExperts = int(v) }
+    if cfg.NumExperts == 0 { return }
+    cfg.NumExpertsPerTok = 2
+    if v, ok := modelConfig["num_experts_per_tok"].(float64); ok { cfg.NumExpertsPerTok = int(v) }
+    cfg.MoEIntermediateSize = cfg.IntermediateSize
+    if v, ok := modelConfig["moe_intermediate_size"].(float64); ok { cfg.MoEIntermediateSize = int(v) }
+    if v, ok := modelConfig["shared_expert_intermediate_size"].(float64); ok { cfg.SharedExpertIntermediateSize = int(v) }
+    cfg.NormTopKProb = cfg.ModelType == "mixtral"
+    if v, ok := modelConfig["norm_topk_prob"].(bool); ok { cfg.NormTopKProb = v }
+    cfg.DecoderSparseStep = 1
+    if v, ok := modelConfig["decoder_sparse_step"].(float64); ok && v > 0 { cfg.DecoderSparseStep = int(v) }
+    if v, ok := modelConfig["mlp_only_layers"].([]interface{}); ok {
+        for _, l := range v {
+            if f, ok := l.(float64); ok { cfg.MLPOnlyLayers = append(cfg.MLPOnlyLayers, int(f)) }
+        }
+    }
+}
+
 // Option is a function that modifies the config
 type Option func(*Config)
 
@@ -0,0 +1,60 @@
+package config
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+// loadJSON writes src as config.json in a temporary model dir and loads it
+func loadJSON(t *testing.T, src string) *Config {
+    t.Helper()
+    dir := t.TempDir()
+    if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(src), 0o644); err != nil { t.Fatal(err) }
+    cfg, err := LoadConfig(dir)
+    if err != nil { t.Fatal(err) }
+    return cfg
+}
+
+func TestRopePairing(t *testing.T) {
+    tests := []struct {
+        name        string
+        src         string
+        interleaved bool
+        rotaryDim   int
+    }{
+        {"llama", `{"model_type": "llama", "hidden_size": 64, "num_attention_heads": 4}`, false, 0},
+        {"gptj", `{"model_type": "gptj", "n_embd": 64, "n_head": 4, "rotary_dim": 8}`, true, 8},
+        {"codegen", `{"model_type": "codegen", "n_embd": 64, "n_head": 4, "rotary_dim": 8}`, true, 8},
+        {"opt-in", `{"model_type": "llama", "hidden_size": 64, "num_attention_heads": 4, "rotary_emb_interleaved": true}`, true, 0},
+        {"opt-out", `{"model_type": "gptj", "n_embd": 64, "n_head": 4, "rotary_emb_interleaved": false}`, false, 0},
+    }
+    for _, tt := range tests {
+        cfg := loadJSON(t, tt.src)
+        if cfg.RopeInterleaved != tt.interleaved || cfg.RotaryDim != tt.rotaryDim {
+            t.Errorf("%s: interleaved %v, rotary dim %d; want %v, %d", tt.name, cfg.RopeInterleaved, cfg.RotaryDim, tt.interleaved, tt.rotaryDim)
+        }
+    }
+}
+
+func TestEOSTokenIDs(t *testing.T) {
+    const base = `{"hidden_size": 64, "num_attention_heads": 4`
+    tests := []struct {
+        src  string
+        id   int
+        ids  []int
+    }{
+        {base + `}`, -1, nil},
+        {base + `, "eos_token_id": 0}`, 0, []int{0}},
+        {base + `, "eos_token_id": [128001, 128008, 128009]}`, 128001, []int{128001, 128008, 128009}},
+    }
+    for _, tt := range tests {
+        cfg := loadJSON(t, tt.src)
+        if cfg.EOSTokenID != tt.id || len(cfg.EOSTokenIDs) != len(tt.ids) {
+            t.Fatalf("%s: got %d %v, want %d %v", tt.src, cfg.EOSTokenID, cfg.EOSTokenIDs, tt.id, tt.ids)
+        }
+        for i := range tt.ids {
+            if cfg.EOSTokenIDs[i] != tt.ids[i] { t.Fatalf("%s: got %v, want %v", tt.src, cfg.EOSTokenIDs, tt.ids) }
+        }
+    }
+}
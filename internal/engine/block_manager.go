@@ -42,9 +42,12 @@ func NewBlockManager(numBlocks, blockSize int) *BlockManager {
 	}
 }
 
-// CanAllocate checks if a sequence can be allocated
-func (bm *BlockManager) CanAllocate(seq *Sequence) bool {
-	numBlocks := (seq.NumTokens + bm.blockSize - 1) / bm.blockSize
+// CanAllocate checks if sequences can be allocated together
+func (bm *BlockManager) CanAllocate(seqs ...*Sequence) bool {
+	numBlocks := 0
+	for _, seq := range seqs {
+		numBlocks += (seq.NumTokens + bm.blockSize - 1) / bm.blockSize
+	}
 	return len(bm.freeBlocks) >= numBlocks
 }
 
@@ -107,20 +110,20 @@ func (bm *BlockManager) Free(seq *Sequence) {
 	seq.NumCachedTokens = 0
 }
 
-// CanAppend checks if a sequence can append a token
-func (bm *BlockManager) CanAppend(seq *Sequence) bool {
-	if len(seq.BlockTable) == 0 {
-		return len(bm.freeBlocks) > 0
-	}
-	
-	lastBlockID := seq.BlockTable[len(seq.BlockTable)-1]
-	lastBlock := bm.blocks[lastBlockID]
-	
-	if len(lastBlock.Tokens) < bm.blockSize {
-		return true
+// CanAppend checks if sequences can each append a token
+func (bm *BlockManager) CanAppend(seqs ...*Sequence) bool {
+	needed := 0
+	for _, seq := range seqs {
+		if len(seq.BlockTable) == 0 {
+			needed++
+			continue
+		}
+		lastBlockID := seq.BlockTable[len(seq.BlockTable)-1]
+		if len(bm.blocks[lastBlockID].Tokens) >= bm.blockSize {
+			needed++
+		}
 	}
-	
-	return len(bm.freeBlocks) > 0
+	return len(bm.freeBlocks) >= needed
 }
 
 // Append appends a token to a sequence
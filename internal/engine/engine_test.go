@@ -0,0 +1,264 @@
+package engine
+
+import (
+    "math"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+
+    "github.com/unixsysdev/nano-go-vllm/internal/config"
+    "github.com/unixsysdev/nano-go-vllm/internal/sampling"
+    "github.com/unixsysdev/nano-go-vllm/internal/tensor"
+)
+
+// fakeTokenizer encodes by greedy longest match over a small vocabulary;
+// its special tokens decode to no bytes
+type fakeTokenizer struct {
+    vocab   []string
+    special map[int]bool
+    eos     int
+}
+
+func (f *fakeTokenizer) Encode(text string) ([]int, error) {
+    var ids []int
+    for len(text) > 0 {
+        best := -1
+        for id, s := range f.vocab {
+            if f.special[id] || !strings.HasPrefix(text, s) { continue }
+            if best < 0 || len(s) > len(f.vocab[best]) { best = id }
+        }
+        if best < 0 { text = text[1:]; continue }
+        ids = append(ids, best)
+        text = text[len(f.vocab[best]):]
+    }
+    return ids, nil
+}
+
+func (f *fakeTokenizer) Decode(ids []int) (string, error) {
+    var b strings.Builder
+    for _, id := range ids {
+        if !f.special[id] { b.WriteString(f.vocab[id]) }
+    }
+    return b.String(), nil
+}
+
+func (f *fakeTokenizer) GetEOS() int { return f.eos }
+
+func (f *fakeTokenizer) TokenBytes() ([][]byte, error) {
+    out := make([][]byte, len(f.vocab))
+    for id, s := range f.vocab {
+        if !f.special[id] { out[id] = []byte(s) }
+    }
+    return out, nil
+}
+
+// fakeModel returns the same logits for every position and records which
+// KV caches the engine uses, resets and frees
+type fakeModel struct {
+    cfg    *config.Config
+    logits []float32
+    active int
+    cached map[int]int // sequence id -> cached tokens
+    used   []int       // active sequence of every forward
+    freed  []int
+}
+
+func newFakeModel(cfg *config.Config, logits []float32) *fakeModel {
+    return &fakeModel{cfg: cfg, logits: logits, cached: map[int]int{}}
+}
+
+func (m *fakeModel) Forward(inputIDs, positions *tensor.Tensor, rows []int) (*tensor.Tensor, error) {
+    logits, _, err := m.ForwardHidden(inputIDs, positions, rows)
+    return logits, err
+}
+
+func (m *fakeModel) ForwardHidden(inputIDs, positions *tensor.Tensor, rows []int) (*tensor.Tensor, *tensor.Tensor, error) {
+    T := inputIDs.Shape()[0]
+    m.cached[m.active] += T
+    m.used = append(m.used, m.active)
+    n := max(len(rows), 1)
+    logits, err := tensor.NewTensor([]int{n, len(m.logits)}, tensor.Float32, tensor.CPU)
+    if err != nil { return nil, nil, err }
+    data := logits.Data().Data().([]float32)
+    for r := 0; r < n; r++ { copy(data[r*len(m.logits):], m.logits) }
+    hidden, err := tensor.NewTensor([]int{T, 2}, tensor.Float32, tensor.CPU)
+    if err != nil { return nil, nil, err }
+    return logits, hidden, nil
+}
+
+func (m *fakeModel) Lookahead(candidates []int) (*tensor.Tensor, error) {
+    return tensor.NewTensor([]int{len(candidates), 2}, tensor.Float32, tensor.CPU)
+}
+
+func (m *fakeModel) ResetKVCache()          { m.cached[m.active] = 0 }
+func (m *fakeModel) UseKVCache(id int)      { m.active = id }
+func (m *fakeModel) FreeKVCache(id int)     { delete(m.cached, id); m.freed = append(m.freed, id) }
+func (m *fakeModel) Config() *config.Config { return m.cfg }
+
+// testVocab has EOS-like specials at 2 and 5; token 0 is a real "!"
+var testVocab = []string{"!", "a", "</s>", "b", "c", "<|eot|>"}
+
+// newTestEngine loads a tiny config.json with the extra fields and wires an
+// engine around the fake tokenizer and a fake model that always returns
+// logits
+func newTestEngine(t *testing.T, extra string, eos int, logits []float32, opts ...config.Option) (*LLMEngine, *fakeModel) {
+    t.Helper()
+    dir := t.TempDir()
+    src := `{"vocab_size": 6, "hidden_size": 4, "num_attention_heads": 1, "num_hidden_layers": 1, "max_position_embeddings": 64`
+    if extra != "" { src += ", " + extra }
+    if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(src+"}"), 0o644); err != nil { t.Fatal(err) }
+    cfg, err := config.LoadConfig(dir, opts...)
+    if err != nil { t.Fatal(err) }
+    tok := &fakeTokenizer{vocab: testVocab, special: map[int]bool{2: true, 5: true}, eos: eos}
+    m := newFakeModel(cfg, logits)
+    e, err := newEngine(cfg, tok, m)
+    if err != nil { t.Fatal(err) }
+    return e, m
+}
+
+// runToEnd steps the engine until every request finishes and returns the
+// completion of each sequence id
+func runToEnd(t *testing.T, e *LLMEngine) map[int][]int {
+    t.Helper()
+    out := map[int][]int{}
+    for steps := 0; !e.IsFinished(); steps++ {
+        if steps > 100 { t.Fatal("engine did not finish") }
+        step, err := e.Step()
+        if err != nil { t.Fatal(err) }
+        for _, o := range step {
+            if o.Finished { out[o.SeqID] = o.TokenIDs }
+        }
+    }
+    return out
+}
+
+// onlyResult returns the completion of the single finished sequence
+func onlyResult(t *testing.T, out map[int][]int) []int {
+    t.Helper()
+    if len(out) != 1 { t.Fatalf("got %d finished sequences, want 1", len(out)) }
+    for _, toks := range out { return toks }
+    return nil
+}
+
+func TestConstraintDeadEndEmitsModelEOS(t *testing.T) {
+    flat := make([]float32, len(testVocab))
+    e, _ := newTestEngine(t, `"eos_token_id": [2, 5]`, -1, flat)
+    // the choice allows only "b", the whitelist only "a": nothing is left
+    params := &sampling.SamplingParams{MaxTokens: 4, Choices: []string{"b"}, AllowedTokenIDs: []int{1}}
+    if err := e.AddRequest("a", params); err != nil { t.Fatal(err) }
+    if got := onlyResult(t, runToEnd(t, e)); len(got) != 1 || got[0] != 2 {
+        t.Errorf("dead end emitted %v, want the model's EOS [2]", got)
+    }
+}
+
+func TestGrammarVocabKeepsTokenZero(t *testing.T) {
+    flat := make([]float32, len(testVocab))
+    e, _ := newTestEngine(t, `"eos_token_id": 2`, -1, flat)
+    if err := e.AddRequest("a", &sampling.SamplingParams{MaxTokens: 4, Choices: []string{"!"}}); err != nil { t.Fatal(err) }
+    if got := onlyResult(t, runToEnd(t, e)); len(got) != 1 || got[0] != 0 {
+        t.Errorf("got %v, want [0] (\"!\")", got)
+    }
+}
+
+func TestStopsOnAnyEOS(t *testing.T) {
+    logits := make([]float32, len(testVocab))
+    logits[5] = 50
+    e, _ := newTestEngine(t, `"eos_token_id": [2, 5]`, -1, logits)
+    if err := e.AddRequest("a", &sampling.SamplingParams{MaxTokens: 4}); err != nil { t.Fatal(err) }
+    if got := onlyResult(t, runToEnd(t, e)); len(got) != 1 || got[0] != 5 {
+        t.Errorf("got %v, want to stop on the second EOS id [5]", got)
+    }
+}
+
+func TestEOSFallsBackToTokenizer(t *testing.T) {
+    e, _ := newTestEngine(t, "", 5, make([]float32, len(testVocab)))
+    if e.config.EOSTokenID != 5 || len(e.config.EOSTokenIDs) != 1 {
+        t.Errorf("EOS %d %v, want the tokenizer's 5", e.config.EOSTokenID, e.config.EOSTokenIDs)
+    }
+    // with no EOS anywhere, constrained decoding is refused
+    e, _ = newTestEngine(t, "", -1, make([]float32, len(testVocab)))
+    if err := e.AddRequest("a", &sampling.SamplingParams{Choices: []string{"b"}}); err == nil {
+        t.Error("constraint accepted without an EOS id")
+    }
+}
+
+// TestMirostatSurvivesPreemption: μ lives on the sequence, so a sequence
+// that is preempted and prefilled again carries on from its μ. With two
+// equally likely tokens every step has 1 bit of surprise and moves μ by
+// 0.1*(5-1) from 2τ = 10, whatever the scheduling.
+func TestMirostatSurvivesPreemption(t *testing.T) {
+    logits := []float32{-1e30, 0, -1e30, 0, -1e30, -1e30}
+    e, m := newTestEngine(t, "", -1, logits, config.WithKVCacheBlockSize(1), config.WithNumKVCacheBlocks(6))
+    params := &sampling.SamplingParams{MaxTokens: 5, Mirostat: 2, MirostatTau: 5, MirostatEta: 0.1}
+    var seqs []*Sequence
+    for _, prompt := range []string{"a", "b"} {
+        if err := e.AddRequest(prompt, params); err != nil { t.Fatal(err) }
+        seqs = append(seqs, e.scheduler.waitingQueue.Back().Value.(*Sequence))
+    }
+    out := runToEnd(t, e)
+
+    // every sequence is freed once when it finishes; more means preemption
+    if len(m.freed) <= len(seqs) { t.Fatalf("no preemption: freed %v", m.freed) }
+    for _, s := range seqs {
+        if n := len(out[s.ID]); n != params.MaxTokens { t.Fatalf("sequence %d: %d tokens, want %d", s.ID, n, params.MaxTokens) }
+        want := 10 + 0.4*float64(params.MaxTokens)
+        if mu := s.SamplerState.MirostatMu; math.Abs(float64(mu)-want) > 1e-4 {
+            t.Errorf("sequence %d: μ = %g, want %g", s.ID, mu, want)
+        }
+    }
+}
+
+// TestBadWordsAfterPromptPrefix: the bad word "aa" bans "a" only after an
+// "a" has been generated; the "a" ending the prompt does not count
+func TestBadWordsAfterPromptPrefix(t *testing.T) {
+    logits := []float32{0, 10, 0, 9, 0, 0}
+    e, _ := newTestEngine(t, "", -1, logits)
+    params := &sampling.SamplingParams{MaxTokens: 4, TopK: 1, BadWords: []string{"aa"}}
+    if err := e.AddRequest("ba", params); err != nil { t.Fatal(err) }
+    if got := onlyResult(t, runToEnd(t, e)); !reflect.DeepEqual(got, []int{1, 3, 1, 3}) {
+        t.Errorf("got %v, want [1 3 1 3] (a b a b)", got)
+    }
+}
+
+// TestDRYBreakerIDs: every token of every breaker's encoding breaks a repeat
+func TestDRYBreakerIDs(t *testing.T) {
+    e, _ := newTestEngine(t, "", -1, make([]float32, len(testVocab)))
+    ids, err := e.dryBreakerIDs(&sampling.SamplingParams{DRYSequenceBreakers: []string{"b", "ac", "!"}})
+    if err != nil { t.Fatal(err) }
+    if want := []int{3, 1, 4, 0}; !reflect.DeepEqual(ids, want) { t.Errorf("breakers %v, want %v", ids, want) }
+}
+
+// TestGuidancePairKVCaches: the unconditional branch runs right before its
+// sequence on every step, and its KV cache is freed whenever the
+// sequence's is, through preemption and finishing alike
+func TestGuidancePairKVCaches(t *testing.T) {
+    logits := []float32{-1e30, 0, -1e30, 0, -1e30, -1e30}
+    e, m := newTestEngine(t, "", -1, logits, config.WithKVCacheBlockSize(1), config.WithNumKVCacheBlocks(8))
+    params := &sampling.SamplingParams{MaxTokens: 4, GuidanceScale: 2}
+    branch := map[int]int{} // sequence id -> branch id
+    for _, prompt := range []string{"a", "b"} {
+        if err := e.AddRequest(prompt, params); err != nil { t.Fatal(err) }
+        s := e.scheduler.waitingQueue.Back().Value.(*Sequence)
+        branch[s.ID] = s.Guidance.ID
+    }
+    out := runToEnd(t, e)
+    for id := range branch {
+        if n := len(out[id]); n != params.MaxTokens { t.Fatalf("sequence %d: %d tokens, want %d", id, n, params.MaxTokens) }
+    }
+
+    for i, id := range m.used {
+        if g, ok := branch[id]; ok && (i == 0 || m.used[i-1] != g) { t.Fatalf("forward %d of sequence %d without its branch: %v", i, id, m.used) }
+        if _, ok := branch[id]; !ok && (i+1 == len(m.used) || branch[m.used[i+1]] != id) {
+            t.Fatalf("forward %d of branch %d not followed by its sequence: %v", i, id, m.used)
+        }
+    }
+    // every sequence is freed once when it finishes; more means preemption
+    if len(m.freed) <= 2*len(branch) { t.Fatalf("no preemption: freed %v", m.freed) }
+    count := map[int]int{}
+    for _, id := range m.freed { count[id]++ }
+    for id, g := range branch {
+        if count[id] != count[g] { t.Errorf("sequence %d freed %d times, its branch %d", id, count[id], count[g]) }
+    }
+}
@@ -5,6 +5,7 @@ import (
     "sync"
 
     "github.com/unixsysdev/nano-go-vllm/internal/config"
+    "github.com/unixsysdev/nano-go-vllm/internal/grammar"
     "github.com/unixsysdev/nano-go-vllm/internal/models"
     "github.com/unixsysdev/nano-go-vllm/internal/sampling"
     "github.com/unixsysdev/nano-go-vllm/pkg/tokenizer"
@@ -13,10 +14,11 @@ import (
 // LLMEngine represents the LLM inference engine
 type LLMEngine struct {
 	config      *config.Config
-    model       *models.QwenModel
+    model       models.CausalLM
 	tokenizer   tokenizer.Tokenizer
 	scheduler   *Scheduler
 	modelRunner *ModelRunner
+	vocab       *grammar.Vocab // token trie for constrained decoding, built on first use
 	mu          sync.Mutex
 }
 
@@ -35,10 +37,21 @@ func NewLLMEngine(modelPath string, opts ...config.Option) (*LLMEngine, error) {
 	}
 
 	// Initialize model
-    model, err := models.NewQwenModel(cfg)
+    model, err := models.New(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create model: %v", err)
 	}
+	return newEngine(cfg, tok, model)
+}
+
+// newEngine wires an engine around a loaded config, tokenizer and model
+func newEngine(cfg *config.Config, tok tokenizer.Tokenizer, model models.CausalLM) (*LLMEngine, error) {
+	// A config.json without eos_token_id falls back to the tokenizer's
+	if cfg.EOSTokenID < 0 {
+		if id := tok.GetEOS(); id >= 0 {
+			cfg.EOSTokenID, cfg.EOSTokenIDs = id, []int{id}
+		}
+	}
 
 	// Initialize scheduler
 	scheduler := NewScheduler(cfg)
@@ -71,6 +84,27 @@ func (e *LLMEngine) AddRequest(prompt string, params *sampling.SamplingParams) e
 
 	// Create sequence
 	seq := NewSequence(tokenIDs, params)
+	constraint, err := e.newConstraint(params)
+	if err != nil {
+		return err
+	}
+	seq.SamplerState.Constraint = constraint
+	seq.SamplerState.EOSTokenID = e.config.EOSTokenID
+	if len(params.BadWords) > 0 {
+		if seq.BadWordsIDs, err = e.badWordsIDs(params); err != nil {
+			return err
+		}
+	}
+	if params.GuidanceScale != 0 && params.GuidanceScale != 1 {
+		if seq.Guidance, err = e.guidanceBranch(params, tokenIDs); err != nil {
+			return err
+		}
+	}
+	if params.DRYMultiplier > 0 && len(params.DRYSequenceBreakerIDs) == 0 {
+		if seq.DRYBreakerIDs, err = e.dryBreakerIDs(params); err != nil {
+			return err
+		}
+	}
 
 	// Add to scheduler
 	e.scheduler.Add(seq)
@@ -78,6 +112,135 @@ func (e *LLMEngine) AddRequest(prompt string, params *sampling.SamplingParams) e
 	return nil
 }
 
+// newConstraint compiles the structured-output params of a request, if any
+func (e *LLMEngine) newConstraint(params *sampling.SamplingParams) (sampling.Constraint, error) {
+	set := 0
+	for _, on := range []bool{params.Grammar != "", params.JSONSchema != "", params.JSONObject, params.Regex != "", len(params.Choices) > 0} {
+		if on {
+			set++
+		}
+	}
+	if set == 0 {
+		return nil, nil
+	}
+	if set > 1 {
+		return nil, fmt.Errorf("only one of Grammar, JSONSchema, JSONObject, Regex and Choices may be set")
+	}
+	// Completed and dead-ended outputs end with EOS
+	if e.config.EOSTokenID < 0 {
+		return nil, fmt.Errorf("constrained decoding needs the model's eos_token_id")
+	}
+	vocab, err := e.grammarVocab()
+	if err != nil {
+		return nil, err
+	}
+
+	// Regular languages compile to a DFA
+	if params.Regex != "" || len(params.Choices) > 0 {
+		var dfa *grammar.DFA
+		if params.Regex != "" {
+			dfa, err = grammar.CompileRegex(params.Regex)
+		} else {
+			dfa, err = grammar.CompileChoices(params.Choices)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return grammar.NewRegexMatcher(dfa, vocab), nil
+	}
+
+	// Everything else goes through a GBNF grammar
+	src := params.Grammar
+	if params.JSONSchema != "" {
+		if src, err = grammar.FromJSONSchema([]byte(params.JSONSchema)); err != nil {
+			return nil, err
+		}
+	} else if params.JSONObject {
+		src = grammar.JSONObjectGrammar()
+	}
+	g, err := grammar.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return grammar.NewMatcher(g, vocab), nil
+}
+
+// badWordsIDs tokenizes each bad word both as written and with a leading
+// space, since BPE vocabularies encode word-initial tokens differently
+func (e *LLMEngine) badWordsIDs(params *sampling.SamplingParams) ([][]int, error) {
+	out := append([][]int(nil), params.BadWordsIDs...)
+	seen := make(map[string]bool)
+	for _, w := range params.BadWords {
+		if w == "" {
+			continue
+		}
+		for _, v := range []string{w, " " + w} {
+			ids, err := e.tokenizer.Encode(v)
+			if err != nil {
+				return nil, fmt.Errorf("tokenize bad word %q: %v", w, err)
+			}
+			key := fmt.Sprint(ids)
+			if len(ids) == 0 || seen[key] {
+				continue
+			}
+			seen[key] = true
+			out = append(out, ids)
+		}
+	}
+	return out, nil
+}
+
+// guidanceBranch creates the unconditional sequence for classifier-free
+// guidance. Without a negative prompt it starts from the last prompt token.
+func (e *LLMEngine) guidanceBranch(params *sampling.SamplingParams, promptIDs []int) (*Sequence, error) {
+	ids := promptIDs[len(promptIDs)-1:]
+	if params.NegativePrompt != "" {
+		var err error
+		if ids, err = e.tokenizer.Encode(params.NegativePrompt); err != nil {
+			return nil, fmt.Errorf("tokenize negative prompt: %v", err)
+		}
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("negative prompt encodes to no tokens")
+		}
+	}
+	return NewSequence(ids, &sampling.SamplingParams{MaxTokens: params.MaxTokens}), nil
+}
+
+// dryBreakerIDs tokenizes the DRY sequence breakers (or the defaults). Every
+// token of a breaker's encoding breaks a repeat.
+func (e *LLMEngine) dryBreakerIDs(params *sampling.SamplingParams) ([]int, error) {
+	breakers := params.DRYSequenceBreakers
+	if breakers == nil {
+		breakers = sampling.DefaultDRYSequenceBreakers
+	}
+	var out []int
+	for _, b := range breakers {
+		ids, err := e.tokenizer.Encode(b)
+		if err != nil {
+			return nil, fmt.Errorf("tokenize DRY sequence breaker %q: %v", b, err)
+		}
+		out = append(out, ids...)
+	}
+	return out, nil
+}
+
+// grammarVocab returns the token trie, building it from the tokenizer once
+func (e *LLMEngine) grammarVocab() (*grammar.Vocab, error) {
+	if e.vocab != nil {
+		return e.vocab, nil
+	}
+	vp, ok := e.tokenizer.(tokenizer.VocabProvider)
+	if !ok {
+		return nil, fmt.Errorf("tokenizer does not expose its vocabulary; constrained decoding unavailable")
+	}
+	tokens, err := vp.TokenBytes()
+	if err != nil {
+		return nil, fmt.Errorf("read vocabulary: %v", err)
+	}
+	e.vocab = grammar.NewVocab(tokens, e.config.EOSTokenID)
+	return e.vocab, nil
+}
+
 // Step performs one inference step
 func (e *LLMEngine) Step() ([]*SequenceOutput, error) {
 	e.mu.Lock()
@@ -85,6 +248,11 @@ func (e *LLMEngine) Step() ([]*SequenceOutput, error) {
 
 	// Schedule sequences
 	seqs, isPrefill := e.scheduler.Schedule()
+	// Preemption exists to release memory: drop the model KV caches too,
+	// they are rebuilt when the sequences are prefilled again
+	for _, g := range e.scheduler.Preempted() {
+		e.model.FreeKVCache(g.ID)
+	}
 	if len(seqs) == 0 {
 		return nil, nil
 	}
@@ -97,19 +265,38 @@ func (e *LLMEngine) Step() ([]*SequenceOutput, error) {
 
 	// Post-process sequences
 	finished := e.scheduler.PostProcess(seqs, tokenIDs)
+	for i, seq := range seqs {
+		if finished[i] {
+			for _, g := range seq.group() {
+				e.model.FreeKVCache(g.ID)
+			}
+		}
+	}
 	
 	outputs := make([]*SequenceOutput, len(seqs))
 	for i, seq := range seqs {
 		outputs[i] = &SequenceOutput{
-			SeqID:    seq.ID,
-			TokenIDs: seq.CompletionTokenIDs(),
-			Finished: finished[i],
+			SeqID:          seq.ID,
+			TokenIDs:       seq.CompletionTokenIDs(),
+			Finished:       finished[i],
+			PromptLogprobs: seq.PromptLogprobs,
 		}
 	}
 
 	return outputs, nil
 }
 
+// ExpertLoad returns per-layer expert token counts for MoE models (nil
+// entries for dense layers, nil for models without experts)
+func (e *LLMEngine) ExpertLoad() [][]int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if r, ok := e.model.(models.ExpertLoadReporter); ok {
+		return r.ExpertLoad()
+	}
+	return nil
+}
+
 // IsFinished checks if all requests are finished
 func (e *LLMEngine) IsFinished() bool {
 	e.mu.Lock()
@@ -141,8 +328,9 @@ func (e *LLMEngine) Generate(prompts []string, params []*sampling.SamplingParams
 					return nil, fmt.Errorf("decoding failed: %v", err)
 				}
 				outputs[output.SeqID] = &GenerationOutput{
-					Text:     text,
-					TokenIDs: output.TokenIDs,
+					Text:           text,
+					TokenIDs:       output.TokenIDs,
+					PromptLogprobs: output.PromptLogprobs,
 				}
 			}
 		}
@@ -168,10 +356,14 @@ type SequenceOutput struct {
 	SeqID    int
 	TokenIDs []int
 	Finished bool
+	// PromptLogprobs[i] is the log-probability of prompt token i+1 (only
+	// with SamplingParams.PromptLogprobs)
+	PromptLogprobs []float32
 }
 
 // GenerationOutput represents final generation output
 type GenerationOutput struct {
-	Text     string
-	TokenIDs []int
+	Text           string
+	TokenIDs       []int
+	PromptLogprobs []float32
 }
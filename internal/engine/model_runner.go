@@ -14,12 +14,12 @@ import (
 // ModelRunner runs the model inference
 type ModelRunner struct {
     config  *config.Config
-    model   *models.QwenModel
+    model   models.CausalLM
     sampler *sampling.Sampler
 }
 
 // NewModelRunner creates a new model runner
-func NewModelRunner(cfg *config.Config, model *models.QwenModel) (*ModelRunner, error) {
+func NewModelRunner(cfg *config.Config, model models.CausalLM) (*ModelRunner, error) {
 	return &ModelRunner{
 		config:  cfg,
 		model:   model,
@@ -30,50 +30,124 @@ func NewModelRunner(cfg *config.Config, model *models.QwenModel) (*ModelRunner,
 // Run runs the model on sequences
 func (mr *ModelRunner) Run(seqs []*Sequence, isPrefill bool) ([]int, error) {
     if len(seqs) == 0 { return nil, nil }
-    // Process each sequence independently (simple batching). Shared model state (KV) is per run.
+    // Forward each sequence independently on its own KV cache (simple
+    // batching), then sample the whole batch in one call.
+    // Contrastive rows are sampled right after their own forward, since the
+    // lookahead needs that sequence's KV cache.
     out := make([]int, len(seqs))
+    var batch []float32 // last-position logits of the batched rows
+    var rows []int      // seqs index of each batched row
+    vocab := 0
     for i, s := range seqs {
-        // Prepare input for this sequence
-        inputIDs, positions, err := mr.prepareInput([]*Sequence{s}, isPrefill)
-        if err != nil { return nil, fmt.Errorf("prepare input (seq %d): %v", i, err) }
-        // Reset caches at start of prefill for this seq
-        if isPrefill && s.NumCachedTokens == 0 { mr.model.ResetKVCache() }
-        // Forward
-        logitsAll, err := mr.model.Forward(inputIDs, positions)
-        if err != nil { return nil, fmt.Errorf("model forward (seq %d): %v", i, err) }
-        shape := logitsAll.Shape()
-        if len(shape) != 2 { return nil, fmt.Errorf("logits must be 2D") }
-        T, vocab := shape[0], shape[1]
-        last := make([]float32, vocab)
-        data := logitsAll.Data().Data().([]float32)
-        copy(last, data[(T-1)*vocab:T*vocab])
-        lastTensor, err := tensor.NewTensor([]int{1, vocab}, tensor.Float32, tensor.CPU)
-        if err != nil { return nil, err }
-        copy(lastTensor.Data().Data().([]float32), last)
-        // Sample one
-        temps := []float32{s.Temperature}
-        prev := [][]int{s.CompletionTokenIDs()}
-        params := []*sampling.SamplingParams{{
-            TopP: s.TopP, TopK: s.TopK,
-            RepetitionPenalty: s.RepetitionPenalty,
-            PresencePenalty: s.PresencePenalty,
-            FrequencyPenalty: s.FrequencyPenalty,
-        }}
-        toks, err := mr.sampler.Sample(lastTensor, temps, prev, params)
-        if err != nil { return nil, fmt.Errorf("sampling (seq %d): %v", i, err) }
-        out[i] = toks[0]
+        // Classifier-free guidance: run the unconditional branch first, so
+        // the conditional sequence's KV cache is left active
+        var uncond []float32
+        if g := s.Guidance; g != nil {
+            var err error
+            if uncond, _, err = mr.forward(g, isPrefill); err != nil { return nil, fmt.Errorf("guidance (seq %d): %v", i, err) }
+        }
+        last, hidden, err := mr.forward(s, isPrefill)
+        if err != nil { return nil, fmt.Errorf("seq %d: %v", i, err) }
+        vocab = len(last)
+        if uncond != nil { sampling.ApplyGuidance(last, uncond, s.GuidanceScale) }
+
+        if s.Contrastive() {
+            s.recordHidden(hidden, isPrefill)
+            if s.SamplerState.Lookahead == nil {
+                s.SamplerState.Lookahead = &contrastiveLookahead{model: mr.model, seq: s}
+            }
+            row, err := tensor.NewTensor([]int{1, vocab}, tensor.Float32, tensor.CPU)
+            if err != nil { return nil, err }
+            copy(row.Data().Data().([]float32), last)
+            toks, err := mr.sampler.Sample(row, []float32{s.Temperature}, [][]int{s.TokenIDs},
+                []*sampling.SamplingParams{samplingParams(s)}, []*sampling.State{s.SamplerState})
+            if err != nil { return nil, fmt.Errorf("sampling (seq %d): %v", i, err) }
+            out[i] = toks[0]
+            continue
+        }
+        // Keep only the last position's logits
+        batch = append(batch, last...)
+        rows = append(rows, i)
     }
+    if len(rows) == 0 { return out, nil }
+
+    logits, err := tensor.NewTensor([]int{len(rows), vocab}, tensor.Float32, tensor.CPU)
+    if err != nil { return nil, err }
+    copy(logits.Data().Data().([]float32), batch)
+    temps := make([]float32, len(rows))
+    prev := make([][]int, len(rows))
+    params := make([]*sampling.SamplingParams, len(rows))
+    states := make([]*sampling.State, len(rows))
+    for r, i := range rows {
+        s := seqs[i]
+        temps[r] = s.Temperature
+        prev[r] = s.TokenIDs
+        params[r] = samplingParams(s)
+        states[r] = s.SamplerState
+    }
+    toks, err := mr.sampler.Sample(logits, temps, prev, params, states)
+    if err != nil { return nil, fmt.Errorf("sampling: %v", err) }
+    for r, i := range rows { out[i] = toks[r] }
     return out, nil
 }
 
+// forward runs one sequence on its own KV cache and returns the logits of
+// its last position and the last-layer hidden states. The first prefill of
+// a sequence that wants prompt logprobs also records them.
+func (mr *ModelRunner) forward(s *Sequence, isPrefill bool) ([]float32, *tensor.Tensor, error) {
+    inputIDs, positions, err := mr.prepareInput([]*Sequence{s}, isPrefill)
+    if err != nil { return nil, nil, fmt.Errorf("prepare input: %v", err) }
+    mr.model.UseKVCache(s.ID)
+    // The model cache is private to the sequence, so prefill (first run or
+    // after preemption) always recomputes it from scratch
+    if isPrefill { mr.model.ResetKVCache() }
+    // Only the last position is sampled, so only its logits are computed
+    // unless the prompt logprobs need every position
+    var rows []int
+    promptLogprobs := isPrefill && s.WantPromptLogprobs && s.PromptLogprobs == nil
+    if promptLogprobs { rows = models.AllRows(s.NumTokens) }
+    logits, hidden, err := mr.model.ForwardHidden(inputIDs, positions, rows)
+    if err != nil { return nil, nil, fmt.Errorf("model forward: %v", err) }
+    shape := logits.Shape()
+    if n := max(len(rows), 1); len(shape) != 2 || shape[0] != n {
+        return nil, nil, fmt.Errorf("expected logits [%d, vocab], got %v", n, shape)
+    }
+    data := logits.Data().Data().([]float32)
+    if promptLogprobs {
+        vocab, P := shape[1], s.NumPromptTokens
+        s.PromptLogprobs = sampling.TokenLogprobs(data[:(P-1)*vocab], vocab, s.TokenIDs[1:P])
+        data = data[(len(rows)-1)*vocab:]
+    }
+    return data, hidden, nil
+}
+
+// samplingParams rebuilds the per-step sampling params of a sequence
+func samplingParams(s *Sequence) *sampling.SamplingParams {
+    return &sampling.SamplingParams{
+        TopP: s.TopP, TopK: s.TopK,
+        RepetitionPenalty: s.RepetitionPenalty,
+        PresencePenalty: s.PresencePenalty,
+        FrequencyPenalty: s.FrequencyPenalty,
+        PenaltyIncludePrompt: s.PenaltyIncludePrompt, PenaltyLastN: s.PenaltyLastN,
+        MinP: s.MinP, TypicalP: s.TypicalP,
+        EpsilonCutoff: s.EpsilonCutoff, EtaCutoff: s.EtaCutoff,
+        Mirostat: s.Mirostat, MirostatTau: s.MirostatTau, MirostatEta: s.MirostatEta,
+        LogitBias: s.LogitBias, AllowedTokenIDs: s.AllowedTokenIDs,
+        BadWordsIDs: s.BadWordsIDs,
+        DRYMultiplier: s.DRYMultiplier, DRYBase: s.DRYBase,
+        DRYAllowedLength: s.DRYAllowedLength, DRYSequenceBreakerIDs: s.DRYBreakerIDs,
+        PenaltyAlpha: s.PenaltyAlpha,
+        Watermark: s.Watermark,
+    }
+}
+
 // prepareInput prepares input tensors
 func (mr *ModelRunner) prepareInput(seqs []*Sequence, isPrefill bool) (*tensor.Tensor, *tensor.Tensor, error) {
     seq := seqs[0]
     var tokenIDs []int64
     var positions []int64
     if isPrefill {
-        start := seq.NumCachedTokens
-        for i := start; i < seq.NumTokens; i++ {
+        for i := 0; i < seq.NumTokens; i++ {
             tokenIDs = append(tokenIDs, int64(seq.TokenIDs[i]))
             positions = append(positions, int64(i))
         }
@@ -91,3 +165,37 @@ func (mr *ModelRunner) prepareInput(seqs []*Sequence, isPrefill bool) (*tensor.T
     for i, p := range positions { densePos.Set(i, p) }
     return inputIDs, posT, nil
 }
+
+// contrastiveLookahead serves a sequence's hidden states to contrastive search
+type contrastiveLookahead struct {
+    model models.CausalLM
+    seq   *Sequence
+}
+
+func (l *contrastiveLookahead) Context() [][]float32 { return l.seq.Hidden }
+
+func (l *contrastiveLookahead) Candidates(ids []int) ([][]float32, error) {
+    h, err := l.model.Lookahead(ids)
+    if err != nil { return nil, err }
+    return splitRows(h), nil
+}
+
+// recordHidden appends the hidden states [T, hidden] of a forward pass,
+// starting over when the sequence was prefilled from scratch
+func (s *Sequence) recordHidden(hidden *tensor.Tensor, reset bool) {
+    if reset { s.Hidden = nil }
+    s.Hidden = append(s.Hidden, splitRows(hidden)...)
+}
+
+// splitRows copies a [N, D] tensor into N separate rows
+func splitRows(t *tensor.Tensor) [][]float32 {
+    shape := t.Shape()
+    n, d := shape[0], shape[1]
+    data := t.Data().Data().([]float32)
+    rows := make([][]float32, n)
+    for i := range rows {
+        rows[i] = make([]float32, d)
+        copy(rows[i], data[i*d:(i+1)*d])
+    }
+    return rows
+}
@@ -0,0 +1,53 @@
+package engine
+
+import (
+    "encoding/json"
+    "math"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "github.com/unixsysdev/nano-go-vllm/internal/config"
+    "github.com/unixsysdev/nano-go-vllm/internal/models"
+    "github.com/unixsysdev/nano-go-vllm/internal/sampling"
+)
+
+// TestPromptLogprobs prefills the tiny Llama checkpoint of internal/models
+// and checks the prompt logprobs against its reference logits
+func TestPromptLogprobs(t *testing.T) {
+    dir := filepath.Join("..", "models", "testdata", "llama")
+    b, err := os.ReadFile(filepath.Join(dir, "logits.json"))
+    if err != nil { t.Fatal(err) }
+    var ref struct {
+        InputIDs []int       `json:"input_ids"`
+        Logits   [][]float64 `json:"logits"`
+    }
+    if err := json.Unmarshal(b, &ref); err != nil { t.Fatal(err) }
+    cfg, err := config.LoadConfig(dir)
+    if err != nil { t.Fatal(err) }
+    m, err := models.New(cfg)
+    if err != nil { t.Fatal(err) }
+    mr, err := NewModelRunner(cfg, m)
+    if err != nil { t.Fatal(err) }
+
+    s := NewSequence(ref.InputIDs, &sampling.SamplingParams{TopK: 1, MaxTokens: 1, PromptLogprobs: true})
+    toks, err := mr.Run([]*Sequence{s}, true)
+    if err != nil { t.Fatal(err) }
+    if len(s.PromptLogprobs) != len(ref.InputIDs)-1 {
+        t.Fatalf("got %d prompt logprobs, want %d", len(s.PromptLogprobs), len(ref.InputIDs)-1)
+    }
+    for i, got := range s.PromptLogprobs {
+        row := ref.Logits[i]
+        var sum float64
+        for _, v := range row { sum += math.Exp(v) }
+        want := row[ref.InputIDs[i+1]] - math.Log(sum)
+        if math.Abs(float64(got)-want) > 1e-4 { t.Errorf("token %d: got %g, want %g", i+1, got, want) }
+    }
+
+    // the sampled token still comes from the last position
+    last, best := ref.Logits[len(ref.Logits)-1], 0
+    for id, v := range last {
+        if v > last[best] { best = id }
+    }
+    if toks[0] != best { t.Errorf("sampled %d, want argmax %d", toks[0], best) }
+}
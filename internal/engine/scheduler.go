@@ -10,10 +10,11 @@ import (
 type Scheduler struct {
 	maxNumSeqs           int
 	maxNumBatchedTokens  int
-	eosTokenID           int
+	eosTokenIDs          []int
 	blockManager         *BlockManager
 	waitingQueue         *list.List
 	runningQueue         *list.List
+	preempted            []*Sequence // since the last Preempted call
 }
 
 // NewScheduler creates a new scheduler
@@ -21,7 +22,7 @@ func NewScheduler(config *config.Config) *Scheduler {
 	return &Scheduler{
 		maxNumSeqs:          config.MaxNumSeqs,
 		maxNumBatchedTokens: config.MaxNumBatchedTokens,
-		eosTokenID:          config.EOSTokenID,
+		eosTokenIDs:         config.EOSTokenIDs,
 		blockManager:        NewBlockManager(config.NumKVCacheBlocks, config.KVCacheBlockSize),
 		waitingQueue:        list.New(),
 		runningQueue:        list.New(),
@@ -44,8 +45,10 @@ func (s *Scheduler) Schedule() ([]*Sequence, bool) {
 		
 		if s.canSchedule(seq, len(scheduled)) {
 			s.waitingQueue.Remove(elem)
-			s.blockManager.Allocate(seq)
-			seq.Status = SequenceStatusRunning
+			for _, g := range seq.group() {
+				s.blockManager.Allocate(g)
+				g.Status = SequenceStatusRunning
+			}
 			s.runningQueue.PushBack(seq)
 			scheduled = append(scheduled, seq)
 		} else {
@@ -61,15 +64,20 @@ func (s *Scheduler) Schedule() ([]*Sequence, bool) {
 	for elem := s.runningQueue.Front(); elem != nil && len(scheduled) < s.maxNumSeqs; {
 		seq := elem.Value.(*Sequence)
 		
-		if s.blockManager.CanAppend(seq) {
-			s.blockManager.Append(seq)
+		if s.blockManager.CanAppend(seq.group()...) {
+			for _, g := range seq.group() {
+				s.blockManager.Append(g)
+			}
 			scheduled = append(scheduled, seq)
 			elem = elem.Next()
 		} else {
-			// Preempt this sequence
+			// Preempt this sequence (and its guidance branch)
 			s.runningQueue.Remove(elem)
-			s.blockManager.Free(seq)
-			seq.Status = SequenceStatusWaiting
+			for _, g := range seq.group() {
+				s.blockManager.Free(g)
+				g.Status = SequenceStatusWaiting
+				s.preempted = append(s.preempted, g)
+			}
 			s.waitingQueue.PushFront(seq)
 			elem = s.runningQueue.Front()
 		}
@@ -78,18 +86,35 @@ func (s *Scheduler) Schedule() ([]*Sequence, bool) {
 	return scheduled, false // decode
 }
 
+// Preempted returns the sequences (guidance branches included) preempted
+// since the last call, so the caller can free their model KV caches
+func (s *Scheduler) Preempted() []*Sequence {
+	p := s.preempted
+	s.preempted = nil
+	return p
+}
+
 // PostProcess processes the output tokens
 func (s *Scheduler) PostProcess(seqs []*Sequence, tokenIDs []int) []bool {
 	finished := make([]bool, len(seqs))
 	
 	for i, seq := range seqs {
-		seq.AppendToken(tokenIDs[i])
+		// The guidance branch follows the sampled tokens
+		for _, g := range seq.group() {
+			g.AppendToken(tokenIDs[i])
+		}
 		
 		// Check if finished
-		if (!seq.IgnoreEOS && tokenIDs[i] == s.eosTokenID) || 
-		   seq.NumCompletionTokens() >= seq.MaxTokens {
-			seq.Status = SequenceStatusFinished
-			s.blockManager.Free(seq)
+		// A constraint that has fully matched (e.g. a closed grammar) or hit a
+		// dead end ends the sequence even without EOS
+		st := seq.SamplerState
+		constraintDone := st.Constraint != nil && (st.Constraint.Done() || st.Stalled())
+		if (!seq.IgnoreEOS && s.isEOS(tokenIDs[i])) || 
+		   seq.NumCompletionTokens() >= seq.MaxTokens || constraintDone {
+			for _, g := range seq.group() {
+				g.Status = SequenceStatusFinished
+				s.blockManager.Free(g)
+			}
 			
 			// Remove from running queue
 			for e := s.runningQueue.Front(); e != nil; e = e.Next() {
@@ -106,6 +131,16 @@ func (s *Scheduler) PostProcess(seqs []*Sequence, tokenIDs []int) []bool {
 	return finished
 }
 
+// isEOS reports whether id is one of the model's end-of-sequence tokens
+func (s *Scheduler) isEOS(id int) bool {
+	for _, eos := range s.eosTokenIDs {
+		if id == eos {
+			return true
+		}
+	}
+	return false
+}
+
 // IsFinished checks if all sequences are finished
 func (s *Scheduler) IsFinished() bool {
 	return s.waitingQueue.Len() == 0 && s.runningQueue.Len() == 0
@@ -117,10 +152,15 @@ func (s *Scheduler) canSchedule(seq *Sequence, currentBatchSize int) bool {
 		return false
 	}
 	
-	tokensNeeded := seq.NumTokens - seq.NumCachedTokens
+	// Prefill recomputes every sequence from its first token (the model KV
+	// cache is private to the sequence), so prefix-cached blocks save no work
+	tokensNeeded := 0
+	for _, g := range seq.group() {
+		tokensNeeded += g.NumTokens
+	}
 	if tokensNeeded > s.maxNumBatchedTokens {
 		return false
 	}
 	
-	return s.blockManager.CanAllocate(seq)
+	return s.blockManager.CanAllocate(seq.group()...)
 }
@@ -0,0 +1,96 @@
+package engine
+
+import (
+    "testing"
+
+    "github.com/unixsysdev/nano-go-vllm/internal/config"
+    "github.com/unixsysdev/nano-go-vllm/internal/sampling"
+)
+
+// newGuidedScheduler queues one guided sequence, prompt [1 3] with the
+// unconditional branch [5], on a scheduler with one-token blocks; no block
+// is shared through the prefix cache
+func newGuidedScheduler(numBlocks, maxBatchedTokens, maxTokens int) (*Scheduler, *Sequence) {
+    s := NewScheduler(&config.Config{MaxNumSeqs: 4, MaxNumBatchedTokens: maxBatchedTokens, NumKVCacheBlocks: numBlocks, KVCacheBlockSize: 1})
+    params := &sampling.SamplingParams{MaxTokens: maxTokens, GuidanceScale: 2}
+    seq := NewSequence([]int{1, 3}, params)
+    seq.Guidance = NewSequence([]int{5}, &sampling.SamplingParams{MaxTokens: maxTokens})
+    s.Add(seq)
+    return s, seq
+}
+
+// checkPair checks that both sequences of the pair have status st and hold
+// the given numbers of blocks
+func checkPair(t *testing.T, seq *Sequence, st SequenceStatus, condBlocks, uncondBlocks int) {
+    t.Helper()
+    for _, c := range []struct {
+        seq    *Sequence
+        blocks int
+    }{{seq, condBlocks}, {seq.Guidance, uncondBlocks}} {
+        if c.seq.Status != st || len(c.seq.BlockTable) != c.blocks {
+            t.Fatalf("sequence %d: status %v with %d blocks, want %v with %d", c.seq.ID, c.seq.Status, len(c.seq.BlockTable), st, c.blocks)
+        }
+    }
+}
+
+// TestGuidancePairAdmission: the pair is admitted only when the blocks and
+// the token budget cover both sequences
+func TestGuidancePairAdmission(t *testing.T) {
+    // 2 blocks fit the conditional prompt but not the pair
+    s, seq := newGuidedScheduler(2, 64, 4)
+    if got, _ := s.Schedule(); len(got) != 0 { t.Fatalf("scheduled %d sequences with 2 blocks for 3 tokens", len(got)) }
+    checkPair(t, seq, SequenceStatusWaiting, 0, 0)
+
+    // 2 batched tokens fit the conditional prompt but not the pair
+    s, seq = newGuidedScheduler(3, 2, 4)
+    if got, _ := s.Schedule(); len(got) != 0 { t.Fatalf("scheduled %d sequences with a budget of 2 tokens for 3", len(got)) }
+    checkPair(t, seq, SequenceStatusWaiting, 0, 0)
+
+    s, seq = newGuidedScheduler(3, 64, 4)
+    got, prefill := s.Schedule()
+    if len(got) != 1 || got[0] != seq || !prefill { t.Fatalf("scheduled %v (prefill %v), want the conditional sequence alone", got, prefill) }
+    checkPair(t, seq, SequenceStatusRunning, 2, 1)
+    if n := len(s.blockManager.freeBlocks); n != 0 { t.Errorf("%d free blocks, want 0", n) }
+}
+
+// TestGuidancePairDecode: both sequences get the sampled token and a block
+// for it, or neither runs
+func TestGuidancePairDecode(t *testing.T) {
+    s, seq := newGuidedScheduler(5, 64, 4)
+    s.Schedule()
+    s.PostProcess([]*Sequence{seq}, []int{4})
+    if seq.LastToken != 4 || seq.Guidance.LastToken != 4 || seq.Guidance.NumTokens != 2 {
+        t.Fatalf("after sampling 4: last tokens %d and %d, branch length %d", seq.LastToken, seq.Guidance.LastToken, seq.Guidance.NumTokens)
+    }
+    got, prefill := s.Schedule()
+    if len(got) != 1 || prefill { t.Fatalf("scheduled %d sequences (prefill %v), want one decode", len(got), prefill) }
+    checkPair(t, seq, SequenceStatusRunning, 3, 2)
+}
+
+// TestGuidancePairPreemption: when the pair cannot both grow, both are
+// preempted and freed, and only the conditional sequence is requeued
+func TestGuidancePairPreemption(t *testing.T) {
+    // 3 blocks for the prompts and 1 spare: enough for one of the two
+    s, seq := newGuidedScheduler(4, 64, 4)
+    s.Schedule()
+    s.PostProcess([]*Sequence{seq}, []int{4})
+    if got, _ := s.Schedule(); len(got) != 0 { t.Fatalf("scheduled %d sequences without room for the pair", len(got)) }
+    checkPair(t, seq, SequenceStatusWaiting, 0, 0)
+    if n := len(s.blockManager.freeBlocks); n != 4 { t.Errorf("%d free blocks after preemption, want 4", n) }
+    if p := s.Preempted(); len(p) != 2 || p[0] != seq || p[1] != seq.Guidance {
+        t.Errorf("preempted %v, want the pair", p)
+    }
+    if s.runningQueue.Len() != 0 || s.waitingQueue.Len() != 1 || s.waitingQueue.Front().Value != seq {
+        t.Errorf("running %d, waiting %d: want only the conditional sequence waiting", s.runningQueue.Len(), s.waitingQueue.Len())
+    }
+}
+
+// TestGuidancePairFinish: finishing the sequence frees the branch too
+func TestGuidancePairFinish(t *testing.T) {
+    s, seq := newGuidedScheduler(3, 64, 1)
+    s.Schedule()
+    if done := s.PostProcess([]*Sequence{seq}, []int{4}); !done[0] { t.Fatal("sequence not finished after MaxTokens") }
+    checkPair(t, seq, SequenceStatusFinished, 0, 0)
+    if n := len(s.blockManager.freeBlocks); n != 3 { t.Errorf("%d free blocks, want 3", n) }
+    if !s.IsFinished() { t.Error("scheduler still has work") }
+}
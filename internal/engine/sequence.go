@@ -33,6 +33,39 @@ type Sequence struct {
     RepetitionPenalty  float32
     PresencePenalty    float32
     FrequencyPenalty   float32
+    PenaltyIncludePrompt bool
+    PenaltyLastN       int
+    MinP               float32
+    TypicalP           float32
+    EpsilonCutoff      float32
+    EtaCutoff          float32
+    Mirostat           int
+    MirostatTau        float32
+    MirostatEta        float32
+    LogitBias          map[int]float32
+    AllowedTokenIDs    []int
+    BadWordsIDs        [][]int
+    DRYMultiplier      float32
+    DRYBase            float32
+    DRYAllowedLength   int
+    DRYBreakerIDs      []int
+    PenaltyAlpha       float32
+    Watermark          *sampling.Watermark
+    // Guidance is the unconditional (negative-prompt) branch for
+    // classifier-free guidance. It is not queued itself: the scheduler
+    // allocates, runs, preempts and frees it together with this sequence.
+    Guidance           *Sequence
+    GuidanceScale      float32
+    // Hidden holds the last-layer hidden state of every token, kept only for
+    // contrastive search and rebuilt whenever the sequence is re-prefilled
+    Hidden             [][]float32
+    // SamplerState is per-sequence sampler state (e.g. Mirostat μ). It is kept
+    // on the sequence so it survives preemption.
+    SamplerState       *sampling.State
+    // PromptLogprobs[i] is the log-probability of prompt token i+1, filled
+    // in by the first prefill when WantPromptLogprobs is set
+    WantPromptLogprobs bool
+    PromptLogprobs     []float32
 }
 
 var sequenceCounter int64
@@ -56,13 +89,48 @@ func NewSequence(tokenIDs []int, params *sampling.SamplingParams) *Sequence {
         RepetitionPenalty: params.RepetitionPenalty,
         PresencePenalty:   params.PresencePenalty,
         FrequencyPenalty:  params.FrequencyPenalty,
+        PenaltyIncludePrompt: params.PenaltyIncludePrompt,
+        PenaltyLastN:      params.PenaltyLastN,
+        MinP:              params.MinP,
+        TypicalP:          params.TypicalP,
+        EpsilonCutoff:     params.EpsilonCutoff,
+        EtaCutoff:         params.EtaCutoff,
+        Mirostat:          params.Mirostat,
+        MirostatTau:       params.MirostatTau,
+        MirostatEta:       params.MirostatEta,
+        LogitBias:         params.LogitBias,
+        AllowedTokenIDs:   params.AllowedTokenIDs,
+        BadWordsIDs:       params.BadWordsIDs,
+        DRYMultiplier:     params.DRYMultiplier,
+        DRYBase:           params.DRYBase,
+        DRYAllowedLength:  params.DRYAllowedLength,
+        DRYBreakerIDs:     params.DRYSequenceBreakerIDs,
+        PenaltyAlpha:      params.PenaltyAlpha,
+        Watermark:         params.Watermark,
+        GuidanceScale:     params.GuidanceScale,
+        SamplerState:      sampling.NewState(),
+        WantPromptLogprobs: params.PromptLogprobs,
     }
+	s.SamplerState.NumPromptTokens = len(tokenIDs)
 	
 	// Copy token IDs
 	copy(s.TokenIDs, tokenIDs)
 	return s
 }
 
+// Contrastive reports whether the sequence decodes with contrastive search
+func (s *Sequence) Contrastive() bool {
+	return s.PenaltyAlpha > 0 && s.TopK > 1
+}
+
+// group returns the sequence together with its guidance branch, if any
+func (s *Sequence) group() []*Sequence {
+	if s.Guidance == nil {
+		return []*Sequence{s}
+	}
+	return []*Sequence{s, s.Guidance}
+}
+
 // IsFinished checks if the sequence is finished
 func (s *Sequence) IsFinished() bool {
 	return s.Status == SequenceStatusFinished
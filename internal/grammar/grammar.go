@@ -0,0 +1,396 @@
+// Package grammar implements GBNF-style grammars for constrained decoding.
+//
+// Grammars are parsed from a llama.cpp-like GBNF syntax and lowered to
+// byte-level rules: character classes become alternatives over UTF-8 byte
+// ranges, so the matcher can advance one byte at a time over the raw bytes
+// of ByteLevel BPE tokens (which may split multi-byte characters).
+package grammar
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "unicode/utf8"
+)
+
+// Grammar is a compiled grammar: a set of rules, each a list of alternatives
+type Grammar struct {
+    rules [][]alt
+    names []string
+    root  int
+}
+
+// alt is one alternative of a rule: a sequence of elements
+type alt []elem
+
+// elem is either a reference to another rule (ref >= 0) or a byte set
+type elem struct {
+    ref int
+    set byteSet
+}
+
+// Parse compiles GBNF source. The start symbol is the rule named "root".
+//
+// Supported syntax: rule definitions `name ::= ...`, string literals "...",
+// character classes [a-z] and [^...], `.` (any character), grouping ( ),
+// alternation |, repetition * + ? {m} {m,} {m,n}, and # comments.
+func Parse(src string) (*Grammar, error) {
+    p := &parser{
+        src:     src,
+        g:       &Grammar{},
+        byName:  make(map[string]int),
+        classes: make(map[string]int),
+    }
+    p.skipSpace(true)
+    for p.pos < len(p.src) {
+        if err := p.parseRule(); err != nil { return nil, err }
+        p.skipSpace(true)
+    }
+    for name, id := range p.byName {
+        if !p.defined[id] { return nil, fmt.Errorf("grammar: undefined rule %q", name) }
+    }
+    root, ok := p.byName["root"]
+    if !ok { return nil, fmt.Errorf("grammar: missing root rule") }
+    p.g.root = root
+    if err := p.g.checkLeftRecursion(); err != nil { return nil, err }
+    return p.g, nil
+}
+
+type parser struct {
+    src     string
+    pos     int
+    g       *Grammar
+    byName  map[string]int
+    defined []bool
+    classes map[string]int // synthetic rules for non-ASCII character classes
+}
+
+// ruleID returns the id for a named rule, allocating it on first use
+func (p *parser) ruleID(name string) int {
+    if id, ok := p.byName[name]; ok { return id }
+    id := p.newRule(name)
+    p.byName[name] = id
+    return id
+}
+
+// newRule allocates an (initially empty) rule
+func (p *parser) newRule(name string) int {
+    p.g.rules = append(p.g.rules, nil)
+    p.g.names = append(p.g.names, name)
+    p.defined = append(p.defined, false)
+    return len(p.g.rules) - 1
+}
+
+// synthRule allocates a generated rule with the given alternatives
+func (p *parser) synthRule(base string, alts []alt) int {
+    id := p.newRule(fmt.Sprintf("%s_%d", base, len(p.g.rules)))
+    p.g.rules[id] = alts
+    p.defined[id] = true
+    return id
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+    line := 1 + strings.Count(p.src[:p.pos], "\n")
+    return fmt.Errorf("grammar: line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) peek() byte {
+    if p.pos >= len(p.src) { return 0 }
+    return p.src[p.pos]
+}
+
+// skipSpace skips blanks and comments, and newlines when allowed
+func (p *parser) skipSpace(newlines bool) {
+    for p.pos < len(p.src) {
+        switch c := p.src[p.pos]; {
+        case c == ' ' || c == '\t':
+            p.pos++
+        case c == '\r' || c == '\n':
+            if !newlines { return }
+            p.pos++
+        case c == '#':
+            for p.pos < len(p.src) && p.src[p.pos] != '\n' { p.pos++ }
+        default:
+            return
+        }
+    }
+}
+
+func isNameChar(c byte) bool {
+    return c == '_' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (p *parser) parseName() string {
+    start := p.pos
+    for p.pos < len(p.src) && isNameChar(p.src[p.pos]) { p.pos++ }
+    return p.src[start:p.pos]
+}
+
+func (p *parser) parseRule() error {
+    name := p.parseName()
+    if name == "" { return p.errorf("expected rule name") }
+    p.skipSpace(false)
+    if !strings.HasPrefix(p.src[p.pos:], "::=") { return p.errorf("expected ::= after %q", name) }
+    p.pos += 3
+    p.skipSpace(true)
+    id := p.ruleID(name)
+    if p.defined[id] { return p.errorf("rule %q defined twice", name) }
+    alts, err := p.parseAlternatives(name, false)
+    if err != nil { return err }
+    p.g.rules[id] = alts
+    p.defined[id] = true
+    if c := p.peek(); c != 0 && c != '\n' && c != '\r' {
+        return p.errorf("unexpected %q", c)
+    }
+    return nil
+}
+
+func (p *parser) parseAlternatives(name string, nested bool) ([]alt, error) {
+    seq, err := p.parseSequence(name, nested)
+    if err != nil { return nil, err }
+    alts := []alt{seq}
+    for p.peek() == '|' {
+        p.pos++
+        p.skipSpace(true)
+        if seq, err = p.parseSequence(name, nested); err != nil { return nil, err }
+        alts = append(alts, seq)
+    }
+    return alts, nil
+}
+
+func (p *parser) parseSequence(name string, nested bool) (alt, error) {
+    var seq alt
+    last := -1 // start of the last item, for repetition operators
+    for {
+        c := p.peek()
+        switch {
+        case c == '"':
+            p.pos++
+            last = len(seq)
+            for p.peek() != '"' {
+                if p.pos >= len(p.src) { return nil, p.errorf("unterminated string literal") }
+                r, err := p.parseChar()
+                if err != nil { return nil, err }
+                var buf [utf8.UTFMax]byte
+                n := utf8.EncodeRune(buf[:], r)
+                for _, b := range buf[:n] {
+                    var s byteSet
+                    s.add(b)
+                    seq = append(seq, elem{ref: -1, set: s})
+                }
+            }
+            p.pos++
+        case c == '[':
+            p.pos++
+            negated := false
+            if p.peek() == '^' { negated = true; p.pos++ }
+            var ranges []runeRange
+            for p.peek() != ']' {
+                if p.pos >= len(p.src) { return nil, p.errorf("unterminated character class") }
+                lo, err := p.parseChar()
+                if err != nil { return nil, err }
+                hi := lo
+                if p.peek() == '-' && p.pos+1 < len(p.src) && p.src[p.pos+1] != ']' {
+                    p.pos++
+                    if hi, err = p.parseChar(); err != nil { return nil, err }
+                }
+                ranges = append(ranges, runeRange{lo, hi})
+            }
+            p.pos++
+            if negated { ranges = negateRanges(ranges) }
+            last = len(seq)
+            seq = append(seq, p.classElem(name, ranges))
+        case c == '.':
+            p.pos++
+            last = len(seq)
+            seq = append(seq, p.classElem(name, []runeRange{{0, utf8.MaxRune}}))
+        case c == '(':
+            p.pos++
+            p.skipSpace(true)
+            alts, err := p.parseAlternatives(name, true)
+            if err != nil { return nil, err }
+            if p.peek() != ')' { return nil, p.errorf("expected )") }
+            p.pos++
+            last = len(seq)
+            seq = append(seq, elem{ref: p.synthRule(name, alts)})
+        case isNameChar(c):
+            ref := p.ruleID(p.parseName())
+            last = len(seq)
+            seq = append(seq, elem{ref: ref})
+        case c == '*' || c == '+' || c == '?' || c == '{':
+            if last < 0 { return nil, p.errorf("repetition %q without an item", c) }
+            min, max, err := p.parseRepetition()
+            if err != nil { return nil, err }
+            item := append(alt(nil), seq[last:]...)
+            seq = append(seq[:last], p.repeat(name, item, min, max)...)
+            last = -1
+        default:
+            return seq, nil
+        }
+        p.skipSpace(nested)
+    }
+}
+
+// parseRepetition parses * + ? or {m}, {m,}, {m,n}; max < 0 means unbounded
+func (p *parser) parseRepetition() (int, int, error) {
+    c := p.src[p.pos]
+    p.pos++
+    switch c {
+    case '*':
+        return 0, -1, nil
+    case '+':
+        return 1, -1, nil
+    case '?':
+        return 0, 1, nil
+    }
+    end := strings.IndexByte(p.src[p.pos:], '}')
+    if end < 0 { return 0, 0, p.errorf("unterminated {m,n}") }
+    body := strings.ReplaceAll(p.src[p.pos:p.pos+end], " ", "")
+    p.pos += end + 1
+    lo, hi, hasComma := body, body, false
+    if i := strings.IndexByte(body, ','); i >= 0 {
+        lo, hi, hasComma = body[:i], body[i+1:], true
+    }
+    min, err := strconv.Atoi(lo)
+    if err != nil { return 0, 0, p.errorf("bad repetition {%s}", body) }
+    max := min
+    if hasComma {
+        max = -1
+        if hi != "" {
+            if max, err = strconv.Atoi(hi); err != nil || max < min {
+                return 0, 0, p.errorf("bad repetition {%s}", body)
+            }
+        }
+    }
+    return min, max, nil
+}
+
+// repeat expands item{min,max} into plain elements and generated rules
+func (p *parser) repeat(name string, item alt, min, max int) alt {
+    var out alt
+    for i := 0; i < min; i++ { out = append(out, item...) }
+    if max < 0 {
+        // star ::= item star | ε
+        id := p.newRule(fmt.Sprintf("%s_%d", name, len(p.g.rules)))
+        body := append(append(alt(nil), item...), elem{ref: id})
+        p.g.rules[id] = []alt{body, {}}
+        p.defined[id] = true
+        return append(out, elem{ref: id})
+    }
+    // opt_k ::= item opt_{k-1} | ε
+    next := -1
+    for i := 0; i < max-min; i++ {
+        body := append(alt(nil), item...)
+        if next >= 0 { body = append(body, elem{ref: next}) }
+        next = p.synthRule(name, []alt{body, {}})
+    }
+    if next >= 0 { out = append(out, elem{ref: next}) }
+    return out
+}
+
+// classElem turns a code point class into a single byte-set element for
+// ASCII-only classes, or a reference to a rule over UTF-8 byte sequences
+func (p *parser) classElem(name string, ranges []runeRange) elem {
+    ranges = normalizeRanges(ranges)
+    if len(ranges) == 0 || ranges[len(ranges)-1].hi < utf8.RuneSelf {
+        var s byteSet
+        for _, r := range ranges { s.addRange(byte(r.lo), byte(r.hi)) }
+        return elem{ref: -1, set: s}
+    }
+    key := fmt.Sprint(ranges)
+    if id, ok := p.classes[key]; ok { return elem{ref: id} }
+    var ascii byteSet
+    hasASCII := false
+    var alts []alt
+    for _, r := range ranges {
+        for _, seq := range utf8Sequences(r.lo, r.hi) {
+            if len(seq) == 1 {
+                ascii.addRange(seq[0].lo, seq[0].hi)
+                hasASCII = true
+                continue
+            }
+            a := make(alt, len(seq))
+            for i, br := range seq {
+                var s byteSet
+                s.addRange(br.lo, br.hi)
+                a[i] = elem{ref: -1, set: s}
+            }
+            alts = append(alts, a)
+        }
+    }
+    if hasASCII { alts = append([]alt{{{ref: -1, set: ascii}}}, alts...) }
+    id := p.synthRule(name+"_char", alts)
+    p.classes[key] = id
+    return elem{ref: id}
+}
+
+// parseChar parses one (possibly escaped) character of a literal or class
+func (p *parser) parseChar() (rune, error) {
+    if p.src[p.pos] != '\\' {
+        r, n := utf8.DecodeRuneInString(p.src[p.pos:])
+        p.pos += n
+        return r, nil
+    }
+    p.pos++
+    if p.pos >= len(p.src) { return 0, p.errorf("dangling escape") }
+    c := p.src[p.pos]
+    p.pos++
+    switch c {
+    case 'n':
+        return '\n', nil
+    case 'r':
+        return '\r', nil
+    case 't':
+        return '\t', nil
+    case 'x', 'u', 'U':
+        n := map[byte]int{'x': 2, 'u': 4, 'U': 8}[c]
+        if p.pos+n > len(p.src) { return 0, p.errorf("short \\%c escape", c) }
+        v, err := strconv.ParseUint(p.src[p.pos:p.pos+n], 16, 32)
+        if err != nil { return 0, p.errorf("bad \\%c escape", c) }
+        p.pos += n
+        return rune(v), nil
+    default:
+        r, n := utf8.DecodeRuneInString(p.src[p.pos-1:])
+        p.pos += n - 1
+        return r, nil
+    }
+}
+
+// checkLeftRecursion rejects grammars whose expansion would never consume input
+func (g *Grammar) checkLeftRecursion() error {
+    nullable := make([]bool, len(g.rules))
+    for changed := true; changed; {
+        changed = false
+        for id, alts := range g.rules {
+            if nullable[id] { continue }
+            for _, a := range alts {
+                ok := true
+                for _, e := range a {
+                    if e.ref < 0 || !nullable[e.ref] { ok = false; break }
+                }
+                if ok { nullable[id] = true; changed = true; break }
+            }
+        }
+    }
+    // state: 0 = unvisited, 1 = on stack, 2 = done
+    state := make([]int, len(g.rules))
+    var visit func(id int) error
+    visit = func(id int) error {
+        if state[id] == 1 { return fmt.Errorf("grammar: left recursion through rule %q", g.names[id]) }
+        if state[id] == 2 { return nil }
+        state[id] = 1
+        for _, a := range g.rules[id] {
+            for _, e := range a {
+                if e.ref < 0 { break }
+                if err := visit(e.ref); err != nil { return err }
+                if !nullable[e.ref] { break }
+            }
+        }
+        state[id] = 2
+        return nil
+    }
+    for id := range g.rules {
+        if err := visit(id); err != nil { return err }
+    }
+    return nil
+}
@@ -0,0 +1,90 @@
+package grammar
+
+import (
+    "strings"
+    "testing"
+)
+
+// byteEOS is the EOS id of byteVocab, whose token i is the single byte i
+const byteEOS = 256
+
+var byteVocab = func() *Vocab {
+    tokens := make([][]byte, byteEOS+1)
+    for i := 0; i < byteEOS; i++ { tokens[i] = []byte{byte(i)} }
+    return NewVocab(tokens, byteEOS)
+}()
+
+// matches reports whether the grammar accepts exactly the input, fed one
+// byte at a time and closed with EOS
+func matches(g *Grammar, input string) bool {
+    m := NewMatcher(g, byteVocab)
+    for i := 0; i < len(input); i++ {
+        if m.Accept(int(input[i])) != nil { return false }
+    }
+    return m.Accept(byteEOS) == nil
+}
+
+func TestParseErrors(t *testing.T) {
+    tests := []struct {
+        src, err string
+    }{
+        {`item ::= "a"`, "missing root rule"},
+        {`root ::= item`, `undefined rule "item"`},
+        {`root ::= "abc`, "unterminated string literal"},
+        {`root ::= [a-z`, "unterminated character class"},
+        {`root ::= ("a" | "b"`, "expected )"},
+        {`root ::= "a"{2`, "unterminated {m,n}"},
+        {`root ::= "a"{3,2}`, "bad repetition {3,2}"},
+        {`root ::= "a"{x}`, "bad repetition {x}"},
+        {`root ::= *`, "without an item"},
+        {`root ::= "\x4`, `short \x escape`},
+        {`root ::= "\xzz"`, `bad \x escape`},
+        {`root ::= root "a"`, `left recursion through rule "root"`},
+        {"root ::= item \"b\"\nitem ::= root | \"a\"", "left recursion"},
+        // the recursion is hidden behind a nullable prefix
+        {"root ::= opt root \"a\" | \"b\"\nopt ::= \"x\"?", "left recursion"},
+    }
+    for _, tt := range tests {
+        _, err := Parse(tt.src)
+        if err == nil || !strings.Contains(err.Error(), tt.err) {
+            t.Errorf("Parse(%q) = %v, want an error containing %q", tt.src, err, tt.err)
+        }
+    }
+}
+
+func TestParseAccepts(t *testing.T) {
+    tests := []struct {
+        src  string
+        good []string
+        bad  []string
+    }{
+        // right recursion is fine
+        {`root ::= "a" root | "b"`, []string{"b", "ab", "aaab"}, []string{"", "a", "ba"}},
+        {`root ::= "a"*`, []string{"", "a", "aaaa"}, []string{"b", "ab"}},
+        {`root ::= "a"+`, []string{"a", "aaa"}, []string{""}},
+        {`root ::= "a"? "b"`, []string{"b", "ab"}, []string{"aab", "a"}},
+        {`root ::= "a"{2}`, []string{"aa"}, []string{"a", "aaa"}},
+        {`root ::= "a"{2,3}`, []string{"aa", "aaa"}, []string{"a", "aaaa"}},
+        {`root ::= "a"{2,}`, []string{"aa", "aaaaa"}, []string{"a"}},
+        {`root ::= "a"{0,2} "b"`, []string{"b", "ab", "aab"}, []string{"aaab"}},
+        // repetition binds to the last item only; groups repeat as a whole
+        {`root ::= "x" "ab"{2}`, []string{"xabab"}, []string{"xab", "xabb"}},
+        {`root ::= "x" ("a" | "bc"){1,2}`, []string{"xa", "xbc", "xabc", "xbca"}, []string{"x", "xaaa"}},
+        {`root ::= [a-c]+ [0-9]`, []string{"a1", "cab9"}, []string{"d1", "a"}},
+        {`root ::= [^a-z]`, []string{"A", "é", "日"}, []string{"a", "AB"}},
+        {`root ::= .`, []string{"a", "é", "😀"}, []string{"", "ab"}},
+        {`root ::= [à-ÿ]{2}`, []string{"àÿ", "éé"}, []string{"aé", "é"}},
+        {`root ::= "\x41é\n" [\t\]]`, []string{"Aé\n\t", "Aé\n]"}, []string{"Aé\n "}},
+        {"# a comment\nroot ::= item item # trailing\nitem ::= \"a\" |\n  \"b\"", []string{"ab", "ba"}, []string{"a", "abc"}},
+    }
+    for _, tt := range tests {
+        g, err := Parse(tt.src)
+        if err != nil { t.Errorf("Parse(%q): %v", tt.src, err); continue }
+        for _, s := range tt.good {
+            if !matches(g, s) { t.Errorf("%q rejects %q", tt.src, s) }
+        }
+        for _, s := range tt.bad {
+            if matches(g, s) { t.Errorf("%q accepts %q", tt.src, s) }
+        }
+    }
+}
@@ -0,0 +1,557 @@
+package grammar
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "math"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Shared JSON building blocks. Numbers and whitespace are bounded so that a
+// model can never get stuck extending a value that would then never close.
+var jsonPrimitives = map[string]string{
+    "ws":      `| " " | "\n" [ \t]{0,20}`,
+    "value":   `object | array | string | number | boolean | null`,
+    "object":  `"{" ws ( string ws ":" ws value ( ws "," ws string ws ":" ws value )* )? ws "}"`,
+    "array":   `"[" ws ( value ( ws "," ws value )* )? ws "]"`,
+    "string":  `"\"" char* "\""`,
+    "char":    `[^"\\\x00-\x1F] | "\\" ( ["\\/bfnrt] | "u" [0-9a-fA-F]{4} )`,
+    "integer": `"-"? ( "0" | [1-9] [0-9]{0,15} )`,
+    "number":  `"-"? ( "0" | [1-9] [0-9]{0,15} ) ( "." [0-9]{1,16} )? ( [eE] [-+]? [0-9]{1,3} )?`,
+    "boolean": `"true" | "false"`,
+    "null":    `"null"`,
+    // string formats
+    "date":      `[0-9]{4} "-" ( "0" [1-9] | "1" [0-2] ) "-" ( "0" [1-9] | [1-2] [0-9] | "3" [0-1] )`,
+    "time":      `( [01] [0-9] | "2" [0-3] ) ":" [0-5] [0-9] ":" [0-5] [0-9] ( "." [0-9]{1,6} )? ( "Z" | [+-] [0-9]{2} ":" [0-9]{2} )`,
+    "date-time": `date "T" time`,
+    "uuid":      `[0-9a-fA-F]{8} "-" [0-9a-fA-F]{4} "-" [0-9a-fA-F]{4} "-" [0-9a-fA-F]{4} "-" [0-9a-fA-F]{12}`,
+    "ipv4":      `dec-octet "." dec-octet "." dec-octet "." dec-octet`,
+    "dec-octet": `[0-9] | [1-9] [0-9] | "1" [0-9]{2} | "2" [0-4] [0-9] | "25" [0-5]`,
+    "email":     `[A-Za-z0-9._%+-]{1,64} "@" [A-Za-z0-9-]{1,63} ( "." [A-Za-z0-9-]{1,63} ){1,4}`,
+}
+
+// primitive dependencies, so only the rules actually used are emitted
+var jsonPrimitiveDeps = map[string][]string{
+    "value":     {"object", "array", "string", "number", "boolean", "null"},
+    "object":    {"ws", "string", "value"},
+    "array":     {"ws", "value"},
+    "string":    {"char"},
+    "date-time": {"date", "time"},
+    "ipv4":      {"dec-octet"},
+}
+
+// JSONObjectGrammar returns a grammar accepting any syntactically valid JSON
+// object. The root has no trailing whitespace, so the grammar is complete
+// (and generation stops) as soon as the top-level object is closed.
+func JSONObjectGrammar() string {
+    c := newSchemaCompiler(nil)
+    c.rules["root"] = "object"
+    c.use("object")
+    return c.String()
+}
+
+// FromJSONSchema compiles a JSON Schema into GBNF source. Supported keywords:
+// type (incl. lists), properties, required, additionalProperties, items,
+// minItems/maxItems, enum, const, anyOf/oneOf, minLength/maxLength, format
+// (date, time, date-time, uuid, ipv4, email), minimum/maximum and their
+// exclusive forms for integers, and local $ref into $defs/definitions. Properties are emitted in declared order;
+// unlisted properties are only allowed when additionalProperties is set.
+func FromJSONSchema(schema []byte) (string, error) {
+    doc, err := decodeOrdered(json.NewDecoder(bytes.NewReader(schema)))
+    if err != nil { return "", fmt.Errorf("json schema: %v", err) }
+    root, ok := doc.(map[string]interface{})
+    if !ok { return "", fmt.Errorf("json schema: top level must be an object") }
+    c := newSchemaCompiler(root)
+    body, err := c.visit(root, "root")
+    if err != nil { return "", err }
+    c.rules["root"] = body
+    return c.String(), nil
+}
+
+type schemaCompiler struct {
+    doc   map[string]interface{}
+    rules map[string]string
+    refs  map[string]string // $ref -> rule name
+}
+
+func newSchemaCompiler(doc map[string]interface{}) *schemaCompiler {
+    return &schemaCompiler{doc: doc, rules: make(map[string]string), refs: make(map[string]string)}
+}
+
+// use pulls a primitive rule and its dependencies into the output
+func (c *schemaCompiler) use(name string) string {
+    if _, ok := c.rules[name]; ok { return name }
+    c.rules[name] = jsonPrimitives[name]
+    for _, dep := range jsonPrimitiveDeps[name] { c.use(dep) }
+    return name
+}
+
+var invalidRuleChars = regexp.MustCompile(`[^a-zA-Z0-9-]+`)
+
+// define adds a rule under a unique name derived from hint, reusing an
+// existing rule with the same name stem and body
+func (c *schemaCompiler) define(hint, body string) string {
+    base := ruleName(hint)
+    name := base
+    for i := 1; ; i++ {
+        if _, taken := c.rules[name]; !taken { break }
+        if _, prim := jsonPrimitives[name]; !prim && c.rules[name] == body { return name }
+        name = fmt.Sprintf("%s-%d", base, i)
+    }
+    c.rules[name] = body
+    return name
+}
+
+// reserve claims a fresh rule name whose body is filled in later
+func (c *schemaCompiler) reserve(hint string) string {
+    base := ruleName(hint)
+    name := base
+    for i := 1; ; i++ {
+        if _, taken := c.rules[name]; !taken { break }
+        name = fmt.Sprintf("%s-%d", base, i)
+    }
+    c.rules[name] = `""`
+    return name
+}
+
+func ruleName(hint string) string {
+    base := strings.Trim(invalidRuleChars.ReplaceAllString(hint, "-"), "-")
+    if base == "" { base = "rule" }
+    return base
+}
+
+// String renders the collected rules, root first
+func (c *schemaCompiler) String() string {
+    names := make([]string, 0, len(c.rules))
+    for n := range c.rules {
+        if n != "root" { names = append(names, n) }
+    }
+    sort.Strings(names)
+    var b strings.Builder
+    fmt.Fprintf(&b, "root ::= %s\n", c.rules["root"])
+    for _, n := range names { fmt.Fprintf(&b, "%s ::= %s\n", n, c.rules[n]) }
+    return b.String()
+}
+
+// visit returns a grammar expression matching values of schema s
+func (c *schemaCompiler) visit(s map[string]interface{}, name string) (string, error) {
+    if ref, ok := s["$ref"].(string); ok { return c.resolveRef(ref) }
+    if v, ok := s["const"]; ok { return jsonLiteral(v) }
+    if vals, ok := s["enum"].([]interface{}); ok {
+        alts := make([]string, len(vals))
+        for i, v := range vals {
+            lit, err := jsonLiteral(v)
+            if err != nil { return "", err }
+            alts[i] = lit
+        }
+        return "( " + strings.Join(alts, " | ") + " )", nil
+    }
+    for _, kw := range []string{"anyOf", "oneOf"} {
+        if subs, ok := s[kw].([]interface{}); ok {
+            return c.visitAlternatives(subs, name)
+        }
+    }
+    if _, ok := s["allOf"]; ok { return "", fmt.Errorf("json schema: allOf is not supported") }
+
+    switch t := s["type"].(type) {
+    case nil:
+        if _, ok := s["properties"]; ok { return c.visitObject(s, name) }
+        return c.use("value"), nil
+    case []interface{}:
+        var alts []string
+        for _, tt := range t {
+            sub := copySchema(s)
+            sub["type"] = tt
+            body, err := c.visit(sub, name)
+            if err != nil { return "", err }
+            alts = append(alts, body)
+        }
+        return "( " + strings.Join(alts, " | ") + " )", nil
+    case string:
+        switch t {
+        case "object":
+            return c.visitObject(s, name)
+        case "array":
+            return c.visitArray(s, name)
+        case "string":
+            return c.visitString(s, name)
+        case "integer":
+            return c.visitInteger(s, name)
+        case "number":
+            for _, kw := range []string{"minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf"} {
+                if _, ok := s[kw]; ok { return "", fmt.Errorf("json schema: %s on numbers is not supported", kw) }
+            }
+            return c.use(t), nil
+        case "boolean", "null":
+            return c.use(t), nil
+        }
+        return "", fmt.Errorf("json schema: unsupported type %q", t)
+    }
+    return "", fmt.Errorf("json schema: bad type %v", s["type"])
+}
+
+func (c *schemaCompiler) visitAlternatives(subs []interface{}, name string) (string, error) {
+    alts := make([]string, 0, len(subs))
+    for i, sub := range subs {
+        m, ok := sub.(map[string]interface{})
+        if !ok { return "", fmt.Errorf("json schema: %s alternative %d is not a schema", name, i) }
+        body, err := c.visit(m, fmt.Sprintf("%s-%d", name, i))
+        if err != nil { return "", err }
+        alts = append(alts, body)
+    }
+    return "( " + strings.Join(alts, " | ") + " )", nil
+}
+
+// visitObject emits properties in declared order. For optional properties
+// it builds rules P(i, comma) = "properties i.. where a separator is needed
+// before the next one iff comma", which keeps the grammar linear in size.
+func (c *schemaCompiler) visitObject(s map[string]interface{}, name string) (string, error) {
+    props, _ := s["properties"].(map[string]interface{})
+    order := propertyOrder(props)
+    if _, ok := s["additionalProperties"]; !ok && len(order) == 0 {
+        // a bare {"type": "object"} accepts any object
+        return c.use("object"), nil
+    }
+    required := make(map[string]bool)
+    if req, ok := s["required"].([]interface{}); ok {
+        for _, r := range req {
+            if rs, ok := r.(string); ok { required[rs] = true }
+        }
+    }
+    kvs := make([]string, len(order))
+    for i, key := range order {
+        sub, ok := props[key].(map[string]interface{})
+        if !ok { return "", fmt.Errorf("json schema: property %q is not a schema", key) }
+        val, err := c.visit(sub, name+"-"+key)
+        if err != nil { return "", err }
+        lit, _ := jsonLiteral(key)
+        kvs[i] = c.define(name+"-"+key+"-kv", lit+` ws ":" ws `+val)
+    }
+
+    var extra string
+    switch ap := s["additionalProperties"].(type) {
+    case bool:
+        if ap { extra = c.define(name+"-extra-kv", c.use("string")+` ws ":" ws `+c.use("value")) }
+    case map[string]interface{}:
+        val, err := c.visit(ap, name+"-additional")
+        if err != nil { return "", err }
+        extra = c.define(name+"-extra-kv", c.use("string")+` ws ":" ws `+val)
+    }
+    c.use("ws")
+
+    // rest[i][comma]: rule name for properties i..n
+    rest := make([][2]string, len(order)+1)
+    tail := `""`
+    if extra != "" {
+        tail = c.define(name+"-extras", `( ws "," ws `+extra+` )*`)
+        first := c.define(name+"-extras-first", `( `+extra+` `+tail+` )?`)
+        rest[len(order)] = [2]string{first, tail}
+    } else {
+        rest[len(order)] = [2]string{`""`, `""`}
+    }
+    for i := len(order) - 1; i >= 0; i-- {
+        for comma := 0; comma < 2; comma++ {
+            sep := ""
+            if comma == 1 { sep = `ws "," ws ` }
+            body := sep + kvs[i] + " " + rest[i+1][1]
+            if !required[order[i]] {
+                body = "( " + body + " ) | " + rest[i+1][comma]
+            }
+            rest[i][comma] = c.define(fmt.Sprintf("%s-props-%d-%d", name, i, comma), body)
+        }
+    }
+    return `"{" ws ` + rest[0][0] + ` ws "}"`, nil
+}
+
+func (c *schemaCompiler) visitArray(s map[string]interface{}, name string) (string, error) {
+    var item string
+    if it, ok := s["items"].(map[string]interface{}); ok {
+        body, err := c.visit(it, name+"-item")
+        if err != nil { return "", err }
+        item = c.define(name+"-item", body)
+    } else {
+        item = c.use("value")
+    }
+    c.use("ws")
+    min, max := intKeyword(s, "minItems", 0), intKeyword(s, "maxItems", -1)
+    if max == 0 { return `"[" ws "]"`, nil }
+    more := `( ws "," ws ` + item + ` )`
+    var list string
+    switch {
+    case max < 0 && min <= 1:
+        list = item + " " + more + "*"
+    case max < 0:
+        list = item + " " + more + fmt.Sprintf("{%d,}", min-1)
+    default:
+        lo := min - 1
+        if lo < 0 { lo = 0 }
+        list = item + " " + more + fmt.Sprintf("{%d,%d}", lo, max-1)
+    }
+    if min == 0 { list = "( " + list + " )?" }
+    return `"[" ws ` + list + ` ws "]"`, nil
+}
+
+// maxIntDigits matches the 16-digit bound of the integer primitive
+const maxIntDigits = 16
+
+// visitInteger lowers minimum/maximum (and their exclusive forms) to a
+// digit-by-digit range, so out-of-range values can never be generated
+func (c *schemaCompiler) visitInteger(s map[string]interface{}, name string) (string, error) {
+    if _, ok := s["multipleOf"]; ok { return "", fmt.Errorf("json schema: multipleOf is not supported") }
+    var lo, hi int64
+    hasLo, hasHi := false, false
+    for _, b := range []struct {
+        key   string
+        lower bool
+        round func(float64) float64
+    }{
+        {"minimum", true, math.Ceil},
+        {"exclusiveMinimum", true, func(x float64) float64 { return math.Floor(x) + 1 }},
+        {"maximum", false, math.Floor},
+        {"exclusiveMaximum", false, func(x float64) float64 { return math.Ceil(x) - 1 }},
+    } {
+        v, ok := s[b.key]
+        if !ok { continue }
+        f, ok := v.(float64)
+        if !ok { return "", fmt.Errorf("json schema: %s must be a number", b.key) }
+        f = b.round(f)
+        if math.Abs(f) >= 1e16 { return "", fmt.Errorf("json schema: %s %v is out of range", b.key, v) }
+        n := int64(f)
+        switch {
+        case b.lower && (!hasLo || n > lo):
+            lo, hasLo = n, true
+        case !b.lower && (!hasHi || n < hi):
+            hi, hasHi = n, true
+        }
+    }
+    if !hasLo && !hasHi { return c.use("integer"), nil }
+    if hasLo && hasHi && lo > hi { return "", fmt.Errorf("json schema: %s has an empty integer range", name) }
+
+    var alts []string
+    if !hasLo || lo < 0 {
+        // negative values as "-" and a magnitude range
+        from := int64(1)
+        if hasHi && hi < 0 { from = -hi }
+        alts = append(alts, `"-" `+group(uintRange(from, -lo, hasLo)))
+    }
+    if !hasHi || hi >= 0 {
+        alts = append(alts, uintRange(max(lo, 0), hi, hasHi)...)
+    }
+    return c.define(name+"-int", strings.Join(alts, " | ")), nil
+}
+
+// uintRange returns alternatives matching the decimal numbers lo..hi without
+// leading zeros; hi is ignored when unbounded
+func uintRange(lo, hi int64, bounded bool) []string {
+    a := strconv.FormatInt(lo, 10)
+    if !bounded {
+        alts := []string{digitRange(a, strings.Repeat("9", len(a)))}
+        if len(a) < maxIntDigits { alts = append(alts, "[1-9]"+digits(len(a), maxIntDigits-1)) }
+        return alts
+    }
+    b := strconv.FormatInt(hi, 10)
+    if len(a) == len(b) { return []string{digitRange(a, b)} }
+    alts := []string{digitRange(a, strings.Repeat("9", len(a)))}
+    if len(b)-len(a) > 1 { alts = append(alts, "[1-9]"+digits(len(a), len(b)-2)) }
+    return append(alts, digitRange("1"+strings.Repeat("0", len(b)-1), b))
+}
+
+// digitRange matches the digit strings lo..hi of equal length
+func digitRange(lo, hi string) string {
+    i := 0
+    for i < len(lo) && lo[i] == hi[i] { i++ }
+    if i == len(lo) { return `"` + lo + `"` }
+    prefix := ""
+    if i > 0 { prefix = `"` + lo[:i] + `" ` }
+    lo, hi = lo[i:], hi[i:]
+    n := len(lo) - 1
+    zeros, nines := strings.Repeat("0", n), strings.Repeat("9", n)
+    d0, d1 := lo[0], hi[0]
+    var alts []string
+    if lo[1:] != zeros {
+        alts = append(alts, `"`+lo[:1]+`" `+digitRange(lo[1:], nines))
+        d0++
+    }
+    var upper string
+    if hi[1:] != nines {
+        upper = `"` + hi[:1] + `" ` + digitRange(zeros, hi[1:])
+        d1--
+    }
+    if d0 <= d1 {
+        r := fmt.Sprintf("[%c-%c]", d0, d1)
+        if d0 == d1 { r = `"` + string(d0) + `"` }
+        alts = append(alts, r+digits(n, n))
+    }
+    if upper != "" { alts = append(alts, upper) }
+    return prefix + group(alts)
+}
+
+// digits repeats [0-9] min..max times
+func digits(min, max int) string {
+    switch {
+    case max == 0:
+        return ""
+    case min == max && min == 1:
+        return " [0-9]"
+    case min == max:
+        return fmt.Sprintf(" [0-9]{%d}", min)
+    }
+    return fmt.Sprintf(" [0-9]{%d,%d}", min, max)
+}
+
+// group parenthesizes a list of alternatives
+func group(alts []string) string {
+    if len(alts) == 1 { return alts[0] }
+    return "( " + strings.Join(alts, " | ") + " )"
+}
+
+func (c *schemaCompiler) visitString(s map[string]interface{}, name string) (string, error) {
+    if _, ok := s["pattern"]; ok { return "", fmt.Errorf("json schema: string pattern is not supported") }
+    if f, ok := s["format"].(string); ok {
+        if _, known := jsonPrimitives[f]; !known || f == "value" || f == "string" {
+            return "", fmt.Errorf("json schema: unsupported string format %q", f)
+        }
+        return `"\"" ` + c.use(f) + ` "\""`, nil
+    }
+    min, max := intKeyword(s, "minLength", 0), intKeyword(s, "maxLength", -1)
+    if min == 0 && max < 0 { return c.use("string"), nil }
+    c.use("char")
+    rep := fmt.Sprintf("{%d,}", min)
+    if max >= 0 { rep = fmt.Sprintf("{%d,%d}", min, max) }
+    return `"\"" char` + rep + ` "\""`, nil
+}
+
+// resolveRef maps a local reference (#/$defs/x, #/definitions/x) to a rule
+func (c *schemaCompiler) resolveRef(ref string) (string, error) {
+    if name, ok := c.refs[ref]; ok { return name, nil }
+    if !strings.HasPrefix(ref, "#/") { return "", fmt.Errorf("json schema: only local $ref is supported, got %q", ref) }
+    var node interface{} = c.doc
+    for _, part := range strings.Split(ref[2:], "/") {
+        m, ok := node.(map[string]interface{})
+        if !ok { return "", fmt.Errorf("json schema: cannot resolve %q", ref) }
+        node = m[strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")]
+    }
+    target, ok := node.(map[string]interface{})
+    if !ok { return "", fmt.Errorf("json schema: cannot resolve %q", ref) }
+    // Reserve the name first so recursive schemas terminate
+    name := c.reserve("ref-" + ref[strings.LastIndex(ref, "/")+1:])
+    c.refs[ref] = name
+    body, err := c.visit(target, name)
+    if err != nil { return "", err }
+    c.rules[name] = body
+    return name, nil
+}
+
+// orderKey stores the source key order of every decoded JSON object, since
+// Go maps lose it and properties must be emitted in declared order
+const orderKey = "\x00order"
+
+// decodeOrdered decodes one JSON value, recording object key order
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+    tok, err := dec.Token()
+    if err != nil { return nil, err }
+    d, ok := tok.(json.Delim)
+    if !ok { return tok, nil }
+    switch d {
+    case '{':
+        m := make(map[string]interface{})
+        var keys []string
+        for dec.More() {
+            kt, err := dec.Token()
+            if err != nil { return nil, err }
+            k := kt.(string)
+            if m[k], err = decodeOrdered(dec); err != nil { return nil, err }
+            keys = append(keys, k)
+        }
+        m[orderKey] = keys
+        _, err = dec.Token()
+        return m, err
+    case '[':
+        arr := make([]interface{}, 0)
+        for dec.More() {
+            v, err := decodeOrdered(dec)
+            if err != nil { return nil, err }
+            arr = append(arr, v)
+        }
+        _, err = dec.Token()
+        return arr, err
+    }
+    return nil, fmt.Errorf("unexpected %v", d)
+}
+
+// propertyOrder returns object keys in source order when known, else sorted
+func propertyOrder(m map[string]interface{}) []string {
+    if keys, ok := m[orderKey].([]string); ok { return keys }
+    keys := make([]string, 0, len(m))
+    for k := range m { keys = append(keys, k) }
+    sort.Strings(keys)
+    return keys
+}
+
+// marshalOrdered renders v as compact JSON, keeping object key order
+func marshalOrdered(v interface{}) ([]byte, error) {
+    switch t := v.(type) {
+    case map[string]interface{}:
+        var b bytes.Buffer
+        b.WriteByte('{')
+        for i, k := range propertyOrder(t) {
+            if i > 0 { b.WriteByte(',') }
+            kb, _ := json.Marshal(k)
+            vb, err := marshalOrdered(t[k])
+            if err != nil { return nil, err }
+            b.Write(kb)
+            b.WriteByte(':')
+            b.Write(vb)
+        }
+        b.WriteByte('}')
+        return b.Bytes(), nil
+    case []interface{}:
+        var b bytes.Buffer
+        b.WriteByte('[')
+        for i, e := range t {
+            if i > 0 { b.WriteByte(',') }
+            eb, err := marshalOrdered(e)
+            if err != nil { return nil, err }
+            b.Write(eb)
+        }
+        b.WriteByte(']')
+        return b.Bytes(), nil
+    }
+    return json.Marshal(v)
+}
+
+// jsonLiteral renders v as JSON and quotes it as a GBNF string literal
+func jsonLiteral(v interface{}) (string, error) {
+    b, err := marshalOrdered(v)
+    if err != nil { return "", fmt.Errorf("json schema: %v", err) }
+    var sb strings.Builder
+    sb.WriteByte('"')
+    for _, r := range string(b) {
+        switch {
+        case r == '"' || r == '\\':
+            sb.WriteByte('\\')
+            sb.WriteRune(r)
+        case r < 0x20:
+            sb.WriteString(`\x` + strconv.FormatInt(int64(r)+0x100, 16)[1:])
+        default:
+            sb.WriteRune(r)
+        }
+    }
+    sb.WriteByte('"')
+    return sb.String(), nil
+}
+
+func intKeyword(s map[string]interface{}, key string, def int) int {
+    if v, ok := s[key].(float64); ok { return int(v) }
+    return def
+}
+
+func copySchema(s map[string]interface{}) map[string]interface{} {
+    out := make(map[string]interface{}, len(s))
+    for k, v := range s { out[k] = v }
+    return out
+}
@@ -0,0 +1,167 @@
+package grammar
+
+import (
+    "strconv"
+    "strings"
+    "testing"
+)
+
+func compileSchema(t *testing.T, schema string) *Grammar {
+    t.Helper()
+    src, err := FromJSONSchema([]byte(schema))
+    if err != nil { t.Fatalf("%s: %v", schema, err) }
+    g, err := Parse(src)
+    if err != nil { t.Fatalf("%s: generated grammar does not parse: %v\n%s", schema, err, src) }
+    return g
+}
+
+func TestFromJSONSchemaGolden(t *testing.T) {
+    tests := []struct {
+        schema string
+        want   string
+    }{
+        {`{"type": "integer", "minimum": 5, "maximum": 123}`, `root ::= root-int
+root-int ::= [5-9] | [1-9] [0-9] | "1" ( [0-1] [0-9] | "2" [0-3] )
+`},
+        {`{"type": "integer", "exclusiveMinimum": -20, "exclusiveMaximum": 0}`, `root ::= root-int
+root-int ::= "-" ( [1-9] | "1" [0-9] )
+`},
+        {`{"type": "array", "items": {"enum": ["a", 1]}, "minItems": 1, "maxItems": 2}`, `root ::= "[" ws root-item ( ws "," ws root-item ){0,1} ws "]"
+root-item ::= ( "\"a\"" | "1" )
+ws ::= | " " | "\n" [ \t]{0,20}
+`},
+        {`{"type": "object", "properties": {"id": {"type": "integer", "minimum": 0}, "tag": {"const": "x"}}, "required": ["id"]}`,
+            `root ::= "{" ws root-props-0-0 ws "}"
+root-id-int ::= [0-9] | [1-9] [0-9]{1,15}
+root-id-kv ::= "\"id\"" ws ":" ws root-id-int
+root-props-0-0 ::= root-id-kv root-props-1-1
+root-props-0-1 ::= ws "," ws root-id-kv root-props-1-1
+root-props-1-0 ::= ( root-tag-kv "" ) | ""
+root-props-1-1 ::= ( ws "," ws root-tag-kv "" ) | ""
+root-tag-kv ::= "\"tag\"" ws ":" ws "\"x\""
+ws ::= | " " | "\n" [ \t]{0,20}
+`},
+    }
+    for _, tt := range tests {
+        got, err := FromJSONSchema([]byte(tt.schema))
+        if err != nil { t.Errorf("%s: %v", tt.schema, err); continue }
+        if got != tt.want { t.Errorf("%s:\ngot:\n%s\nwant:\n%s", tt.schema, got, tt.want) }
+    }
+}
+
+func TestFromJSONSchemaAccepts(t *testing.T) {
+    tests := []struct {
+        name   string
+        schema string
+        good   []string
+        bad    []string
+    }{
+        {"required and optional", `{"type": "object", "properties": {"a": {"type": "integer"}, "b": {"type": "boolean"}, "c": {"type": "null"}}, "required": ["b"]}`,
+            []string{`{"b":true}`, `{"a":-3, "b":false}`, `{"b":true,"c":null}`, `{ "a":1,"b":true,"c":null }`},
+            []string{`{}`, `{"a":1}`, `{"b":true,"a":1}`, `{"b":true,}`, `{"b":true,"d":1}`}},
+        {"additional properties", `{"type": "object", "properties": {"a": {"type": "string"}}, "additionalProperties": {"type": "number"}}`,
+            []string{`{}`, `{"a":"x"}`, `{"a":"x","z":1.5}`, `{"z":2,"y":-1e3}`},
+            []string{`{"z":"x"}`, `{"a":"x","z":"y"}`, `{"a":"x",}`}},
+        {"enum", `{"enum": ["red", 2, null, {"k": [true]}]}`,
+            []string{`"red"`, `2`, `null`, `{"k":[true]}`},
+            []string{`"blue"`, `"Red"`, `20`, `{"k":[]}`}},
+        {"array", `{"type": "array", "items": {"type": "string", "maxLength": 2}, "minItems": 2}`,
+            []string{`["a",""]`, `["ab", "c", "d"]`},
+            []string{`[]`, `["a"]`, `["abc","a"]`, `["a",1]`}},
+        {"format", `{"type": "object", "properties": {"d": {"type": "string", "format": "date"}, "u": {"type": "string", "format": "uuid"}}, "required": ["d", "u"]}`,
+            []string{`{"d":"2024-02-29","u":"123e4567-e89b-12d3-a456-426614174000"}`},
+            []string{`{"d":"2024-13-01","u":"123e4567-e89b-12d3-a456-426614174000"}`, `{"d":"2024-02-29","u":"123e4567"}`}},
+        {"ref", `{"$defs": {"node": {"type": "object", "properties": {"next": {"$ref": "#/$defs/node"}}}}, "$ref": "#/$defs/node"}`,
+            []string{`{}`, `{"next":{}}`, `{"next":{"next":{}}}`},
+            []string{`{"next":1}`}},
+        {"type list", `{"type": ["integer", "null"], "maximum": 9}`,
+            []string{`null`, `9`, `-100`},
+            []string{`10`, `"9"`}},
+    }
+    for _, tt := range tests {
+        g := compileSchema(t, tt.schema)
+        for _, s := range tt.good {
+            if !matches(g, s) { t.Errorf("%s: rejects %s", tt.name, s) }
+        }
+        for _, s := range tt.bad {
+            if matches(g, s) { t.Errorf("%s: accepts %s", tt.name, s) }
+        }
+    }
+}
+
+// TestIntegerBounds checks every integer in -1200..1200 against each range
+func TestIntegerBounds(t *testing.T) {
+    tests := []struct {
+        schema string
+        in     func(n int) bool
+    }{
+        {`"minimum": 0`, func(n int) bool { return n >= 0 }},
+        {`"minimum": 7`, func(n int) bool { return n >= 7 }},
+        {`"minimum": -15`, func(n int) bool { return n >= -15 }},
+        {`"maximum": 100`, func(n int) bool { return n <= 100 }},
+        {`"maximum": -3`, func(n int) bool { return n <= -3 }},
+        {`"minimum": 5, "maximum": 123`, func(n int) bool { return n >= 5 && n <= 123 }},
+        {`"minimum": 90, "maximum": 1005`, func(n int) bool { return n >= 90 && n <= 1005 }},
+        {`"minimum": -1100, "maximum": -99`, func(n int) bool { return n >= -1100 && n <= -99 }},
+        {`"minimum": -7, "maximum": 42`, func(n int) bool { return n >= -7 && n <= 42 }},
+        {`"minimum": 300, "maximum": 300`, func(n int) bool { return n == 300 }},
+        {`"exclusiveMinimum": 0, "exclusiveMaximum": 10`, func(n int) bool { return n > 0 && n < 10 }},
+        // fractional bounds round inwards; the tighter of two bounds wins
+        {`"minimum": 1.5, "maximum": 20.5`, func(n int) bool { return n >= 2 && n <= 20 }},
+        {`"minimum": 3, "exclusiveMinimum": 5, "maximum": 8`, func(n int) bool { return n > 5 && n <= 8 }},
+    }
+    for _, tt := range tests {
+        g := compileSchema(t, `{"type": "integer", `+tt.schema+`}`)
+        for n := -1200; n <= 1200; n++ {
+            if got := matches(g, strconv.Itoa(n)); got != tt.in(n) {
+                t.Errorf("{%s}: %d accepted = %v", tt.schema, n, got)
+            }
+        }
+        // out-of-range values stay rejected far from the bounds too
+        if tt.in(-1200) != matches(g, "-123456789") || tt.in(1200) != matches(g, "123456789") {
+            t.Errorf("{%s}: wrong for large magnitudes", tt.schema)
+        }
+        if matches(g, "007") || matches(g, "-0") { t.Errorf("{%s}: accepts leading zeros", tt.schema) }
+    }
+}
+
+func TestFromJSONSchemaErrors(t *testing.T) {
+    tests := []struct {
+        schema, err string
+    }{
+        {`{"type": "number", "minimum": 0}`, "minimum on numbers is not supported"},
+        {`{"type": "number", "exclusiveMaximum": 1}`, "exclusiveMaximum on numbers is not supported"},
+        {`{"type": "integer", "multipleOf": 2}`, "multipleOf is not supported"},
+        {`{"type": "integer", "minimum": 5, "maximum": 4}`, "empty integer range"},
+        {`{"type": "integer", "exclusiveMinimum": true}`, "exclusiveMinimum must be a number"},
+        {`{"type": "integer", "maximum": 1e20}`, "out of range"},
+        {`{"type": "string", "format": "hostname"}`, `unsupported string format "hostname"`},
+        {`{"type": "string", "pattern": "a+"}`, "pattern is not supported"},
+        {`{"allOf": [{"type": "string"}]}`, "allOf is not supported"},
+        {`{"$ref": "other.json#/a"}`, "only local $ref"},
+        {`{"type": "tuple"}`, `unsupported type "tuple"`},
+    }
+    for _, tt := range tests {
+        _, err := FromJSONSchema([]byte(tt.schema))
+        if err == nil || !strings.Contains(err.Error(), tt.err) {
+            t.Errorf("%s: got %v, want an error containing %q", tt.schema, err, tt.err)
+        }
+    }
+}
+
+func TestJSONObjectGrammar(t *testing.T) {
+    g, err := Parse(JSONObjectGrammar())
+    if err != nil { t.Fatal(err) }
+    for _, s := range []string{`{}`, `{"a":[1,2.5,-3e2,"x\n\u00e9"],"b":{"c":null,"d":true}}`, "{\n  \"k\": false\n}"} {
+        if !matches(g, s) { t.Errorf("rejects %s", s) }
+    }
+    for _, s := range []string{`[]`, `"x"`, `{"a":}`, `{"a":1,}`, `{a:1}`, `{} `, `{"a":01}`} {
+        if matches(g, s) { t.Errorf("accepts %s", s) }
+    }
+    // generation stops once the object closes
+    m := NewMatcher(g, byteVocab)
+    for _, c := range []byte(`{"a":1}`) {
+        if err := m.Accept(int(c)); err != nil { t.Fatal(err) }
+    }
+    if !m.Done() { t.Error("not Done after the top-level object closes") }
+}
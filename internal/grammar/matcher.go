@@ -0,0 +1,196 @@
+package grammar
+
+import (
+    "encoding/binary"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// maxCachedMasks bounds the per-matcher cache of allowed-token lists
+const maxCachedMasks = 64
+
+// pos points at the next element to match: rules[rule][alt][idx]
+type pos struct{ rule, alt, idx int32 }
+
+// stack is one parse path; the top is the next element, frames below are
+// the continuations of the enclosing rules. An empty stack has matched root.
+type stack []pos
+
+// Matcher tracks the parse state of a single sequence. It implements the
+// sampling.Constraint interface.
+type Matcher struct {
+    g      *Grammar
+    vocab  *Vocab
+    stacks []stack
+    ended  bool
+    cache  map[string][]int
+}
+
+// NewMatcher creates a matcher positioned at the start of the grammar
+func NewMatcher(g *Grammar, v *Vocab) *Matcher {
+    var stacks []stack
+    for ai, a := range g.rules[g.root] {
+        if len(a) == 0 {
+            stacks = append(stacks, stack{})
+            continue
+        }
+        g.expand(stack{{int32(g.root), int32(ai), 0}}, &stacks)
+    }
+    return &Matcher{g: g, vocab: v, stacks: dedupe(stacks), cache: make(map[string][]int)}
+}
+
+// Accept advances the parse state past tokenID
+func (m *Matcher) Accept(tokenID int) error {
+    if m.ended { return fmt.Errorf("grammar: token %d after end of input", tokenID) }
+    if tokenID == m.vocab.eosID {
+        if !m.canEnd() { return fmt.Errorf("grammar: end of sequence before grammar is complete") }
+        m.ended = true
+        m.stacks = nil
+        return nil
+    }
+    b := m.vocab.TokenBytes(tokenID)
+    if len(b) == 0 { return fmt.Errorf("grammar: token %d has no text", tokenID) }
+    stacks := m.stacks
+    for _, c := range b {
+        if stacks = m.g.acceptByte(stacks, c); len(stacks) == 0 {
+            return fmt.Errorf("grammar: token %d (%q) does not match", tokenID, b)
+        }
+    }
+    m.stacks = stacks
+    return nil
+}
+
+// Done reports whether the input is complete and no token can follow
+func (m *Matcher) Done() bool {
+    if m.ended { return true }
+    for _, st := range m.stacks {
+        if len(st) > 0 { return false }
+    }
+    return len(m.stacks) > 0
+}
+
+// Allowed marks every token that keeps the parse valid. EOS is allowed once
+// root has been fully matched.
+func (m *Matcher) Allowed(allowed []bool) {
+    if m.ended { return }
+    key := stacksKey(m.stacks)
+    ids, ok := m.cache[key]
+    if !ok {
+        m.walk(m.vocab.root, m.stacks, &ids)
+        if len(m.cache) >= maxCachedMasks { m.cache = make(map[string][]int) }
+        m.cache[key] = ids
+    }
+    for _, id := range ids {
+        if id < len(allowed) { allowed[id] = true }
+    }
+    if eos := m.vocab.eosID; m.canEnd() && eos >= 0 && eos < len(allowed) {
+        allowed[eos] = true
+    }
+}
+
+// walk descends the token trie, pruning every subtree no parse path accepts
+func (m *Matcher) walk(n *trieNode, stacks []stack, ids *[]int) {
+    for _, e := range n.edges {
+        next := m.g.acceptByte(stacks, e.b)
+        if len(next) == 0 { continue }
+        *ids = append(*ids, e.node.tokens...)
+        m.walk(e.node, next, ids)
+    }
+}
+
+// canEnd reports whether some parse path has matched root completely
+func (m *Matcher) canEnd() bool {
+    for _, st := range m.stacks {
+        if len(st) == 0 { return true }
+    }
+    return false
+}
+
+// acceptByte advances every stack whose top byte set contains b
+func (g *Grammar) acceptByte(stacks []stack, b byte) []stack {
+    var out []stack
+    for _, st := range stacks {
+        if len(st) == 0 { continue }
+        top := st[len(st)-1]
+        if !g.rules[top.rule][top.alt][top.idx].set.has(b) { continue }
+        g.expand(g.advance(st), &out)
+    }
+    if len(out) > 1 { out = dedupe(out) }
+    return out
+}
+
+// expand resolves rule references on top of st until a byte set is on top
+// (or the stack is empty) and appends the resulting stacks to out
+func (g *Grammar) expand(st stack, out *[]stack) {
+    if len(st) == 0 {
+        *out = append(*out, st)
+        return
+    }
+    top := st[len(st)-1]
+    e := g.rules[top.rule][top.alt][top.idx]
+    if e.ref < 0 {
+        *out = append(*out, st)
+        return
+    }
+    rest := g.advance(st)
+    for ai, a := range g.rules[e.ref] {
+        if len(a) == 0 {
+            g.expand(rest, out)
+            continue
+        }
+        next := make(stack, len(rest), len(rest)+1)
+        copy(next, rest)
+        g.expand(append(next, pos{int32(e.ref), int32(ai), 0}), out)
+    }
+}
+
+// advance returns a copy of st with its top element consumed
+func (g *Grammar) advance(st stack) stack {
+    top := st[len(st)-1]
+    out := make(stack, len(st)-1, len(st))
+    copy(out, st)
+    if int(top.idx)+1 < len(g.rules[top.rule][top.alt]) {
+        out = append(out, pos{top.rule, top.alt, top.idx + 1})
+    }
+    return out
+}
+
+// stackKey encodes a stack as a string for deduplication and caching
+func stackKey(st stack) string {
+    buf := make([]byte, 0, len(st)*12)
+    for _, p := range st {
+        buf = binary.LittleEndian.AppendUint32(buf, uint32(p.rule))
+        buf = binary.LittleEndian.AppendUint32(buf, uint32(p.alt))
+        buf = binary.LittleEndian.AppendUint32(buf, uint32(p.idx))
+    }
+    return string(buf)
+}
+
+// stacksKey is an order-independent key for a set of stacks
+func stacksKey(stacks []stack) string {
+    keys := make([]string, len(stacks))
+    for i, st := range stacks { keys[i] = stackKey(st) }
+    sort.Strings(keys)
+    var b strings.Builder
+    for _, k := range keys {
+        b.WriteString(strconv.Itoa(len(k)))
+        b.WriteByte(':')
+        b.WriteString(k)
+    }
+    return b.String()
+}
+
+// dedupe removes duplicate stacks, which ambiguous grammars produce
+func dedupe(stacks []stack) []stack {
+    seen := make(map[string]struct{}, len(stacks))
+    out := stacks[:0]
+    for _, st := range stacks {
+        k := stackKey(st)
+        if _, ok := seen[k]; ok { continue }
+        seen[k] = struct{}{}
+        out = append(out, st)
+    }
+    return out
+}
@@ -0,0 +1,105 @@
+package grammar
+
+import (
+    "reflect"
+    "testing"
+)
+
+// tokenVocab splits "é" (C3 A9) across tokens 3 and 4 and also has it whole;
+// 6 is EOS and 7 a special token with no text
+var tokenVocab = NewVocab([][]byte{
+    []byte("a"), []byte("b"), []byte("ab"), {0xc3}, {0xa9}, []byte("é"), nil, nil,
+}, 6)
+
+func allowedIDs(m *Matcher) []int {
+    mask := make([]bool, tokenVocab.Size())
+    m.Allowed(mask)
+    var ids []int
+    for id, ok := range mask {
+        if ok { ids = append(ids, id) }
+    }
+    return ids
+}
+
+func TestMatcherMasks(t *testing.T) {
+    g, err := Parse(`root ::= "a" [é]+ "b"`)
+    if err != nil { t.Fatal(err) }
+    m := NewMatcher(g, tokenVocab)
+    steps := []struct {
+        allowed []int
+        accept  int
+    }{
+        // "ab" would need é after the a
+        {[]int{0}, 0},
+        // é whole or its lead byte, never a bare continuation byte
+        {[]int{3, 5}, 3},
+        {[]int{4}, 4},
+        {[]int{1, 3, 5}, 5},
+        {[]int{1, 3, 5}, 1},
+        // root has closed: only EOS remains
+        {[]int{6}, 6},
+    }
+    for i, s := range steps {
+        if got := allowedIDs(m); !reflect.DeepEqual(got, s.allowed) {
+            t.Fatalf("step %d: allowed %v, want %v", i, got, s.allowed)
+        }
+        if m.Done() != (s.accept == 6) { t.Errorf("step %d: Done() = %v before the root closes", i, m.Done()) }
+        if err := m.Accept(s.accept); err != nil { t.Fatalf("step %d: %v", i, err) }
+    }
+    if !m.Done() { t.Error("not Done after EOS") }
+    if err := m.Accept(0); err == nil { t.Error("token accepted after EOS") }
+}
+
+func TestMatcherRejects(t *testing.T) {
+    g, err := Parse(`root ::= "a" [é]`)
+    if err != nil { t.Fatal(err) }
+    tests := []struct {
+        name   string
+        prefix []int
+        token  int
+    }{
+        {"mismatch", nil, 1},
+        {"stray continuation byte", []int{0}, 4},
+        {"eos before the root closes", []int{0, 3}, 6},
+        {"special token", nil, 7},
+    }
+    for _, tt := range tests {
+        m := NewMatcher(g, tokenVocab)
+        for _, id := range tt.prefix {
+            if err := m.Accept(id); err != nil { t.Fatalf("%s: prefix: %v", tt.name, err) }
+        }
+        before := allowedIDs(m)
+        if err := m.Accept(tt.token); err == nil { t.Errorf("%s: token %d accepted", tt.name, tt.token) }
+        // a rejected token leaves the state untouched
+        if got := allowedIDs(m); !reflect.DeepEqual(got, before) {
+            t.Errorf("%s: allowed %v after the rejected token, want %v", tt.name, got, before)
+        }
+    }
+}
+
+// TestMatcherDoneWithOpenTail: a root that may continue is not Done, even
+// though EOS is already allowed
+func TestMatcherDoneWithOpenTail(t *testing.T) {
+    g, err := Parse(`root ::= "a" "b"?`)
+    if err != nil { t.Fatal(err) }
+    m := NewMatcher(g, tokenVocab)
+    if err := m.Accept(0); err != nil { t.Fatal(err) }
+    if got := allowedIDs(m); !reflect.DeepEqual(got, []int{1, 6}) { t.Errorf("allowed %v, want [1 6]", got) }
+    if m.Done() { t.Error("Done() with an optional tail left") }
+    if err := m.Accept(1); err != nil { t.Fatal(err) }
+    if !m.Done() { t.Error("not Done after the root closes") }
+}
+
+// TestMatcherMaskCache: masks are cached per parse state, so returning to
+// the start of the loop reuses the first mask instead of leaking another
+func TestMatcherMaskCache(t *testing.T) {
+    g, err := Parse(`root ::= ("a" | "b" "é")*`)
+    if err != nil { t.Fatal(err) }
+    m := NewMatcher(g, tokenVocab)
+    want := [][]int{{0, 1, 2, 6}, {0, 1, 2, 6}, {3, 5}, {0, 1, 2, 6}}
+    for i, id := range []int{0, 1, 5, 2} {
+        if got := allowedIDs(m); !reflect.DeepEqual(got, want[i]) { t.Fatalf("step %d: allowed %v, want %v", i, got, want[i]) }
+        if err := m.Accept(id); err != nil { t.Fatalf("step %d: %v", i, err) }
+    }
+    if len(m.cache) != 2 { t.Errorf("%d cached masks, want 2", len(m.cache)) }
+}
@@ -0,0 +1,293 @@
+package grammar
+
+import (
+    "fmt"
+    "regexp"
+    "regexp/syntax"
+    "sort"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+// DFA is a byte-level automaton for a regular expression, matched against
+// the whole output. It is built lazily by subset construction from a
+// Thompson NFA whose transitions are UTF-8 byte ranges.
+type DFA struct {
+    nfa    []nfaState
+    states []dfaState
+    index  map[string]int
+    masks  map[int][]int // allowed token ids per DFA state, filled on demand
+}
+
+type nfaState struct {
+    ranges []byteRange // byte transitions to next
+    next   int
+    eps    []int
+    match  bool
+}
+
+type dfaState struct {
+    nfa   []int
+    match bool
+    trans [256]int32 // -2 = not computed, -1 = dead
+}
+
+const (
+    dfaUnknown = -2
+    dfaDead    = -1
+)
+
+// CompileRegex compiles a Go (RE2) regular expression. The output must
+// match the whole pattern; ^ and $ are accepted but redundant.
+func CompileRegex(pattern string) (*DFA, error) {
+    re, err := syntax.Parse(pattern, syntax.Perl)
+    if err != nil { return nil, fmt.Errorf("regex: %v", err) }
+    b := &nfaBuilder{}
+    start, end, err := b.build(re.Simplify())
+    if err != nil { return nil, err }
+    b.states[end].match = true
+    d := &DFA{nfa: b.states, index: make(map[string]int), masks: make(map[int][]int)}
+    d.state(d.closure([]int{start}))
+    return d, nil
+}
+
+// CompileChoices builds a DFA matching exactly one of the given strings
+func CompileChoices(choices []string) (*DFA, error) {
+    if len(choices) == 0 { return nil, fmt.Errorf("regex: empty choice list") }
+    quoted := make([]string, len(choices))
+    for i, c := range choices { quoted[i] = regexp.QuoteMeta(c) }
+    return CompileRegex(strings.Join(quoted, "|"))
+}
+
+// closure returns the sorted epsilon closure of a set of NFA states
+func (d *DFA) closure(set []int) []int {
+    seen := make(map[int]bool)
+    stack := append([]int(nil), set...)
+    for len(stack) > 0 {
+        s := stack[len(stack)-1]
+        stack = stack[:len(stack)-1]
+        if seen[s] { continue }
+        seen[s] = true
+        stack = append(stack, d.nfa[s].eps...)
+    }
+    out := make([]int, 0, len(seen))
+    for s := range seen { out = append(out, s) }
+    sort.Ints(out)
+    return out
+}
+
+// state interns a closed NFA state set and returns its DFA state id
+func (d *DFA) state(set []int) int {
+    key := fmt.Sprint(set)
+    if id, ok := d.index[key]; ok { return id }
+    ds := dfaState{nfa: set}
+    for i := range ds.trans { ds.trans[i] = dfaUnknown }
+    for _, s := range set {
+        if d.nfa[s].match { ds.match = true }
+    }
+    d.states = append(d.states, ds)
+    d.index[key] = len(d.states) - 1
+    return len(d.states) - 1
+}
+
+// step returns the state after consuming b, or dfaDead
+func (d *DFA) step(from int, b byte) int {
+    if t := d.states[from].trans[b]; t != dfaUnknown { return int(t) }
+    var next []int
+    for _, s := range d.states[from].nfa {
+        for _, r := range d.nfa[s].ranges {
+            if b >= r.lo && b <= r.hi {
+                next = append(next, d.nfa[s].next)
+                break
+            }
+        }
+    }
+    to := dfaDead
+    if len(next) > 0 { to = d.state(d.closure(next)) }
+    d.states[from].trans[b] = int32(to)
+    return to
+}
+
+// allowed returns the ids of tokens that keep the DFA alive from state s
+func (d *DFA) allowed(s int, v *Vocab) []int {
+    if ids, ok := d.masks[s]; ok { return ids }
+    var ids []int
+    var walk func(n *trieNode, s int)
+    walk = func(n *trieNode, s int) {
+        for _, e := range n.edges {
+            t := d.step(s, e.b)
+            if t == dfaDead { continue }
+            ids = append(ids, e.node.tokens...)
+            walk(e.node, t)
+        }
+    }
+    walk(v.root, s)
+    d.masks[s] = ids
+    return ids
+}
+
+// final reports whether s accepts and has no live transitions
+func (d *DFA) final(s int) bool {
+    if !d.states[s].match { return false }
+    for b := 0; b < 256; b++ {
+        if d.step(s, byte(b)) != dfaDead { return false }
+    }
+    return true
+}
+
+// RegexMatcher tracks one sequence's position in a DFA. It implements the
+// sampling.Constraint interface.
+type RegexMatcher struct {
+    dfa   *DFA
+    vocab *Vocab
+    state int
+    ended bool
+}
+
+// NewRegexMatcher creates a matcher at the DFA start state
+func NewRegexMatcher(d *DFA, v *Vocab) *RegexMatcher {
+    return &RegexMatcher{dfa: d, vocab: v}
+}
+
+// Allowed marks tokens that keep a full match possible; EOS once matched
+func (m *RegexMatcher) Allowed(allowed []bool) {
+    if m.ended { return }
+    for _, id := range m.dfa.allowed(m.state, m.vocab) {
+        if id < len(allowed) { allowed[id] = true }
+    }
+    if eos := m.vocab.eosID; m.dfa.states[m.state].match && eos >= 0 && eos < len(allowed) {
+        allowed[eos] = true
+    }
+}
+
+// Accept advances the DFA over the token's bytes
+func (m *RegexMatcher) Accept(tokenID int) error {
+    if m.ended { return fmt.Errorf("regex: token %d after end of input", tokenID) }
+    if tokenID == m.vocab.eosID {
+        if !m.dfa.states[m.state].match { return fmt.Errorf("regex: end of sequence before a full match") }
+        m.ended = true
+        return nil
+    }
+    b := m.vocab.TokenBytes(tokenID)
+    if len(b) == 0 { return fmt.Errorf("regex: token %d has no text", tokenID) }
+    s := m.state
+    for _, c := range b {
+        if s = m.dfa.step(s, c); s == dfaDead {
+            return fmt.Errorf("regex: token %d (%q) does not match", tokenID, b)
+        }
+    }
+    m.state = s
+    return nil
+}
+
+// Done reports whether the output fully matches and cannot be extended
+func (m *RegexMatcher) Done() bool {
+    return m.ended || m.dfa.final(m.state)
+}
+
+// nfaBuilder compiles a simplified regexp/syntax tree into a byte NFA
+type nfaBuilder struct {
+    states []nfaState
+}
+
+func (b *nfaBuilder) add() int {
+    b.states = append(b.states, nfaState{})
+    return len(b.states) - 1
+}
+
+func (b *nfaBuilder) eps(from, to int) {
+    b.states[from].eps = append(b.states[from].eps, to)
+}
+
+// build returns the start and end states of a fragment for re
+func (b *nfaBuilder) build(re *syntax.Regexp) (int, int, error) {
+    switch re.Op {
+    case syntax.OpEmptyMatch, syntax.OpBeginText, syntax.OpEndText, syntax.OpBeginLine, syntax.OpEndLine:
+        // Anchors are implied: the output is always matched as a whole
+        s := b.add()
+        return s, s, nil
+    case syntax.OpNoMatch:
+        return b.add(), b.add(), nil
+    case syntax.OpLiteral:
+        start := b.add()
+        end := start
+        for _, r := range re.Rune {
+            ranges := []runeRange{{r, r}}
+            if re.Flags&syntax.FoldCase != 0 {
+                for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+                    ranges = append(ranges, runeRange{f, f})
+                }
+            }
+            end = b.class(end, ranges)
+        }
+        return start, end, nil
+    case syntax.OpCharClass:
+        var ranges []runeRange
+        for i := 0; i+1 < len(re.Rune); i += 2 {
+            ranges = append(ranges, runeRange{re.Rune[i], re.Rune[i+1]})
+        }
+        start := b.add()
+        return start, b.class(start, ranges), nil
+    case syntax.OpAnyCharNotNL:
+        start := b.add()
+        return start, b.class(start, []runeRange{{0, '\n' - 1}, {'\n' + 1, utf8.MaxRune}}), nil
+    case syntax.OpAnyChar:
+        start := b.add()
+        return start, b.class(start, []runeRange{{0, utf8.MaxRune}}), nil
+    case syntax.OpCapture:
+        return b.build(re.Sub[0])
+    case syntax.OpConcat:
+        start := b.add()
+        end := start
+        for _, sub := range re.Sub {
+            s, e, err := b.build(sub)
+            if err != nil { return 0, 0, err }
+            b.eps(end, s)
+            end = e
+        }
+        return start, end, nil
+    case syntax.OpAlternate:
+        start, end := b.add(), b.add()
+        for _, sub := range re.Sub {
+            s, e, err := b.build(sub)
+            if err != nil { return 0, 0, err }
+            b.eps(start, s)
+            b.eps(e, end)
+        }
+        return start, end, nil
+    case syntax.OpStar, syntax.OpPlus, syntax.OpQuest:
+        s, e, err := b.build(re.Sub[0])
+        if err != nil { return 0, 0, err }
+        start, end := b.add(), b.add()
+        b.eps(start, s)
+        b.eps(e, end)
+        if re.Op != syntax.OpPlus { b.eps(start, end) }
+        if re.Op != syntax.OpQuest { b.eps(e, s) }
+        return start, end, nil
+    }
+    return 0, 0, fmt.Errorf("regex: unsupported construct %v", re)
+}
+
+// class adds transitions from `from` matching any code point in ranges and
+// returns the common end state
+func (b *nfaBuilder) class(from int, ranges []runeRange) int {
+    end := b.add()
+    for _, r := range normalizeRanges(ranges) {
+        for _, seq := range utf8Sequences(r.lo, r.hi) {
+            cur := from
+            for i, br := range seq {
+                next := end
+                if i < len(seq)-1 { next = b.add() }
+                // each NFA state has a single byte-transition target, so
+                // hang every range off its own epsilon-reachable state
+                t := b.add()
+                b.eps(cur, t)
+                b.states[t].ranges = []byteRange{br}
+                b.states[t].next = next
+                cur = next
+            }
+        }
+    }
+    return end
+}
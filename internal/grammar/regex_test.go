@@ -0,0 +1,142 @@
+package grammar
+
+import (
+    "reflect"
+    "testing"
+)
+
+// regexMatches reports whether d accepts exactly the input, fed one byte at
+// a time and closed with EOS
+func regexMatches(d *DFA, input string) bool {
+    m := NewRegexMatcher(d, byteVocab)
+    for i := 0; i < len(input); i++ {
+        if m.Accept(int(input[i])) != nil { return false }
+    }
+    return m.Accept(byteEOS) == nil
+}
+
+func TestCompileRegex(t *testing.T) {
+    tests := []struct {
+        pattern string
+        good    []string
+        bad     []string
+    }{
+        {`(yes|no)`, []string{"yes", "no"}, []string{"", "y", "ye", "yesno", "nope", "Yes"}},
+        {`\d{4}-(0[1-9]|1[0-2])-(0[1-9]|[12]\d|3[01])`, []string{"2024-02-29", "1999-12-31", "0000-01-01"},
+            []string{"2024-13-01", "2024-00-10", "2024-1-01", "2024-01-32", "24-01-01", "2024-01-01T"}},
+        {`^[a-c]+x?$`, []string{"a", "cab", "abx"}, []string{"", "x", "abxx", "d"}},
+        {`(?i)ab`, []string{"ab", "AB", "aB"}, []string{"abc"}},
+        {`é+|ü`, []string{"é", "ééé", "ü"}, []string{"", "e", "éü"}},
+        {`.{2}`, []string{"ab", "日本", "a😀"}, []string{"a", "abc", "a\n"}},
+        {`[^a-z]`, []string{"A", "é"}, []string{"a", ""}},
+        {`a*`, []string{"", "aaa"}, []string{"b"}},
+    }
+    for _, tt := range tests {
+        d, err := CompileRegex(tt.pattern)
+        if err != nil { t.Errorf("%s: %v", tt.pattern, err); continue }
+        for _, s := range tt.good {
+            if !regexMatches(d, s) { t.Errorf("%s rejects %q", tt.pattern, s) }
+        }
+        for _, s := range tt.bad {
+            if regexMatches(d, s) { t.Errorf("%s accepts %q", tt.pattern, s) }
+        }
+    }
+    if _, err := CompileRegex(`(a`); err == nil { t.Error("unbalanced regex compiled") }
+    if _, err := CompileChoices(nil); err == nil { t.Error("empty choice list compiled") }
+}
+
+// TestCompileChoicesPrefix: when one choice is a prefix of another, the
+// shorter one may end the output but does not force it to
+func TestCompileChoicesPrefix(t *testing.T) {
+    d, err := CompileChoices([]string{"no", "none", "a.b"})
+    if err != nil { t.Fatal(err) }
+    for _, s := range []string{"no", "none", "a.b"} {
+        if !regexMatches(d, s) { t.Errorf("rejects %q", s) }
+    }
+    // choices are literal: "." is not a wildcard
+    for _, s := range []string{"non", "nonee", "axb", ""} {
+        if regexMatches(d, s) { t.Errorf("accepts %q", s) }
+    }
+
+    m := NewRegexMatcher(d, byteVocab)
+    for _, c := range []byte("no") {
+        if err := m.Accept(int(c)); err != nil { t.Fatal(err) }
+    }
+    mask := make([]bool, byteVocab.Size())
+    m.Allowed(mask)
+    if !mask['n'] || !mask[byteEOS] || mask['o'] { t.Error(`after "no": want "n" and EOS allowed, nothing else`) }
+    if m.Done() { t.Error(`Done after "no" although "none" can follow`) }
+    for _, c := range []byte("ne") {
+        if err := m.Accept(int(c)); err != nil { t.Fatal(err) }
+    }
+    if !m.Done() { t.Error(`not Done after "none"`) }
+}
+
+// regexVocab has multi-byte tokens and "é" split across tokens 9 and 10
+var regexVocab = NewVocab([][]byte{
+    []byte("y"), []byte("ye"), []byte("yes"), []byte("n"), []byte("no"),
+    []byte("es"), []byte("s"), []byte("o"), nil, {0xc3}, {0xa9}, []byte("é"), []byte("yesno"), nil,
+}, 8)
+
+// maskStep is the expected mask before accepting a token
+type maskStep struct {
+    allowed []int
+    accept  int
+}
+
+func TestRegexMatcherMasks(t *testing.T) {
+    tests := []struct {
+        pattern string
+        steps   []maskStep
+    }{
+        {`(yes|no)`, []maskStep{
+            // "yesno" overshoots; "s", "es" and "o" cannot start a match
+            {[]int{0, 1, 2, 3, 4}, 0},
+            {[]int{5}, 5},
+            {[]int{8}, 8},
+        }},
+        {`(yes|no)`, []maskStep{
+            {[]int{0, 1, 2, 3, 4}, 3},
+            {[]int{7}, 7},
+            {[]int{8}, 8},
+        }},
+        {`né*`, []maskStep{
+            {[]int{3}, 3},
+            // the lead byte alone is allowed, a bare continuation byte is not
+            {[]int{8, 9, 11}, 9},
+            {[]int{10}, 10},
+            {[]int{8, 9, 11}, 11},
+            {[]int{8, 9, 11}, 8},
+        }},
+    }
+    for _, tt := range tests {
+        d, err := CompileRegex(tt.pattern)
+        if err != nil { t.Fatal(err) }
+        m := NewRegexMatcher(d, regexVocab)
+        for i, s := range tt.steps {
+            mask := make([]bool, regexVocab.Size())
+            m.Allowed(mask)
+            var got []int
+            for id, ok := range mask {
+                if ok { got = append(got, id) }
+            }
+            if !reflect.DeepEqual(got, s.allowed) { t.Fatalf("%s step %d: allowed %v, want %v", tt.pattern, i, got, s.allowed) }
+            if err := m.Accept(s.accept); err != nil { t.Fatalf("%s step %d: %v", tt.pattern, i, err) }
+        }
+        if !m.Done() { t.Errorf("%s: not Done after EOS", tt.pattern) }
+    }
+}
+
+func TestRegexMatcherRejects(t *testing.T) {
+    d, err := CompileRegex(`(yes|no)`)
+    if err != nil { t.Fatal(err) }
+    m := NewRegexMatcher(d, regexVocab)
+    for _, id := range []int{12, 6, 13, 8} {
+        if err := m.Accept(id); err == nil { t.Errorf("token %d accepted at the start", id) }
+    }
+    // the failed tokens left the state at the start
+    if err := m.Accept(2); err != nil { t.Fatal(err) }
+    if err := m.Accept(7); err == nil { t.Error("token accepted after a full match") }
+    if err := m.Accept(8); err != nil { t.Fatal(err) }
+    if err := m.Accept(0); err == nil { t.Error("token accepted after EOS") }
+}
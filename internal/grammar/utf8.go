@@ -0,0 +1,113 @@
+package grammar
+
+import (
+    "sort"
+    "unicode/utf8"
+)
+
+// byteSet is a 256-bit set of byte values
+type byteSet [4]uint64
+
+func (s *byteSet) add(b byte)          { s[b>>6] |= 1 << (b & 63) }
+func (s *byteSet) has(b byte) bool     { return s[b>>6]&(1<<(b&63)) != 0 }
+func (s *byteSet) addRange(lo, hi byte) {
+    for c := int(lo); c <= int(hi); c++ { s.add(byte(c)) }
+}
+
+// runeRange is an inclusive range of code points
+type runeRange struct{ lo, hi rune }
+
+// byteRange is an inclusive range of byte values
+type byteRange struct{ lo, hi byte }
+
+// normalizeRanges sorts and merges overlapping or adjacent ranges
+func normalizeRanges(rs []runeRange) []runeRange {
+    if len(rs) == 0 { return rs }
+    sort.Slice(rs, func(i, j int) bool { return rs[i].lo < rs[j].lo })
+    out := []runeRange{rs[0]}
+    for _, r := range rs[1:] {
+        last := &out[len(out)-1]
+        if r.lo <= last.hi+1 {
+            if r.hi > last.hi { last.hi = r.hi }
+            continue
+        }
+        out = append(out, r)
+    }
+    return out
+}
+
+// negateRanges returns the complement of rs over all code points
+func negateRanges(rs []runeRange) []runeRange {
+    rs = normalizeRanges(rs)
+    var out []runeRange
+    next := rune(0)
+    for _, r := range rs {
+        if r.lo > next { out = append(out, runeRange{next, r.lo - 1}) }
+        next = r.hi + 1
+    }
+    if next <= utf8.MaxRune { out = append(out, runeRange{next, utf8.MaxRune}) }
+    return out
+}
+
+// utf8Sequences splits a code point range into sequences of byte ranges such
+// that a byte string matches one of the sequences iff it is the UTF-8
+// encoding of a code point in [lo, hi]. Surrogates are skipped.
+func utf8Sequences(lo, hi rune) [][]byteRange {
+    var out [][]byteRange
+    todo := []runeRange{{lo, hi}}
+    for len(todo) > 0 {
+        r := todo[len(todo)-1]
+        todo = todo[:len(todo)-1]
+    split:
+        for {
+            // Surrogates have no UTF-8 encoding
+            if r.lo < 0xD800 && r.hi > 0xDFFF {
+                todo = append(todo, runeRange{0xE000, r.hi})
+                r.hi = 0xD7FF
+            } else if r.lo >= 0xD800 && r.hi <= 0xDFFF {
+                break
+            } else if r.lo >= 0xD800 && r.lo <= 0xDFFF {
+                r.lo = 0xE000
+            } else if r.hi >= 0xD800 && r.hi <= 0xDFFF {
+                r.hi = 0xD7FF
+            }
+            if r.lo > r.hi { break }
+            // Split at encoded-length boundaries
+            for _, max := range []rune{0x7F, 0x7FF, 0xFFFF} {
+                if r.lo <= max && max < r.hi {
+                    todo = append(todo, runeRange{max + 1, r.hi})
+                    r.hi = max
+                    continue split
+                }
+            }
+            if r.hi <= 0x7F {
+                out = append(out, []byteRange{{byte(r.lo), byte(r.hi)}})
+                break
+            }
+            // Split until every continuation byte spans its full range
+            for i := uint(1); i < 4; i++ {
+                m := rune(1)<<(6*i) - 1
+                if r.lo&^m != r.hi&^m {
+                    if r.lo&m != 0 {
+                        todo = append(todo, runeRange{(r.lo | m) + 1, r.hi})
+                        r.hi = r.lo | m
+                        continue split
+                    }
+                    if r.hi&m != m {
+                        todo = append(todo, runeRange{r.hi &^ m, r.hi})
+                        r.hi = (r.hi &^ m) - 1
+                        continue split
+                    }
+                }
+            }
+            var a, b [utf8.UTFMax]byte
+            n := utf8.EncodeRune(a[:], r.lo)
+            utf8.EncodeRune(b[:], r.hi)
+            seq := make([]byteRange, n)
+            for i := 0; i < n; i++ { seq[i] = byteRange{a[i], b[i]} }
+            out = append(out, seq)
+            break
+        }
+    }
+    return out
+}
@@ -0,0 +1,56 @@
+package grammar
+
+// Vocab is a byte trie over the tokenizer vocabulary. It is built once per
+// tokenizer and shared by all matchers, so masking only walks the prefixes a
+// grammar state can actually continue instead of every token.
+type Vocab struct {
+    root   *trieNode
+    tokens [][]byte
+    eosID  int
+}
+
+type trieNode struct {
+    edges  []trieEdge
+    tokens []int // ids of tokens whose bytes end at this node
+}
+
+type trieEdge struct {
+    b    byte
+    node *trieNode
+}
+
+// NewVocab builds the trie from per-id token bytes. Tokens with no bytes
+// (special tokens) are never allowed by a grammar; eosID is allowed once the
+// grammar has reached an accepting state.
+func NewVocab(tokens [][]byte, eosID int) *Vocab {
+    v := &Vocab{root: &trieNode{}, tokens: tokens, eosID: eosID}
+    for id, b := range tokens {
+        if len(b) == 0 || id == eosID { continue }
+        n := v.root
+        for _, c := range b { n = n.child(c) }
+        n.tokens = append(n.tokens, id)
+    }
+    return v
+}
+
+// Size returns the number of token ids covered by the vocabulary
+func (v *Vocab) Size() int { return len(v.tokens) }
+
+// EOS returns the end-of-sequence token id
+func (v *Vocab) EOS() int { return v.eosID }
+
+// TokenBytes returns the raw bytes of a token (nil for special tokens)
+func (v *Vocab) TokenBytes(id int) []byte {
+    if id < 0 || id >= len(v.tokens) { return nil }
+    return v.tokens[id]
+}
+
+// child returns the child for byte c, creating it if needed
+func (n *trieNode) child(c byte) *trieNode {
+    for _, e := range n.edges {
+        if e.b == c { return e.node }
+    }
+    nn := &trieNode{}
+    n.edges = append(n.edges, trieEdge{b: c, node: nn})
+    return nn
+}
@@ -55,3 +55,93 @@ func (s *SiluAndMul) Forward(input *tensor.Tensor) (*tensor.Tensor, error) {
 	
 	return output, nil
 }
+
+// NewActivation returns the element-wise activation for an HF hidden_act /
+// activation_function name
+func NewActivation(name string) (func(float32) float32, error) {
+    switch name {
+    case "silu", "swish":
+        return func(x float32) float32 { return x / (1 + float32(math.Exp(-float64(x)))) }, nil
+    case "gelu_pytorch_tanh", "gelu_tanh", "gelu_new", "gelu_fast":
+        return func(x float32) float32 { return Gelu(x, true) }, nil
+    case "gelu":
+        return func(x float32) float32 { return Gelu(x, false) }, nil
+    case "quick_gelu":
+        return func(x float32) float32 { return x / (1 + float32(math.Exp(-1.702*float64(x)))) }, nil
+    case "relu":
+        return func(x float32) float32 { return float32(math.Max(0, float64(x))) }, nil
+    }
+    return nil, fmt.Errorf("unsupported activation %q", name)
+}
+
+// GatedActivation is a fused act(gate) * up over a [T, 2*I] input
+type GatedActivation interface {
+    Forward(input *tensor.Tensor) (*tensor.Tensor, error)
+}
+
+// NewGatedActivation returns the gated activation for an HF hidden_act name
+func NewGatedActivation(hiddenAct string) (GatedActivation, error) {
+    switch hiddenAct {
+    case "silu", "swish":
+        return NewSiluAndMul(), nil
+    case "gelu_pytorch_tanh", "gelu_tanh", "gelu_new", "gelu_fast":
+        return NewGeluAndMul(true), nil
+    case "gelu":
+        return NewGeluAndMul(false), nil
+    }
+    return nil, fmt.Errorf("unsupported activation %q", hiddenAct)
+}
+
+// GeluAndMul represents GELU activation and multiplication (GeGLU)
+type GeluAndMul struct {
+    approximate bool // tanh approximation instead of erf
+}
+
+// NewGeluAndMul creates a new GeluAndMul activation
+func NewGeluAndMul(approximate bool) *GeluAndMul {
+    return &GeluAndMul{approximate: approximate}
+}
+
+// Forward performs forward pass
+func (g *GeluAndMul) Forward(input *tensor.Tensor) (*tensor.Tensor, error) {
+    shape := input.Shape()
+    if len(shape) != 2 {
+        return nil, fmt.Errorf("input must be 2D tensor")
+    }
+    batchSize, hiddenSize := shape[0], shape[1]
+    if hiddenSize%2 != 0 {
+        return nil, fmt.Errorf("hidden size must be even")
+    }
+    half := hiddenSize / 2
+    output, err := tensor.NewTensor([]int{batchSize, half}, tensor.Float32, tensor.CPU)
+    if err != nil {
+        return nil, err
+    }
+    in := input.Data().Data().([]float32)
+    out := output.Data().Data().([]float32)
+    for i := 0; i < batchSize; i++ {
+        row := in[i*hiddenSize : (i+1)*hiddenSize]
+        for j := 0; j < half; j++ {
+            out[i*half+j] = Gelu(row[j], g.approximate) * row[j+half]
+        }
+    }
+    return output, nil
+}
+
+// Gelu computes GELU(x), exactly via erf or with the tanh approximation
+func Gelu(x float32, approximate bool) float32 {
+    v := float64(x)
+    if approximate {
+        return float32(0.5 * v * (1 + math.Tanh(math.Sqrt(2/math.Pi)*(v+0.044715*v*v*v))))
+    }
+    return float32(0.5 * v * (1 + math.Erf(v/math.Sqrt2)))
+}
+
+// SoftCap squashes x in place to (-c, c) as c*tanh(x/c) (Gemma 2 attention
+// and final-logit soft-capping); c <= 0 is a no-op
+func SoftCap(x []float32, c float32) {
+    if c <= 0 { return }
+    for i, v := range x {
+        x[i] = c * float32(math.Tanh(float64(v/c)))
+    }
+}
@@ -0,0 +1,64 @@
+package layers
+
+import (
+    "math"
+    "testing"
+
+    "github.com/unixsysdev/nano-go-vllm/internal/tensor"
+)
+
+// matrix wraps row-major data as a [len(data)/cols, cols] tensor
+func matrix(t *testing.T, cols int, data ...float32) *tensor.Tensor {
+    t.Helper()
+    m, err := tensor.FromFloat32([]int{len(data) / cols, cols}, data)
+    if err != nil { t.Fatal(err) }
+    return m
+}
+
+func checkClose(t *testing.T, what string, got []float32, want []float64) {
+    t.Helper()
+    for i := range want {
+        if math.Abs(float64(got[i])-want[i]) > 1e-5 {
+            t.Fatalf("%s: got %v, want %v", what, got, want)
+        }
+    }
+}
+
+func TestSoftCap(t *testing.T) {
+    x := []float32{0, 1, -4, 100}
+    SoftCap(x, 2)
+    // 2*tanh(x/2)
+    checkClose(t, "cap 2", x, []float64{0, 0.9242343145200195, -1.9280551601516338, 2})
+    y := []float32{0, 1, -4, 100}
+    SoftCap(y, 0)
+    checkClose(t, "cap 0", y, []float64{0, 1, -4, 100})
+}
+
+// TestActivations checks every hidden_act name against HF's definitions:
+// exact (erf) GELU, the tanh approximation behind gelu_new and its aliases,
+// and x*sigmoid(1.702x)
+func TestActivations(t *testing.T) {
+    xs := []float32{-2, -0.5, 0, 1, 3}
+    exact := []float64{-0.04550026389635842, -0.15426876936299344, 0, 0.8413447460685429, 2.99595030590511}
+    tanh := []float64{-0.04540230591222494, -0.15428599017485606, 0, 0.8411919906082768, 2.996362607918227}
+    tests := []struct {
+        name string
+        want []float64
+    }{
+        {"gelu", exact},
+        {"gelu_new", tanh},
+        {"gelu_pytorch_tanh", tanh},
+        {"gelu_tanh", tanh},
+        {"gelu_fast", tanh},
+        {"quick_gelu", []float64{-0.06434137685579186, -0.1496115633936199, 0, 0.8457957659328212, 2.981928690292214}},
+        {"relu", []float64{0, 0, 0, 1, 3}},
+    }
+    for _, tt := range tests {
+        act, err := NewActivation(tt.name)
+        if err != nil { t.Fatal(err) }
+        got := make([]float32, len(xs))
+        for i, x := range xs { got[i] = act(x) }
+        checkClose(t, tt.name, got, tt.want)
+    }
+    if _, err := NewActivation("gelu_10"); err == nil { t.Error("unknown activation accepted") }
+}
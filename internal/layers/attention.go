@@ -18,12 +18,25 @@ type Attention struct {
     kProj         *Linear
     vProj         *Linear
     oProj         *Linear
-    rotaryEmbed   *RotaryEmbedding
+    qNorm, kNorm  *RMSNorm // per-head RMSNorm on q and k (Qwen3), nil if unused
+    rotaryEmbed   *RotaryEmbedding // nil for learned absolute positions (GPT-2)
+    slidingWindow int // attend to at most this many trailing positions (0 = all)
+    softCap       float32 // attention logit soft-capping (0 = off)
 
-    // minimal KV cache for single sequence
+    // KV cache of the active sequence
     kCache [][]float32 // per kv head: [tokens*headDim]
     vCache [][]float32
     cacheLen int
+
+    // caches of the other sequences, keyed by sequence id
+    active int
+    caches map[int]*kvCache
+}
+
+// kvCache is a parked per-sequence KV cache
+type kvCache struct {
+    k, v   [][]float32
+    length int
 }
 
 // Setters for loading weights from external files
@@ -32,21 +45,68 @@ func (a *Attention) SetKWeights(w []float32) error { return a.kProj.LoadWeights(
 func (a *Attention) SetVWeights(w []float32) error { return a.vProj.LoadWeights(w, nil) }
 func (a *Attention) SetOWeights(w []float32) error { return a.oProj.LoadWeights(w, nil) }
 
-// NewAttention creates a new attention layer
-func NewAttention(hiddenSize, numHeads, numKVHeads, headDim int, maxPosition int, ropeTheta float64, ropeScalingType string, ropeScalingFactor float64) (*Attention, error) {
+// SetQKVWeights loads a fused QKV projection: rows [q; k; v] of a
+// [(numHeads+2*numKVHeads)*headDim, hidden] weight and its bias (may be nil)
+func (a *Attention) SetQKVWeights(w, b []float32) error {
+    q, k, v, err := SplitQKV(w, a.numHeads*a.headDim, a.numKVHeads*a.headDim)
+    if err != nil { return err }
+    if err := a.qProj.LoadWeights(q, nil); err != nil { return err }
+    if err := a.kProj.LoadWeights(k, nil); err != nil { return err }
+    if err := a.vProj.LoadWeights(v, nil); err != nil { return err }
+    if b == nil { return nil }
+    qb, kb, vb, err := SplitQKV(b, a.numHeads*a.headDim, a.numKVHeads*a.headDim)
+    if err != nil { return err }
+    return a.SetQKVBias(qb, kb, vb)
+}
+
+// SetOBias loads the output projection bias (GPT-2)
+func (a *Attention) SetOBias(b []float32) error { return a.oProj.LoadBias(b) }
+
+// CacheLen returns the number of positions in the active KV cache
+func (a *Attention) CacheLen() int { return a.cacheLen }
+
+// rope rotates vec for position p of a seqLen-token sequence, if the layer
+// uses RoPE; positions past the table reuse its last entry
+func (a *Attention) rope(vec []float32, p, seqLen int) {
+    if a.rotaryEmbed == nil { return }
+    if p >= a.rotaryEmbed.maxPosition { p = a.rotaryEmbed.maxPosition - 1 }
+    a.rotaryEmbed.applyRotary(vec, p, seqLen)
+}
+
+// SetQKVBias loads the q/k/v projection biases (Qwen2, GPT-2)
+func (a *Attention) SetQKVBias(q, k, v []float32) error {
+    if err := a.qProj.LoadBias(q); err != nil { return err }
+    if err := a.kProj.LoadBias(k); err != nil { return err }
+    return a.vProj.LoadBias(v)
+}
+
+// SetQKNorm adds per-head RMSNorms ([headDim]) on q and k, applied before
+// RoPE (Qwen3, Gemma 3)
+func (a *Attention) SetQKNorm(q, k *RMSNorm) { a.qNorm, a.kNorm = q, k }
+
+// SetQKNormWeights loads the q_norm/k_norm weights (each [headDim])
+func (a *Attention) SetQKNormWeights(q, k []float32) error {
+    if a.qNorm == nil { return fmt.Errorf("attention has no q/k norm") }
+    if err := a.qNorm.LoadWeights(q); err != nil { return err }
+    return a.kNorm.LoadWeights(k)
+}
+
+// NewAttention creates a new attention layer; rope may be nil for models
+// without rotary embeddings
+func NewAttention(hiddenSize, numHeads, numKVHeads, headDim int, rope *RotaryEmbedding, qkvBias bool) (*Attention, error) {
 	scale := float32(1.0 / math.Sqrt(float64(headDim)))
 
-	qProj, err := NewLinear(hiddenSize, numHeads*headDim, false)
+	qProj, err := NewLinear(hiddenSize, numHeads*headDim, qkvBias)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create q projection: %v", err)
 	}
 
-	kProj, err := NewLinear(hiddenSize, numKVHeads*headDim, false)
+	kProj, err := NewLinear(hiddenSize, numKVHeads*headDim, qkvBias)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create k projection: %v", err)
 	}
 
-	vProj, err := NewLinear(hiddenSize, numKVHeads*headDim, false)
+	vProj, err := NewLinear(hiddenSize, numKVHeads*headDim, qkvBias)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create v projection: %v", err)
 	}
@@ -56,10 +116,9 @@ func NewAttention(hiddenSize, numHeads, numKVHeads, headDim int, maxPosition int
 		return nil, fmt.Errorf("failed to create o projection: %v", err)
 	}
 
-    rotaryEmbed, err := NewRotaryEmbedding(headDim, headDim, maxPosition, ropeTheta, ropeScalingType, ropeScalingFactor)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create rotary embedding: %v", err)
-	}
+    if rope != nil && rope.headDim != headDim {
+        return nil, fmt.Errorf("rotary embedding head dim %d != %d", rope.headDim, headDim)
+    }
 
     kCache := make([][]float32, numKVHeads)
     vCache := make([][]float32, numKVHeads)
@@ -72,20 +131,72 @@ func NewAttention(hiddenSize, numHeads, numKVHeads, headDim int, maxPosition int
         kProj:        kProj,
         vProj:        vProj,
         oProj:        oProj,
-        rotaryEmbed:  rotaryEmbed,
+        rotaryEmbed:  rope,
         kCache:       kCache,
         vCache:       vCache,
         cacheLen:     0,
+        active:       -1,
+        caches:       make(map[int]*kvCache),
     }, nil
 }
 
-// ResetCache clears KV cache
+// normQK applies the per-head q/k norms in place, if enabled
+func (a *Attention) normQK(qData, kData []float32) {
+    if a.qNorm == nil { return }
+    a.qNorm.normalizeRows(qData)
+    a.kNorm.normalizeRows(kData)
+}
+
+// SetSlidingWindow limits attention to the last w positions (Mistral); 0
+// disables the limit
+func (a *Attention) SetSlidingWindow(w int) { a.slidingWindow = w }
+
+// SetScale overrides the 1/sqrt(headDim) score scale (Gemma 2's
+// query_pre_attn_scalar)
+func (a *Attention) SetScale(scale float32) { a.scale = scale }
+
+// SetSoftCap enables attention logit soft-capping, c*tanh(score/c)
+func (a *Attention) SetSoftCap(c float32) { a.softCap = c }
+
+// windowStart returns the first key position visible from position p
+func (a *Attention) windowStart(p int) int {
+    if a.slidingWindow <= 0 || p+1 <= a.slidingWindow { return 0 }
+    return p + 1 - a.slidingWindow
+}
+
+// ResetCache clears the active KV cache
 func (a *Attention) ResetCache() {
     for i := range a.kCache { a.kCache[i] = nil }
     for i := range a.vCache { a.vCache[i] = nil }
     a.cacheLen = 0
 }
 
+// UseCache makes the KV cache of sequence id active, parking the current
+// one. A sequence seen for the first time starts with an empty cache.
+func (a *Attention) UseCache(id int) {
+    if id == a.active { return }
+    if a.active >= 0 {
+        a.caches[a.active] = &kvCache{k: a.kCache, v: a.vCache, length: a.cacheLen}
+    }
+    c, ok := a.caches[id]
+    if ok {
+        delete(a.caches, id)
+    } else {
+        c = &kvCache{k: make([][]float32, a.numKVHeads), v: make([][]float32, a.numKVHeads)}
+    }
+    a.kCache, a.vCache, a.cacheLen = c.k, c.v, c.length
+    a.active = id
+}
+
+// FreeCache drops the KV cache of sequence id
+func (a *Attention) FreeCache(id int) {
+    if id == a.active {
+        a.ResetCache()
+        return
+    }
+    delete(a.caches, id)
+}
+
 // Forward performs forward pass with single-seq KV cache.
 // input: [T, hidden], positions: [T]
 func (a *Attention) Forward(input, positions *tensor.Tensor) (*tensor.Tensor, error) {
@@ -106,13 +217,14 @@ func (a *Attention) Forward(input, positions *tensor.Tensor) (*tensor.Tensor, er
     qData := q.Data().Data().([]float32) // [T, numHeads*headDim]
     kData := k.Data().Data().([]float32) // [T, numKVHeads*headDim]
     vData := v.Data().Data().([]float32) // [T, numKVHeads*headDim]
+    a.normQK(qData, kData)
 
     headsOut := make([]float32, T*a.numHeads*a.headDim)
     // Append K,V for this block
     prev := a.cacheLen
+    if a.rotaryEmbed != nil { a.rotaryEmbed.Ensure(prev + T) }
     for t := 0; t < T; t++ {
         p := prev + t
-        if p >= a.rotaryEmbed.maxPosition { p = a.rotaryEmbed.maxPosition - 1 }
         for kv := 0; kv < a.numKVHeads; kv++ {
             kOff := t*a.numKVHeads*a.headDim + kv*a.headDim
             vOff := t*a.numKVHeads*a.headDim + kv*a.headDim
@@ -120,7 +232,7 @@ func (a *Attention) Forward(input, positions *tensor.Tensor) (*tensor.Tensor, er
             vVec := make([]float32, a.headDim)
             copy(kVec, kData[kOff:kOff+a.headDim])
             copy(vVec, vData[vOff:vOff+a.headDim])
-            a.rotaryEmbed.applyRotary(kVec, p)
+            a.rope(kVec, p, prev+T)
             a.kCache[kv] = append(a.kCache[kv], kVec...)
             a.vCache[kv] = append(a.vCache[kv], vVec...)
         }
@@ -136,11 +248,10 @@ func (a *Attention) Forward(input, positions *tensor.Tensor) (*tensor.Tensor, er
         qh := make([]float32, T*a.headDim)
         for t := 0; t < T; t++ {
             p := prev + t
-            if p >= a.rotaryEmbed.maxPosition { p = a.rotaryEmbed.maxPosition - 1 }
             qOff := t*a.numHeads*a.headDim + h*a.headDim
             vec := make([]float32, a.headDim)
             copy(vec, qData[qOff:qOff+a.headDim])
-            a.rotaryEmbed.applyRotary(vec, p)
+            a.rope(vec, p, prev+T)
             copy(qh[t*a.headDim:(t+1)*a.headDim], vec)
         }
         // K_h cache [L x D] and V_h cache [L x D]
@@ -154,7 +265,9 @@ func (a *Attention) Forward(input, positions *tensor.Tensor) (*tensor.Tensor, er
             allowed := prev + t + 1
             if allowed > L { allowed = L }
             row := scores[t*L : (t+1)*L]
+            SoftCap(row, a.softCap)
             for i := allowed; i < L; i++ { row[i] = -1e30 }
+            for i := 0; i < a.windowStart(prev+t); i++ { row[i] = -1e30 }
             max := row[0]
             for i := 1; i < L; i++ { if row[i] > max { max = row[i] } }
             var sum float32
@@ -180,3 +293,100 @@ func (a *Attention) Forward(input, positions *tensor.Tensor) (*tensor.Tensor, er
     if err != nil { return nil, fmt.Errorf("output projection failed: %v", err) }
     return out, nil
 }
+
+// ForwardCandidates treats every row of input [K, hidden] as an alternative
+// next token at the current cache position: each row attends to the cache
+// plus its own key/value, and the cache is left untouched. Used for the
+// contrastive-search lookahead.
+func (a *Attention) ForwardCandidates(input *tensor.Tensor) (*tensor.Tensor, error) {
+    inShape := input.Shape()
+    if len(inShape) != 2 {
+        return nil, fmt.Errorf("attention input must be 2D [K, hidden]")
+    }
+    K := inShape[0]
+
+    q, err := a.qProj.Forward(input)
+    if err != nil { return nil, fmt.Errorf("q projection failed: %v", err) }
+    k, err := a.kProj.Forward(input)
+    if err != nil { return nil, fmt.Errorf("k projection failed: %v", err) }
+    v, err := a.vProj.Forward(input)
+    if err != nil { return nil, fmt.Errorf("v projection failed: %v", err) }
+    qData := q.Data().Data().([]float32)
+    kData := k.Data().Data().([]float32)
+    vData := v.Data().Data().([]float32)
+    a.normQK(qData, kData)
+
+    p := a.cacheLen
+    if a.rotaryEmbed != nil { a.rotaryEmbed.Ensure(p + 1) }
+    // Rotate every candidate's q and k in place for position p
+    for t := 0; t < K; t++ {
+        for h := 0; h < a.numHeads; h++ {
+            off := t*a.numHeads*a.headDim + h*a.headDim
+            a.rope(qData[off:off+a.headDim], p, a.cacheLen+1)
+        }
+        for kv := 0; kv < a.numKVHeads; kv++ {
+            off := t*a.numKVHeads*a.headDim + kv*a.headDim
+            a.rope(kData[off:off+a.headDim], p, a.cacheLen+1)
+        }
+    }
+
+    L := a.cacheLen
+    headsOut := make([]float32, K*a.numHeads*a.headDim)
+    groupSize := a.numHeads / a.numKVHeads
+    if groupSize == 0 { groupSize = 1 }
+    qh := make([]float32, K*a.headDim)
+    scores := make([]float32, K*L)
+    outH := make([]float32, K*a.headDim)
+    for h := 0; h < a.numHeads; h++ {
+        kv := h / groupSize
+        for t := 0; t < K; t++ {
+            off := t*a.numHeads*a.headDim + h*a.headDim
+            copy(qh[t*a.headDim:(t+1)*a.headDim], qData[off:off+a.headDim])
+        }
+        // scores over the shared cache: [K x L]
+        if L > 0 {
+            mathx.GemmNT(a.scale, qh, K, a.headDim, a.kCache[kv], L, a.headDim, 0.0, scores)
+        }
+        for t := 0; t < K; t++ {
+            qv := qh[t*a.headDim : (t+1)*a.headDim]
+            kvOff := t*a.numKVHeads*a.headDim + kv*a.headDim
+            kSelf := kData[kvOff : kvOff+a.headDim]
+            vSelf := vData[kvOff : kvOff+a.headDim]
+            var self float32
+            for d := range qv { self += qv[d] * kSelf[d] }
+            self *= a.scale
+            // softmax over [cache..., self]
+            row := scores[t*L : (t+1)*L]
+            if a.softCap > 0 {
+                SoftCap(row, a.softCap)
+                self = a.softCap * float32(math.Tanh(float64(self/a.softCap)))
+            }
+            for i := 0; i < a.windowStart(L); i++ { row[i] = -1e30 }
+            max := self
+            for _, s := range row { if s > max { max = s } }
+            selfP := float32(math.Exp(float64(self - max)))
+            sum := selfP
+            for i := range row { row[i] = float32(math.Exp(float64(row[i]-max))); sum += row[i] }
+            inv := 1 / sum
+            for i := range row { row[i] *= inv }
+            selfP *= inv
+            o := outH[t*a.headDim : (t+1)*a.headDim]
+            for d := range o { o[d] = selfP * vSelf[d] }
+        }
+        // out_h += scores * vh -> [K x D]
+        if L > 0 {
+            mathx.GemmNN(1.0, scores, K, L, a.vCache[kv], L, a.headDim, 1.0, outH)
+        }
+        for t := 0; t < K; t++ {
+            outOff := t*a.numHeads*a.headDim + h*a.headDim
+            copy(headsOut[outOff:outOff+a.headDim], outH[t*a.headDim:(t+1)*a.headDim])
+        }
+    }
+
+    hs, err := tensor.NewTensor([]int{K, a.numHeads * a.headDim}, tensor.Float32, tensor.CPU)
+    if err != nil { return nil, err }
+    copy(hs.Data().Data().([]float32), headsOut)
+    out, err := a.oProj.Forward(hs)
+    if err != nil { return nil, fmt.Errorf("output projection failed: %v", err) }
+    return out, nil
+}
@@ -0,0 +1,41 @@
+package layers
+
+import (
+    "testing"
+
+    "github.com/unixsysdev/nano-go-vllm/internal/tensor"
+)
+
+// TestAttentionScaleAndSoftCap runs one head with identity projections and
+// no rope, so the second position attends to k = v = x with scores
+// cap*tanh(scale*q·k/cap)
+func TestAttentionScaleAndSoftCap(t *testing.T) {
+    identity := []float32{1, 0, 0, 1}
+    tests := []struct {
+        name       string
+        scale, cap float32
+        want       []float64
+    }{
+        // scores 0.5*[2, 5] = [1, 2.5]
+        {"scale", 0.5, 0, []float64{1.8175744761936437, 0.8175744761936437}},
+        // capped to 2*tanh([0.5, 1.25])
+        {"scale and cap", 0.5, 2, []float64{1.684025344792389, 0.6840253447923887}},
+    }
+    for _, tt := range tests {
+        a, err := NewAttention(2, 1, 1, 2, nil, false)
+        if err != nil { t.Fatal(err) }
+        for _, set := range []func([]float32) error{a.SetQWeights, a.SetKWeights, a.SetVWeights, a.SetOWeights} {
+            if err := set(identity); err != nil { t.Fatal(err) }
+        }
+        a.SetScale(tt.scale)
+        a.SetSoftCap(tt.cap)
+        pos, err := tensor.NewTensor([]int{2}, tensor.Int64, tensor.CPU)
+        if err != nil { t.Fatal(err) }
+        pos.Data().Data().([]int64)[1] = 1
+        out, err := a.Forward(matrix(t, 2, 1, 0, 2, 1), pos)
+        if err != nil { t.Fatal(err) }
+        got := out.Data().Data().([]float32)
+        checkClose(t, tt.name+" position 0", got[:2], []float64{1, 0})
+        checkClose(t, tt.name+" position 1", got[2:], tt.want)
+    }
+}
@@ -0,0 +1,94 @@
+package layers
+
+import (
+    "math/rand"
+    "testing"
+
+    "github.com/unixsysdev/nano-go-vllm/internal/mathx"
+    "github.com/unixsysdev/nano-go-vllm/internal/tensor"
+)
+
+// Baselines for BenchmarkLinear: the original Transpose + MatMul forward,
+// and the GEMM forward that allocated a new output whenever T changed
+
+func transposeMatMulForward(l *Linear, input *tensor.Tensor) (*tensor.Tensor, error) {
+    wT, err := l.weight.Transpose()
+    if err != nil { return nil, err }
+    return input.MatMul(wT)
+}
+
+// transposeMatMulGemm is transposeMatMulForward behind the gemmNT signature,
+// so whole models can run on the baseline (see UseTransposeMatMul); like
+// Linear it assumes alpha 1 and beta 0
+func transposeMatMulGemm(alpha float32, A []float32, ar, ac int, B []float32, br, bc int, beta float32, C []float32) {
+    x, err := tensor.FromFloat32([]int{ar, ac}, A)
+    if err != nil { panic(err) }
+    w, err := tensor.FromFloat32([]int{br, bc}, B)
+    if err != nil { panic(err) }
+    wT, err := w.Transpose()
+    if err != nil { panic(err) }
+    out, err := x.MatMul(wT)
+    if err != nil { panic(err) }
+    copy(C, out.Data().Data().([]float32))
+}
+
+func reallocForward(l *Linear, out **tensor.Tensor, input *tensor.Tensor) (*tensor.Tensor, error) {
+    T, inSize := input.Shape()[0], input.Shape()[1]
+    outSize := l.weight.Shape()[0]
+    if *out == nil || (*out).Shape()[0] != T {
+        o, err := tensor.NewTensor([]int{T, outSize}, tensor.Float32, tensor.CPU)
+        if err != nil { return nil, err }
+        *out = o
+    }
+    mathx.GemmNT(1.0, input.Data().Data().([]float32), T, inSize,
+        l.weight.Data().Data().([]float32), outSize, inSize, 0.0, (*out).Data().Data().([]float32))
+    return *out, nil
+}
+
+func benchInput(b *testing.B, T, n int, rng *rand.Rand) *tensor.Tensor {
+    x, err := tensor.NewTensor([]int{T, n}, tensor.Float32, tensor.CPU)
+    if err != nil { b.Fatal(err) }
+    for i, d := 0, x.Data().Data().([]float32); i < len(d); i++ { d[i] = rng.Float32() - 0.5 }
+    return x
+}
+
+// BenchmarkLinear runs a 896x896 projection (Qwen2.5-0.5B hidden size) over
+// a mix of row counts, as when the runner alternates between a prefill and
+// the decode steps of other sequences. The allocations left in "grow" are
+// gonum's parallel GEMM workers for the 32-row calls, not the layer's.
+func BenchmarkLinear(b *testing.B) {
+    const n = 896
+    rng := rand.New(rand.NewSource(1))
+    l, err := NewLinear(n, n, false)
+    if err != nil { b.Fatal(err) }
+    w := l.weight.Data().Data().([]float32)
+    for i := range w { w[i] = rng.Float32() - 0.5 }
+    var inputs []*tensor.Tensor
+    for _, T := range []int{32, 1, 1, 1, 32, 1, 1, 1} { inputs = append(inputs, benchInput(b, T, n, rng)) }
+
+    b.Run("grow", func(b *testing.B) {
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+            for _, x := range inputs {
+                if _, err := l.Forward(x); err != nil { b.Fatal(err) }
+            }
+        }
+    })
+    b.Run("realloc", func(b *testing.B) {
+        b.ReportAllocs()
+        var out *tensor.Tensor
+        for i := 0; i < b.N; i++ {
+            for _, x := range inputs {
+                if _, err := reallocForward(l, &out, x); err != nil { b.Fatal(err) }
+            }
+        }
+    })
+    b.Run("transpose-matmul", func(b *testing.B) {
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+            for _, x := range inputs {
+                if _, err := transposeMatMulForward(l, x); err != nil { b.Fatal(err) }
+            }
+        }
+    })
+}
@@ -0,0 +1,136 @@
+package layers_test
+
+import (
+    "encoding/binary"
+    "encoding/json"
+    "math"
+    "math/rand"
+    "os"
+    "path/filepath"
+    "strconv"
+    "testing"
+
+    ggtensor "gorgonia.org/tensor"
+
+    "github.com/unixsysdev/nano-go-vllm/internal/config"
+    "github.com/unixsysdev/nano-go-vllm/internal/layers"
+    "github.com/unixsysdev/nano-go-vllm/internal/models"
+    "github.com/unixsysdev/nano-go-vllm/internal/tensor"
+)
+
+// benchModel is a small Llama: 4 layers of hidden 512 with GQA and an untied
+// head, so every projection including the lm head goes through Linear
+var benchModel = map[string]interface{}{
+    "architectures":           []string{"LlamaForCausalLM"},
+    "model_type":              "llama",
+    "vocab_size":              8192,
+    "hidden_size":             512,
+    "intermediate_size":       1536,
+    "num_hidden_layers":       4,
+    "num_attention_heads":     8,
+    "num_key_value_heads":     2,
+    "head_dim":                64,
+    "max_position_embeddings": 512,
+    "hidden_act":              "silu",
+    "rms_norm_eps":            1e-5,
+    "tie_word_embeddings":     false,
+}
+
+// writeBenchModel writes benchModel with random weights to a temp dir
+func writeBenchModel(b *testing.B) string {
+    b.Helper()
+    cfg := benchModel
+    V, H, I := cfg["vocab_size"].(int), cfg["hidden_size"].(int), cfg["intermediate_size"].(int)
+    q := cfg["num_attention_heads"].(int) * cfg["head_dim"].(int)
+    kv := cfg["num_key_value_heads"].(int) * cfg["head_dim"].(int)
+    shapes := map[string][]int{
+        "model.embed_tokens.weight": {V, H},
+        "model.norm.weight":         {H},
+        "lm_head.weight":            {V, H},
+    }
+    for i := 0; i < cfg["num_hidden_layers"].(int); i++ {
+        p := "model.layers." + strconv.Itoa(i) + "."
+        for name, s := range map[string][]int{
+            "input_layernorm.weight":          {H},
+            "post_attention_layernorm.weight": {H},
+            "self_attn.q_proj.weight":         {q, H},
+            "self_attn.k_proj.weight":         {kv, H},
+            "self_attn.v_proj.weight":         {kv, H},
+            "self_attn.o_proj.weight":         {H, q},
+            "mlp.gate_proj.weight":            {I, H},
+            "mlp.up_proj.weight":              {I, H},
+            "mlp.down_proj.weight":            {H, I},
+        } {
+            shapes[p+name] = s
+        }
+    }
+
+    rng := rand.New(rand.NewSource(1))
+    header := map[string]interface{}{}
+    var data []byte
+    for name, s := range shapes {
+        n := 1
+        for _, d := range s { n *= d }
+        start := len(data)
+        for j := 0; j < n; j++ {
+            v := float32(rng.NormFloat64() * 0.02)
+            if len(s) == 1 { v = 1 } // norm weights
+            data = binary.LittleEndian.AppendUint32(data, math.Float32bits(v))
+        }
+        header[name] = map[string]interface{}{"dtype": "F32", "shape": s, "data_offsets": []int{start, len(data)}}
+    }
+    h, err := json.Marshal(header)
+    if err != nil { b.Fatal(err) }
+    dir := b.TempDir()
+    st := binary.LittleEndian.AppendUint64(nil, uint64(len(h)))
+    st = append(append(st, h...), data...)
+    if err := os.WriteFile(filepath.Join(dir, "model.safetensors"), st, 0o644); err != nil { b.Fatal(err) }
+    c, err := json.Marshal(cfg)
+    if err != nil { b.Fatal(err) }
+    if err := os.WriteFile(filepath.Join(dir, "config.json"), c, 0o644); err != nil { b.Fatal(err) }
+    return dir
+}
+
+// forward runs ids at positions start, start+1, ... on the active KV cache
+func forward(b *testing.B, m models.CausalLM, ids []int, start int) {
+    idT, err := tensor.NewTensor([]int{len(ids)}, tensor.Int64, tensor.CPU)
+    if err != nil { b.Fatal(err) }
+    posT, err := tensor.NewTensor([]int{len(ids)}, tensor.Int64, tensor.CPU)
+    if err != nil { b.Fatal(err) }
+    idDense, posDense := idT.Data().(*ggtensor.Dense), posT.Data().(*ggtensor.Dense)
+    for i, id := range ids {
+        idDense.Set(i, int64(id))
+        posDense.Set(i, int64(start+i))
+    }
+    if _, err := m.Forward(idT, posT, nil); err != nil { b.Fatal(err) }
+}
+
+// BenchmarkDecode reports single-sequence decode throughput after a 32-token
+// prefill, for the GEMM Linear and the original Transpose + MatMul forward
+func BenchmarkDecode(b *testing.B) {
+    const prefill, decode = 32, 32
+    cfg, err := config.LoadConfig(writeBenchModel(b))
+    if err != nil { b.Fatal(err) }
+    m, err := models.New(cfg)
+    if err != nil { b.Fatal(err) }
+    prompt := make([]int, prefill)
+    for i := range prompt { prompt[i] = (i * 37) % cfg.VocabSize }
+
+    for _, bm := range []struct {
+        name     string
+        baseline bool
+    }{{"gemm", false}, {"transpose-matmul", true}} {
+        b.Run(bm.name, func(b *testing.B) {
+            if bm.baseline { defer layers.UseTransposeMatMul()() }
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                b.StopTimer()
+                m.ResetKVCache()
+                forward(b, m, prompt, 0)
+                b.StartTimer()
+                for p := 0; p < decode; p++ { forward(b, m, []int{(p * 11) % cfg.VocabSize}, prefill+p) }
+            }
+            b.ReportMetric(float64(b.N*decode)/b.Elapsed().Seconds(), "tokens/s")
+        })
+    }
+}
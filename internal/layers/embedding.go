@@ -3,12 +3,14 @@ package layers
 import (
     "fmt"
 
+    "github.com/unixsysdev/nano-go-vllm/internal/mathx"
     "github.com/unixsysdev/nano-go-vllm/internal/tensor"
 )
 
 // Embedding represents an embedding layer
 type Embedding struct {
     weight *tensor.Tensor
+    scale  float32 // output multiplier (Gemma: sqrt(hidden)); 0 means 1
 }
 
 // NewEmbedding creates a new embedding layer
@@ -72,6 +74,9 @@ func (e *Embedding) Forward(input *tensor.Tensor) (*tensor.Tensor, error) {
 			for k := 0; k < embeddingDim; k++ {
 				outputData[outputOffset+k] = weightData[weightOffset+int64(k)]
 			}
+			if e.scale != 0 {
+				for k := 0; k < embeddingDim; k++ { outputData[outputOffset+k] *= e.scale }
+			}
 		}
 	}
 	
@@ -92,6 +97,26 @@ func (e *Embedding) Forward(input *tensor.Tensor) (*tensor.Tensor, error) {
 	return output, nil
 }
 
+// SetScale multiplies every looked-up embedding by s (the weights, and so a
+// tied lm_head, are left unscaled)
+func (e *Embedding) SetScale(s float32) { e.scale = s }
+
+// Attend projects hidden states [T, dim] onto the vocabulary, returning
+// [T, vocab] = input * weight^T. This is the lm_head of a model with tied
+// word embeddings; the embedding matrix is used in place, not copied.
+func (e *Embedding) Attend(input *tensor.Tensor) (*tensor.Tensor, error) {
+    shape := input.Shape()
+    vocab, dim := e.weight.Shape()[0], e.weight.Shape()[1]
+    if len(shape) != 2 || shape[1] != dim {
+        return nil, fmt.Errorf("attend input must be [T, %d], got %v", dim, shape)
+    }
+    output, err := tensor.NewTensor([]int{shape[0], vocab}, tensor.Float32, tensor.CPU)
+    if err != nil { return nil, err }
+    mathx.GemmNT(1.0, input.Data().Data().([]float32), shape[0], dim,
+        e.weight.Data().Data().([]float32), vocab, dim, 0.0, output.Data().Data().([]float32))
+    return output, nil
+}
+
 // LoadWeights loads weights from data
 func (e *Embedding) LoadWeights(weightData []float32) error {
     weightDense := e.weight.Data()
@@ -0,0 +1,26 @@
+package layers
+
+import (
+    "testing"
+
+    "github.com/unixsysdev/nano-go-vllm/internal/tensor"
+)
+
+// TestEmbeddingScale: SetScale scales the looked-up rows (Gemma's
+// sqrt(hidden)) but not the tied lm_head in Attend
+func TestEmbeddingScale(t *testing.T) {
+    e, err := NewEmbedding(3, 2)
+    if err != nil { t.Fatal(err) }
+    if err := e.LoadWeights([]float32{1, 2, 3, 4, 5, 6}); err != nil { t.Fatal(err) }
+    e.SetScale(4)
+    ids, err := tensor.NewTensor([]int{2}, tensor.Int64, tensor.CPU)
+    if err != nil { t.Fatal(err) }
+    copy(ids.Data().Data().([]int64), []int64{2, 0})
+    out, err := e.Forward(ids)
+    if err != nil { t.Fatal(err) }
+    checkClose(t, "forward", out.Data().Data().([]float32), []float64{20, 24, 4, 8})
+
+    logits, err := e.Attend(matrix(t, 2, 1, 1))
+    if err != nil { t.Fatal(err) }
+    checkClose(t, "attend", logits.Data().Data().([]float32), []float64{3, 7, 11})
+}
@@ -0,0 +1,11 @@
+package layers
+
+import "github.com/unixsysdev/nano-go-vllm/internal/mathx"
+
+// UseTransposeMatMul makes every Linear run the original Transpose + MatMul
+// forward, as a model-level benchmark baseline. The returned func restores
+// the GEMM.
+func UseTransposeMatMul() (restore func()) {
+    gemmNT = transposeMatMulGemm
+    return func() { gemmNT = mathx.GemmNT }
+}
@@ -11,6 +11,7 @@ import (
 type RMSNorm struct {
 	weight *tensor.Tensor
 	eps    float32
+	offset float32 // added to the weight: 1 for Gemma's (1+w) convention
 }
 
 // NewRMSNorm creates a new RMSNorm layer
@@ -34,6 +35,10 @@ func NewRMSNorm(hiddenSize int, eps float32) (*RMSNorm, error) {
 	}, nil
 }
 
+// SetWeightOffset makes the norm scale by (offset + w); Gemma checkpoints
+// store w-1 and use offset 1
+func (r *RMSNorm) SetWeightOffset(offset float32) { r.offset = offset }
+
 // Forward performs forward pass
 func (r *RMSNorm) Forward(input *tensor.Tensor) (*tensor.Tensor, error) {
 	shape := input.Shape()
@@ -68,7 +73,7 @@ func (r *RMSNorm) Forward(input *tensor.Tensor) (*tensor.Tensor, error) {
 	for i := 0; i < batchSize; i++ {
 		offset := i * hiddenSize
 		for j := 0; j < hiddenSize; j++ {
-			outputData[offset+j] *= weightData[j]
+			outputData[offset+j] *= r.offset + weightData[j]
 		}
 	}
 	
@@ -88,3 +93,83 @@ func (r *RMSNorm) LoadWeights(weightData []float32) error {
     copy(weightDense.Data().([]float32), weightData)
     return nil
 }
+
+// normalizeRows normalizes x in place as consecutive rows of the layer's
+// width, e.g. the heads of a [T, numHeads*headDim] projection
+func (r *RMSNorm) normalizeRows(x []float32) {
+    w := r.weight.Data().Data().([]float32)
+    n := len(w)
+    for off := 0; off+n <= len(x); off += n {
+        row := x[off : off+n]
+        var sumSq float32
+        for _, v := range row { sumSq += v * v }
+        inv := float32(1 / math.Sqrt(float64(sumSq/float32(n)+r.eps)))
+        for j := range row { row[j] = row[j] * inv * (r.offset + w[j]) }
+    }
+}
+
+// LayerNorm represents layer normalization with an optional bias (GPT-2)
+type LayerNorm struct {
+    weight *tensor.Tensor
+    bias   *tensor.Tensor
+    eps    float32
+}
+
+// NewLayerNorm creates a new LayerNorm layer (weight 1, bias 0)
+func NewLayerNorm(hiddenSize int, eps float32) (*LayerNorm, error) {
+    weight, err := tensor.NewTensor([]int{hiddenSize}, tensor.Float32, tensor.CPU)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create weight tensor: %v", err)
+    }
+    bias, err := tensor.NewTensor([]int{hiddenSize}, tensor.Float32, tensor.CPU)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create bias tensor: %v", err)
+    }
+    w := weight.Data().Data().([]float32)
+    for i := range w { w[i] = 1 }
+    return &LayerNorm{weight: weight, bias: bias, eps: eps}, nil
+}
+
+// Forward performs forward pass
+func (n *LayerNorm) Forward(input *tensor.Tensor) (*tensor.Tensor, error) {
+    shape := input.Shape()
+    if len(shape) != 2 {
+        return nil, fmt.Errorf("input must be 2D tensor")
+    }
+    batchSize, hiddenSize := shape[0], shape[1]
+    output, err := tensor.NewTensor(shape, tensor.Float32, tensor.CPU)
+    if err != nil {
+        return nil, err
+    }
+    in := input.Data().Data().([]float32)
+    out := output.Data().Data().([]float32)
+    w := n.weight.Data().Data().([]float32)
+    b := n.bias.Data().Data().([]float32)
+    for i := 0; i < batchSize; i++ {
+        row := in[i*hiddenSize : (i+1)*hiddenSize]
+        var mean float64
+        for _, v := range row { mean += float64(v) }
+        mean /= float64(hiddenSize)
+        var variance float64
+        for _, v := range row {
+            d := float64(v) - mean
+            variance += d * d
+        }
+        variance /= float64(hiddenSize)
+        inv := 1 / math.Sqrt(variance+float64(n.eps))
+        o := out[i*hiddenSize : (i+1)*hiddenSize]
+        for j, v := range row {
+            o[j] = float32((float64(v)-mean)*inv)*w[j] + b[j]
+        }
+    }
+    return output, nil
+}
+
+// LoadWeights loads weight and bias (bias may be nil)
+func (n *LayerNorm) LoadWeights(weightData, biasData []float32) error {
+    copy(n.weight.Data().Data().([]float32), weightData)
+    if biasData != nil {
+        copy(n.bias.Data().Data().([]float32), biasData)
+    }
+    return nil
+}
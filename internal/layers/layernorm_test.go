@@ -0,0 +1,40 @@
+package layers
+
+import "testing"
+
+// TestRMSNormWeightOffset checks Gemma's x/rms(x) * (1 + w) for whole rows
+// and for the per-head rows of a q/k norm
+func TestRMSNormWeightOffset(t *testing.T) {
+    n, err := NewRMSNorm(2, 0)
+    if err != nil { t.Fatal(err) }
+    if err := n.LoadWeights([]float32{0, 0.5}); err != nil { t.Fatal(err) }
+    n.SetWeightOffset(1)
+    // rms([3, 4]) = sqrt(12.5)
+    want := []float64{0.848528137423857, 1.697056274847714}
+    out, err := n.Forward(matrix(t, 2, 3, 4))
+    if err != nil { t.Fatal(err) }
+    checkClose(t, "forward", out.Data().Data().([]float32), want)
+    heads := []float32{3, 4, -3, -4}
+    n.normalizeRows(heads)
+    checkClose(t, "heads", heads, append(want, -want[0], -want[1]))
+
+    n.SetWeightOffset(0)
+    out, err = n.Forward(matrix(t, 2, 3, 4))
+    if err != nil { t.Fatal(err) }
+    checkClose(t, "no offset", out.Data().Data().([]float32), []float64{0, want[1] / 3})
+}
+
+// TestLayerNorm checks (x - mean)/std * w + b per row; a constant row
+// normalizes to zero (eps keeps it finite) and leaves just the bias
+func TestLayerNorm(t *testing.T) {
+    n, err := NewLayerNorm(4, 1e-5)
+    if err != nil { t.Fatal(err) }
+    if err := n.LoadWeights([]float32{1, 2, 1, 0.5}, []float32{0, 0.5, -1, 0}); err != nil { t.Fatal(err) }
+    out, err := n.Forward(matrix(t, 4, 1, 2, 3, 6, 5, 5, 5, 5))
+    if err != nil { t.Fatal(err) }
+    // mean 3, variance 3.5
+    checkClose(t, "layernorm", out.Data().Data().([]float32), []float64{
+        -1.0690449676496976, -0.5690449676496976, -1, 0.8017837257372732,
+        0, 0.5, -1, 0,
+    })
+}
@@ -3,13 +3,19 @@ package layers
 import (
     "fmt"
 
+    "github.com/unixsysdev/nano-go-vllm/internal/mathx"
     "github.com/unixsysdev/nano-go-vllm/internal/tensor"
 )
 
+// gemmNT computes C = alpha*A*Bᵀ + beta*C; benchmarks swap in a baseline
+var gemmNT = mathx.GemmNT
+
 // Linear represents a linear layer
 type Linear struct {
 	weight *tensor.Tensor
 	bias   *tensor.Tensor
+	buf    []float32              // output storage, grown to the largest T*outputSize seen
+	views  map[int]*tensor.Tensor // [T, outputSize] view of buf per row count
 }
 
 // NewLinear creates a new linear layer
@@ -33,29 +39,49 @@ func NewLinear(inputSize, outputSize int, hasBias bool) (*Linear, error) {
 	}, nil
 }
 
-// Forward performs forward pass
+// Forward performs forward pass.
+// The returned tensor aliases the layer's output buffer: the next Forward
+// call overwrites it, whatever its row count, so callers that keep the
+// result across calls must copy it. The buffer only grows and each row
+// count keeps its view of it, so a steady mix of batch sizes allocates
+// nothing.
 func (l *Linear) Forward(input *tensor.Tensor) (*tensor.Tensor, error) {
 	// input shape: [batchSize, inputSize]
 	// weight shape: [outputSize, inputSize]
 	// output shape: [batchSize, outputSize]
-	
-    wT, err := l.weight.Transpose()
-    if err != nil {
-        return nil, fmt.Errorf("transpose failed: %v", err)
+    shape := input.Shape()
+    outSize, inSize := l.weight.Shape()[0], l.weight.Shape()[1]
+    if len(shape) != 2 || shape[1] != inSize {
+        return nil, fmt.Errorf("linear input must be [T, %d], got %v", inSize, shape)
     }
-    output, err := input.MatMul(wT)
-    if err != nil {
-        return nil, fmt.Errorf("matmul failed: %v", err)
+    T := shape[0]
+    view := l.views[T]
+    if view == nil {
+        if n := T * outSize; l.views == nil || cap(l.buf) < n {
+            // views of the old storage are stale
+            l.buf = make([]float32, n)
+            l.views = make(map[int]*tensor.Tensor)
+        }
+        v, err := tensor.FromFloat32([]int{T, outSize}, l.buf[:T*outSize])
+        if err != nil { return nil, fmt.Errorf("failed to create output tensor: %v", err) }
+        l.views[T] = v
+        view = v
     }
+    out := view.Data().Data().([]float32)
+    // C = X * W^T straight from the stored [out, in] weights, no transpose
+    gemmNT(1.0, input.Data().Data().([]float32), T, inSize,
+        l.weight.Data().Data().([]float32), outSize, inSize, 0.0, out)
 
-	if l.bias != nil {
-		output, err = output.Add(l.bias)
-		if err != nil {
-			return nil, fmt.Errorf("bias add failed: %v", err)
-		}
-	}
+    if l.bias != nil {
+        // broadcast the bias over rows
+        b := l.bias.Data().Data().([]float32)
+        for off := 0; off < len(out); off += outSize {
+            row := out[off : off+outSize]
+            for j := range row { row[j] += b[j] }
+        }
+    }
 
-	return output, nil
+	return view, nil
 }
 
 // LoadWeights loads weights from data
@@ -72,3 +98,63 @@ func (l *Linear) LoadWeights(weightData, biasData []float32) error {
 
 	return nil
 }
+
+// LoadBias loads only the bias, adding one if the layer was created without
+func (l *Linear) LoadBias(biasData []float32) error {
+    out := l.weight.Shape()[0]
+    if len(biasData) != out {
+        return fmt.Errorf("bias has %d elements, want %d", len(biasData), out)
+    }
+    if l.bias == nil {
+        bias, err := tensor.NewTensor([]int{out}, tensor.Float32, tensor.CPU)
+        if err != nil { return fmt.Errorf("failed to create bias tensor: %v", err) }
+        l.bias = bias
+    }
+    copy(l.bias.Data().Data().([]float32), biasData)
+    return nil
+}
+
+// SplitQKV splits a fused [q; k; v] tensor along its first axis, where q
+// holds qRows and k, v kvRows rows each (works for weights and biases)
+func SplitQKV(w []float32, qRows, kvRows int) (q, k, v []float32, err error) {
+    total := qRows + 2*kvRows
+    if total == 0 || len(w)%total != 0 {
+        return nil, nil, nil, fmt.Errorf("fused qkv has %d elements, not a multiple of %d rows", len(w), total)
+    }
+    cols := len(w) / total
+    q = w[:qRows*cols]
+    k = w[qRows*cols : (qRows+kvRows)*cols]
+    v = w[(qRows+kvRows)*cols:]
+    return q, k, v, nil
+}
+
+// SplitQKVPerHead splits a fused tensor packed per head, [heads, 3, headDim]
+// rows (GPT-NeoX query_key_value), into contiguous q, k, v of heads*headDim
+// rows each (works for weights and biases)
+func SplitQKVPerHead(w []float32, heads, headDim int) (q, k, v []float32, err error) {
+    rows := 3 * heads * headDim
+    if rows == 0 || len(w)%rows != 0 {
+        return nil, nil, nil, fmt.Errorf("fused qkv has %d elements, not a multiple of %d rows", len(w), rows)
+    }
+    n := headDim * (len(w) / rows) // elements of one head's q, k or v
+    q = make([]float32, heads*n)
+    k = make([]float32, heads*n)
+    v = make([]float32, heads*n)
+    for h := 0; h < heads; h++ {
+        src := w[3*h*n:]
+        copy(q[h*n:(h+1)*n], src[:n])
+        copy(k[h*n:(h+1)*n], src[n:2*n])
+        copy(v[h*n:(h+1)*n], src[2*n:3*n])
+    }
+    return q, k, v, nil
+}
+
+// TransposeConv1D converts a GPT-2 Conv1D weight [in, out] to the Linear
+// layout [out, in]
+func TransposeConv1D(w []float32, in, out int) []float32 {
+    t := make([]float32, len(w))
+    for i := 0; i < in; i++ {
+        for o := 0; o < out; o++ { t[o*in+i] = w[i*out+o] }
+    }
+    return t
+}
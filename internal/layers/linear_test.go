@@ -0,0 +1,54 @@
+package layers
+
+import (
+    "testing"
+
+    "github.com/unixsysdev/nano-go-vllm/internal/tensor"
+)
+
+// TestLinearReusesBuffer checks the output against x·Wᵀ + b while T
+// shrinks and grows again, and that the shrink reslices the same storage
+func TestLinearReusesBuffer(t *testing.T) {
+    l, err := NewLinear(2, 3, true)
+    if err != nil { t.Fatal(err) }
+    if err := l.LoadWeights([]float32{1, 0, 0, 1, 1, 1}, []float32{0, 0, 10}); err != nil { t.Fatal(err) }
+    forward := func(x []float32) []float32 {
+        in, err := tensor.FromFloat32([]int{len(x) / 2, 2}, x)
+        if err != nil { t.Fatal(err) }
+        out, err := l.Forward(in)
+        if err != nil { t.Fatal(err) }
+        return out.Data().Data().([]float32)
+    }
+    check := func(got, want []float32) {
+        t.Helper()
+        for i := range want {
+            if got[i] != want[i] { t.Fatalf("got %v, want %v", got, want) }
+        }
+    }
+    big := forward([]float32{1, 2, 3, 4})
+    check(big, []float32{1, 2, 13, 3, 4, 17})
+    small := forward([]float32{5, 6})
+    check(small, []float32{5, 6, 21})
+    if &small[0] != &big[0] { t.Error("shrinking T allocated a new buffer") }
+    check(forward([]float32{1, 1, 2, 2}), []float32{1, 1, 12, 2, 2, 14})
+}
+
+// TestLinearSteadyStateAllocs: once every row count has been seen, a mixed
+// prefill/decode pattern allocates nothing
+func TestLinearSteadyStateAllocs(t *testing.T) {
+    l, err := NewLinear(8, 8, true)
+    if err != nil { t.Fatal(err) }
+    var inputs []*tensor.Tensor
+    for _, T := range []int{4, 1, 1, 4, 2} {
+        x, err := tensor.NewTensor([]int{T, 8}, tensor.Float32, tensor.CPU)
+        if err != nil { t.Fatal(err) }
+        inputs = append(inputs, x)
+    }
+    run := func() {
+        for _, x := range inputs {
+            if _, err := l.Forward(x); err != nil { t.Fatal(err) }
+        }
+    }
+    run()
+    if n := testing.AllocsPerRun(10, run); n != 0 { t.Errorf("%v allocs per run, want 0", n) }
+}
@@ -10,14 +10,15 @@ import (
 type MLP struct {
     gateUpProj *Linear
     downProj   *Linear
-    actFn      *SiluAndMul
+    actFn      GatedActivation
 }
 
 // NewMLP creates a new MLP layer
 func NewMLP(hiddenSize, intermediateSize int, hiddenAct string) (*MLP, error) {
-	if hiddenAct != "silu" {
-		return nil, fmt.Errorf("only silu activation is supported")
-	}
+    actFn, err := NewGatedActivation(hiddenAct)
+    if err != nil {
+        return nil, err
+    }
 
 	gateUpProj, err := NewLinear(hiddenSize, intermediateSize*2, false)
 	if err != nil {
@@ -32,7 +33,7 @@ func NewMLP(hiddenSize, intermediateSize int, hiddenAct string) (*MLP, error) {
 	return &MLP{
 		gateUpProj: gateUpProj,
 		downProj:   downProj,
-		actFn:      NewSiluAndMul(),
+		actFn:      actFn,
 	}, nil
 }
 
@@ -77,3 +78,49 @@ func (m *MLP) SetGateUpWeights(w []float32) error { return m.gateUpProj.LoadWeig
 
 // SetDownWeights loads down projection weights
 func (m *MLP) SetDownWeights(w []float32) error { return m.downProj.LoadWeights(w, nil) }
+
+// FFN is a plain two-layer feed-forward block, proj(act(fc(x))), with
+// optional biases (GPT-2 style; no gate)
+type FFN struct {
+    fc   *Linear
+    proj *Linear
+    act  func(float32) float32
+}
+
+// NewFFN creates a new FFN layer
+func NewFFN(hiddenSize, intermediateSize int, hiddenAct string, hasBias bool) (*FFN, error) {
+    act, err := NewActivation(hiddenAct)
+    if err != nil {
+        return nil, err
+    }
+    fc, err := NewLinear(hiddenSize, intermediateSize, hasBias)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create fc projection: %v", err)
+    }
+    proj, err := NewLinear(intermediateSize, hiddenSize, hasBias)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create output projection: %v", err)
+    }
+    return &FFN{fc: fc, proj: proj, act: act}, nil
+}
+
+// Forward performs forward pass
+func (f *FFN) Forward(input *tensor.Tensor) (*tensor.Tensor, error) {
+    h, err := f.fc.Forward(input)
+    if err != nil {
+        return nil, fmt.Errorf("fc projection failed: %v", err)
+    }
+    hd := h.Data().Data().([]float32)
+    for i, v := range hd { hd[i] = f.act(v) }
+    output, err := f.proj.Forward(h)
+    if err != nil {
+        return nil, fmt.Errorf("output projection failed: %v", err)
+    }
+    return output, nil
+}
+
+// SetFCWeights loads the first projection [intermediate, hidden] and bias
+func (f *FFN) SetFCWeights(w, b []float32) error { return f.fc.LoadWeights(w, b) }
+
+// SetProjWeights loads the output projection [hidden, intermediate] and bias
+func (f *FFN) SetProjWeights(w, b []float32) error { return f.proj.LoadWeights(w, b) }
@@ -0,0 +1,18 @@
+package layers
+
+import "testing"
+
+// TestGeluTanhMLP checks down(gelu_tanh(gate(x)) * up(x)), the Gemma MLP,
+// against values computed by hand
+func TestGeluTanhMLP(t *testing.T) {
+    m, err := NewMLP(2, 2, "gelu_pytorch_tanh")
+    if err != nil { t.Fatal(err) }
+    // fused [gate; up] rows, then down
+    gateUp := []float32{1, 0, 0, 1, 1, 1, 0.5, 0}
+    down := []float32{1, 0, 1, 1}
+    if err := m.LoadWeights(gateUp, down); err != nil { t.Fatal(err) }
+    out, err := m.Forward(matrix(t, 2, 1, -2))
+    if err != nil { t.Fatal(err) }
+    // gate = [1, -2], up = [-1, 0.5]: a = [gelu_tanh(1)*-1, gelu_tanh(-2)*0.5]
+    checkClose(t, "mlp", out.Data().Data().([]float32), []float64{-0.8411919906082768, -0.8638931435643893})
+}
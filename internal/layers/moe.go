@@ -0,0 +1,218 @@
+package layers
+
+import (
+    "fmt"
+    "math"
+    "runtime"
+    "sync"
+
+    "github.com/unixsysdev/nano-go-vllm/internal/tensor"
+)
+
+// MoE is a sparse mixture-of-experts feed-forward block (Mixtral,
+// Qwen2-MoE): a router picks topK gated-MLP experts per token and mixes
+// their outputs by the router probabilities. An optional shared expert
+// (Qwen2-MoE) runs on every token behind a sigmoid gate.
+type MoE struct {
+    router   *Linear // [numExperts, hidden]
+    experts  []*MLP
+    topK     int
+    normTopK bool // renormalize the selected probabilities to sum to 1
+
+    shared     *MLP    // nil without a shared expert
+    sharedGate *Linear // [1, hidden]
+
+    mu   sync.Mutex
+    load []int64 // tokens routed to each expert
+}
+
+// NewMoE creates a MoE block with numExperts experts of the given
+// intermediate size, each token routed to topK of them
+func NewMoE(hiddenSize, intermediateSize, numExperts, topK int, normTopK bool, hiddenAct string) (*MoE, error) {
+    if numExperts <= 0 || topK <= 0 || topK > numExperts {
+        return nil, fmt.Errorf("invalid expert routing: top-%d of %d experts", topK, numExperts)
+    }
+    router, err := NewLinear(hiddenSize, numExperts, false)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create router: %v", err)
+    }
+    experts := make([]*MLP, numExperts)
+    for i := range experts {
+        if experts[i], err = NewMLP(hiddenSize, intermediateSize, hiddenAct); err != nil {
+            return nil, fmt.Errorf("failed to create expert %d: %v", i, err)
+        }
+    }
+    return &MoE{
+        router:   router,
+        experts:  experts,
+        topK:     topK,
+        normTopK: normTopK,
+        load:     make([]int64, numExperts),
+    }, nil
+}
+
+// SetSharedExpert adds an always-on expert of the given intermediate size
+// with a sigmoid gate (Qwen2-MoE)
+func (m *MoE) SetSharedExpert(hiddenSize, intermediateSize int, hiddenAct string) error {
+    var err error
+    if m.shared, err = NewMLP(hiddenSize, intermediateSize, hiddenAct); err != nil {
+        return fmt.Errorf("failed to create shared expert: %v", err)
+    }
+    if m.sharedGate, err = NewLinear(hiddenSize, 1, false); err != nil {
+        return fmt.Errorf("failed to create shared expert gate: %v", err)
+    }
+    return nil
+}
+
+// NumExperts returns the number of routed experts
+func (m *MoE) NumExperts() int { return len(m.experts) }
+
+// Expert returns routed expert i (for loading weights)
+func (m *MoE) Expert(i int) *MLP { return m.experts[i] }
+
+// SharedExpert returns the shared expert, or nil
+func (m *MoE) SharedExpert() *MLP { return m.shared }
+
+// SetRouterWeights loads the router weights [numExperts, hidden]
+func (m *MoE) SetRouterWeights(w []float32) error { return m.router.LoadWeights(w, nil) }
+
+// SetSharedGateWeights loads the shared expert gate weights [1, hidden]
+func (m *MoE) SetSharedGateWeights(w []float32) error {
+    if m.sharedGate == nil { return fmt.Errorf("moe has no shared expert") }
+    return m.sharedGate.LoadWeights(w, nil)
+}
+
+// Load returns how many tokens have been routed to each expert so far
+func (m *MoE) Load() []int64 {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return append([]int64(nil), m.load...)
+}
+
+// ResetLoad clears the expert load counters
+func (m *MoE) ResetLoad() {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for i := range m.load { m.load[i] = 0 }
+}
+
+// Forward performs forward pass on [T, hidden]
+func (m *MoE) Forward(input *tensor.Tensor) (*tensor.Tensor, error) {
+    shape := input.Shape()
+    if len(shape) != 2 {
+        return nil, fmt.Errorf("moe input must be 2D [T, hidden]")
+    }
+    T, H := shape[0], shape[1]
+    E := len(m.experts)
+    x := input.Data().Data().([]float32)
+
+    routerLogits, err := m.router.Forward(input)
+    if err != nil { return nil, fmt.Errorf("router failed: %v", err) }
+    rl := routerLogits.Data().Data().([]float32)
+
+    // Route: group (token, weight) pairs by expert
+    tokens := make([][]int, E)
+    weights := make([][]float32, E)
+    probs := make([]float32, E)
+    chosen := make([]int, m.topK)
+    for t := 0; t < T; t++ {
+        routerProbs(probs, rl[t*E:(t+1)*E])
+        topIndices(chosen, probs)
+        var sum float32
+        for _, e := range chosen { sum += probs[e] }
+        for _, e := range chosen {
+            w := probs[e]
+            if m.normTopK && sum > 0 { w /= sum }
+            tokens[e] = append(tokens[e], t)
+            weights[e] = append(weights[e], w)
+        }
+    }
+    m.mu.Lock()
+    for e := range tokens { m.load[e] += int64(len(tokens[e])) }
+    m.mu.Unlock()
+
+    // One GEMM per active expert on its gathered rows, experts in parallel
+    results := make([][]float32, E)
+    errs := make([]error, E)
+    var wg sync.WaitGroup
+    sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+    for e := range m.experts {
+        if len(tokens[e]) == 0 { continue }
+        wg.Add(1)
+        go func(e int) {
+            defer wg.Done()
+            sem <- struct{}{}
+            defer func() { <-sem }()
+            results[e], errs[e] = m.runExpert(m.experts[e], x, H, tokens[e])
+        }(e)
+    }
+    wg.Wait()
+
+    output, err := tensor.NewTensor([]int{T, H}, tensor.Float32, tensor.CPU)
+    if err != nil { return nil, err }
+    out := output.Data().Data().([]float32)
+    // Combine in expert order so the result does not depend on scheduling
+    for e := range m.experts {
+        if errs[e] != nil { return nil, fmt.Errorf("expert %d failed: %v", e, errs[e]) }
+        for r, t := range tokens[e] {
+            w := weights[e][r]
+            src := results[e][r*H : (r+1)*H]
+            dst := out[t*H : (t+1)*H]
+            for j := range dst { dst[j] += w * src[j] }
+        }
+    }
+
+    if m.shared != nil {
+        sharedOut, err := m.shared.Forward(input)
+        if err != nil { return nil, fmt.Errorf("shared expert failed: %v", err) }
+        gate, err := m.sharedGate.Forward(input)
+        if err != nil { return nil, fmt.Errorf("shared expert gate failed: %v", err) }
+        so := sharedOut.Data().Data().([]float32)
+        g := gate.Data().Data().([]float32)
+        for t := 0; t < T; t++ {
+            w := float32(1 / (1 + math.Exp(-float64(g[t]))))
+            for j := 0; j < H; j++ { out[t*H+j] += w * so[t*H+j] }
+        }
+    }
+    return output, nil
+}
+
+// runExpert gathers the rows of x for tokens and runs expert on them,
+// returning [len(tokens), hidden]
+func (m *MoE) runExpert(expert *MLP, x []float32, H int, tokens []int) ([]float32, error) {
+    in, err := tensor.NewTensor([]int{len(tokens), H}, tensor.Float32, tensor.CPU)
+    if err != nil { return nil, err }
+    buf := in.Data().Data().([]float32)
+    for r, t := range tokens { copy(buf[r*H:(r+1)*H], x[t*H:(t+1)*H]) }
+    out, err := expert.Forward(in)
+    if err != nil { return nil, err }
+    return out.Data().Data().([]float32), nil
+}
+
+// routerProbs writes softmax(logits) into probs
+func routerProbs(probs, logits []float32) {
+    max := logits[0]
+    for _, v := range logits[1:] { if v > max { max = v } }
+    var sum float32
+    for i, v := range logits {
+        probs[i] = float32(math.Exp(float64(v - max)))
+        sum += probs[i]
+    }
+    for i := range probs { probs[i] /= sum }
+}
+
+// topIndices fills dst with the indices of the len(dst) largest probs,
+// highest first (ties to the lower index)
+func topIndices(dst []int, probs []float32) {
+    n := 0
+    for i, p := range probs {
+        if n < len(dst) {
+            n++
+        } else if p <= probs[dst[n-1]] {
+            continue
+        }
+        j := n - 1
+        for ; j > 0 && probs[dst[j-1]] < p; j-- { dst[j] = dst[j-1] }
+        dst[j] = i
+    }
+}
@@ -0,0 +1,118 @@
+package layers
+
+import (
+    "math"
+    "math/rand"
+    "reflect"
+    "sort"
+    "testing"
+)
+
+func TestTopIndices(t *testing.T) {
+    tests := []struct {
+        probs []float32
+        k     int
+        want  []int
+    }{
+        {[]float32{0.4, 0.3, 0.2, 0.1}, 2, []int{0, 1}},
+        {[]float32{0.1, 0.2, 0.3, 0.4}, 2, []int{3, 2}},
+        {[]float32{0.1, 0.5, 0.1, 0.3}, 1, []int{1}},
+        {[]float32{0.1, 0.5, 0.1, 0.3}, 4, []int{1, 3, 0, 2}},
+        // ties go to the lower index, also when the tie is at the cut
+        {[]float32{0.1, 0.4, 0.25, 0.25}, 2, []int{1, 2}},
+        {[]float32{0.25, 0.25, 0.25, 0.25}, 3, []int{0, 1, 2}},
+        {[]float32{0.2, 0.3, 0.2, 0.3}, 3, []int{1, 3, 0}},
+    }
+    for _, tt := range tests {
+        got := make([]int, tt.k)
+        // stale contents must not leak into the result
+        for i := range got { got[i] = len(tt.probs) - 1 }
+        topIndices(got, tt.probs)
+        if !reflect.DeepEqual(got, tt.want) { t.Errorf("top %d of %v = %v, want %v", tt.k, tt.probs, got, tt.want) }
+    }
+}
+
+// TestMoELoad routes tokens with a hand-made router and checks the
+// per-expert counts, across calls and after ResetLoad
+func TestMoELoad(t *testing.T) {
+    m, err := NewMoE(2, 4, 4, 2, true, "silu")
+    if err != nil { t.Fatal(err) }
+    // expert logits are x·[1,0], x·[0,1], x·[-1,0], x·[0,-1]
+    if err := m.SetRouterWeights([]float32{1, 0, 0, 1, -1, 0, 0, -1}); err != nil { t.Fatal(err) }
+
+    // [1,0] -> 0, then 1 over 3 (tie); [0,1] -> 1, 0; [-1,0] -> 2, 1
+    if _, err := m.Forward(matrix(t, 2, 1, 0, 0, 1, -1, 0)); err != nil { t.Fatal(err) }
+    if got := m.Load(); !reflect.DeepEqual(got, []int64{2, 3, 1, 0}) { t.Errorf("load %v, want [2 3 1 0]", got) }
+    // [0,-1] -> 3, then 0 over 2
+    if _, err := m.Forward(matrix(t, 2, 0, -1)); err != nil { t.Fatal(err) }
+    load := m.Load()
+    if !reflect.DeepEqual(load, []int64{3, 3, 1, 1}) { t.Errorf("load %v, want [3 3 1 1]", load) }
+
+    load[0] = 100
+    if m.Load()[0] != 3 { t.Error("Load returned the live counters") }
+    m.ResetLoad()
+    if got := m.Load(); !reflect.DeepEqual(got, []int64{0, 0, 0, 0}) { t.Errorf("load %v after ResetLoad", got) }
+}
+
+// TestMoEForward checks the parallel gather/combine against running each
+// token through its top-k experts and the shared expert one at a time
+func TestMoEForward(t *testing.T) {
+    const H, I, E, K, T = 8, 6, 5, 3, 24
+    rng := rand.New(rand.NewSource(1))
+    random := func(n int) []float32 {
+        v := make([]float32, n)
+        for i := range v { v[i] = float32(rng.NormFloat64()) }
+        return v
+    }
+    for _, normTopK := range []bool{false, true} {
+        m, err := NewMoE(H, I, E, K, normTopK, "silu")
+        if err != nil { t.Fatal(err) }
+        if err := m.SetSharedExpert(H, 4, "silu"); err != nil { t.Fatal(err) }
+        router, sharedGate := random(E*H), random(H)
+        if err := m.SetRouterWeights(router); err != nil { t.Fatal(err) }
+        if err := m.SetSharedGateWeights(sharedGate); err != nil { t.Fatal(err) }
+        for e := 0; e < E; e++ {
+            if err := m.Expert(e).LoadWeights(random(2*I*H), random(H*I)); err != nil { t.Fatal(err) }
+        }
+        if err := m.SharedExpert().LoadWeights(random(2*4*H), random(H*4)); err != nil { t.Fatal(err) }
+
+        x := random(T * H)
+        out, err := m.Forward(matrix(t, H, x...))
+        if err != nil { t.Fatal(err) }
+        got := out.Data().Data().([]float32)
+
+        for tok := 0; tok < T; tok++ {
+            row := x[tok*H : (tok+1)*H]
+            probs := make([]float64, E)
+            var z float64
+            for e := range probs {
+                var l float64
+                for j, v := range row { l += float64(v) * float64(router[e*H+j]) }
+                probs[e] = math.Exp(l)
+                z += probs[e]
+            }
+            order := []int{0, 1, 2, 3, 4}
+            sort.SliceStable(order, func(a, b int) bool { return probs[order[a]] > probs[order[b]] })
+            var sum float64
+            for _, e := range order[:K] { sum += probs[e] / z }
+
+            want := make([]float64, H)
+            for _, e := range order[:K] {
+                w := probs[e] / z
+                if normTopK { w /= sum }
+                y, err := m.Expert(e).Forward(matrix(t, H, row...))
+                if err != nil { t.Fatal(err) }
+                for j, v := range y.Data().Data().([]float32) { want[j] += w * float64(v) }
+            }
+            var g float64
+            for j, v := range row { g += float64(v) * float64(sharedGate[j]) }
+            y, err := m.SharedExpert().Forward(matrix(t, H, row...))
+            if err != nil { t.Fatal(err) }
+            for j, v := range y.Data().Data().([]float32) { want[j] += float64(v) / (1 + math.Exp(-g)) }
+            checkClose(t, "moe", got[tok*H:(tok+1)*H], want)
+        }
+        var routed int64
+        for _, n := range m.Load() { routed += n }
+        if routed != T*K { t.Errorf("normTopK %v: %d tokens routed, want %d", normTopK, routed, T*K) }
+    }
+}
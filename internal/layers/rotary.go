@@ -3,59 +3,181 @@ package layers
 import (
     "fmt"
     "math"
+    "sync"
 
     "github.com/unixsysdev/nano-go-vllm/internal/tensor"
 )
 
-// RotaryEmbedding represents rotary positional embedding
+// RotaryEmbedding represents rotary positional embedding. The cos/sin
+// table is grown on demand (see Ensure), so one instance can be shared by
+// every layer of a model without paying for max_position_embeddings rows.
+// Layers may rotate concurrently: the tables are only replaced, never
+// written in place, and readers take a snapshot of them under mu.
 type RotaryEmbedding struct {
     headDim       int
     rotaryDim     int
     maxPosition   int
     base          float64
-    cosCache      []float32
-    sinCache      []float32
+    invFreq       []float64
+    longInvFreq   []float64 // longrope frequencies for sequences longer than longFrom
+    longFrom      int
+    mscale        float64   // cos/sin multiplier (longrope, yarn)
     scalingType   string
     scalingFactor float64
+    interleaved   bool // rotate pairs (2i, 2i+1) instead of (i, i+rotaryDim/2)
+    // dynamic NTK: past dynamicFrom positions the frequencies depend on the
+    // sequence length and are computed on the fly
+    dynamicFrom int
+
+    mu       sync.Mutex // guards the fields below
+    cosCache []float32  // [rows * rotaryDim/2]
+    sinCache []float32
+    longCos  []float32 // longrope: table of longInvFreq, built once a sequence passes longFrom
+    longSin  []float32
+    dynamicSeqLen  int // sequence length dynamicInvFreq was computed for
+    dynamicInvFreq []float64
+}
+
+// RopeScaling mirrors the rope_scaling block of an HF config
+type RopeScaling struct {
+    Type                 string    // "linear", "dynamic", "yarn", "llama3", "longrope" ("" = none)
+    Factor               float64
+    LowFreqFactor        float64   // llama3
+    HighFreqFactor       float64   // llama3
+    OriginalMaxPosition  int       // original context length (0 = maxPosition; llama3: 8192)
+    ShortFactor          []float64 // longrope: per-frequency divisors up to OriginalMaxPosition tokens
+    LongFactor           []float64 // longrope: per-frequency divisors for longer sequences
+    AttentionFactor      float64   // longrope, yarn: cos/sin multiplier (0 = derived from the factor)
+    BetaFast, BetaSlow   float64   // yarn: ramp boundaries in rotations (defaults 32, 1)
+    Mscale, MscaleAllDim float64   // yarn: DeepSeek-style attention factor parameters
 }
 
-// NewRotaryEmbedding creates a new rotary embedding
-func NewRotaryEmbedding(headDim, rotaryDim, maxPosition int, base float64, scalingType string, scalingFactor float64) (*RotaryEmbedding, error) {
+// NewRotaryEmbedding creates a new rotary embedding. Scaling follows the HF
+// rope_type semantics; an unknown type is an error.
+func NewRotaryEmbedding(headDim, rotaryDim, maxPosition int, base float64, scaling RopeScaling) (*RotaryEmbedding, error) {
     if rotaryDim > headDim {
         return nil, fmt.Errorf("rotary dim cannot exceed head dim")
     }
+    scalingType, scalingFactor := scaling.Type, scaling.Factor
+    if scaling.OriginalMaxPosition <= 0 && scalingType != "llama3" {
+        scaling.OriginalMaxPosition = maxPosition
+    }
 
-    // Precompute cos and sin values
-    effBase := base
-    if scalingType == "linear" && scalingFactor > 0 {
-        effBase = base * scalingFactor
+    invFreq := ropeInvFreq(base, rotaryDim)
+    r := &RotaryEmbedding{
+        headDim:       headDim,
+        rotaryDim:     rotaryDim,
+        maxPosition:   maxPosition,
+        base:          base,
+        scalingType:   scalingType,
+        scalingFactor: scalingFactor,
+        dynamicFrom:   -1,
     }
-    invFreq := make([]float64, rotaryDim/2)
-    for i := 0; i < rotaryDim/2; i++ {
-        invFreq[i] = 1.0 / math.Pow(effBase, float64(i*2)/float64(rotaryDim))
+    // longrope (Phi-3) keeps a second set of frequencies that a sequence
+    // switches to as a whole once it outgrows the original context; longrope
+    // and yarn also scale cos/sin
+    var longInvFreq []float64
+    mscale := 1.0
+    longFrom := 0
+    switch scalingType {
+    case "", "default":
+    case "linear":
+        // positions are divided by the factor
+        if scalingFactor > 0 {
+            for i := range invFreq { invFreq[i] /= scalingFactor }
+        }
+    case "dynamic":
+        // the table covers the original context; longer sequences get
+        // NTK-scaled frequencies, and may reach factor times the original
+        if scalingFactor > 1 {
+            r.dynamicFrom = scaling.OriginalMaxPosition
+            if ext := int(float64(scaling.OriginalMaxPosition) * scalingFactor); ext > r.maxPosition {
+                r.maxPosition = ext
+            }
+        }
+    case "yarn":
+        mscale = yarnInvFreq(invFreq, base, rotaryDim, scaling)
+    case "llama3":
+        llama3InvFreq(invFreq, scaling)
+    case "longrope", "su":
+        var err error
+        if longInvFreq, mscale, err = longropeInvFreq(invFreq, maxPosition, scaling); err != nil {
+            return nil, err
+        }
+        longFrom = scaling.OriginalMaxPosition
+    default:
+        return nil, fmt.Errorf("unsupported rope_scaling type %q", scalingType)
     }
 
-	cosCache := make([]float32, maxPosition*rotaryDim/2)
-	sinCache := make([]float32, maxPosition*rotaryDim/2)
+    r.invFreq, r.longInvFreq, r.longFrom, r.mscale = invFreq, longInvFreq, longFrom, mscale
+    return r, nil
+}
 
-	for pos := 0; pos < maxPosition; pos++ {
-		for i := 0; i < rotaryDim/2; i++ {
-			freq := float64(pos) * invFreq[i]
-			cosCache[pos*rotaryDim/2+i] = float32(math.Cos(freq))
-			sinCache[pos*rotaryDim/2+i] = float32(math.Sin(freq))
-		}
-	}
+// ropeInitialRows is the first size of a lazily grown cos/sin table
+const ropeInitialRows = 256
 
-    return &RotaryEmbedding{
-        headDim:     headDim,
-        rotaryDim:   rotaryDim,
-        maxPosition: maxPosition,
-        base:        effBase,
-        cosCache:    cosCache,
-        sinCache:    sinCache,
-        scalingType: scalingType,
-        scalingFactor: scalingFactor,
-    }, nil
+// Ensure grows the cos/sin table to cover positions [0, n) (capped at the
+// maximum position), doubling so that growth is amortized. Callers must
+// Ensure a position before rotating at it; Attention does so once per
+// forward call. For longrope the short-factor table stops at the original
+// context and a sequence longer than that gets the long-factor table for
+// all of its positions.
+func (r *RotaryEmbedding) Ensure(n int) {
+    if n > r.maxPosition { n = r.maxPosition }
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.longInvFreq == nil {
+        r.cosCache, r.sinCache = r.grow(r.cosCache, r.sinCache, n, r.maxPosition, r.invFreq)
+        return
+    }
+    r.cosCache, r.sinCache = r.grow(r.cosCache, r.sinCache, min(n, r.longFrom), r.longFrom, r.invFreq)
+    if n > r.longFrom {
+        r.longCos, r.longSin = r.grow(r.longCos, r.longSin, n, r.maxPosition, r.longInvFreq)
+    }
+}
+
+// grow extends a cos/sin table of freq to at least n rows (at most limit)
+func (r *RotaryEmbedding) grow(cosCache, sinCache []float32, n, limit int, freq []float64) ([]float32, []float32) {
+    half := r.rotaryDim / 2
+    have := 0
+    if half > 0 { have = len(cosCache) / half }
+    if n <= have { return cosCache, sinCache }
+    rows := 2 * have
+    if rows < ropeInitialRows { rows = ropeInitialRows }
+    if rows < n { rows = n }
+    if rows > limit { rows = limit }
+    newCos := make([]float32, rows*half)
+    newSin := make([]float32, rows*half)
+    copy(newCos, cosCache)
+    copy(newSin, sinCache)
+    for pos := have; pos < rows; pos++ {
+        for i := 0; i < half; i++ {
+            sin, cos := math.Sincos(float64(pos) * freq[i])
+            newCos[pos*half+i] = float32(cos * r.mscale)
+            newSin[pos*half+i] = float32(sin * r.mscale)
+        }
+    }
+    return newCos, newSin
+}
+
+// MaxPosition returns the largest supported position + 1
+func (r *RotaryEmbedding) MaxPosition() int { return r.maxPosition }
+
+// SetInterleaved selects the pairing: adjacent dims (2i, 2i+1), as in
+// GPT-J, or by default the rotate-half layout (i, i+rotaryDim/2) of HF
+// Llama, Qwen and NeoX
+func (r *RotaryEmbedding) SetInterleaved(interleaved bool) { r.interleaved = interleaved }
+
+// RotaryDim returns the number of leading head dims that are rotated
+func (r *RotaryEmbedding) RotaryDim() int { return r.rotaryDim }
+
+// ropeInvFreq returns the unscaled inverse frequencies base^(-2i/dim)
+func ropeInvFreq(base float64, dim int) []float64 {
+    invFreq := make([]float64, dim/2)
+    for i := range invFreq {
+        invFreq[i] = 1.0 / math.Pow(base, float64(i*2)/float64(dim))
+    }
+    return invFreq
 }
 
 // Forward applies rotary embedding to query and key
@@ -74,6 +196,12 @@ func (r *RotaryEmbedding) Forward(positions, query, key *tensor.Tensor) (*tensor
 	
 	positionData := positions.Data().Data().([]int64)
 	
+	seqLenTotal := 0
+	for _, p := range positionData {
+		if int(p)+1 > seqLenTotal { seqLenTotal = int(p) + 1 }
+	}
+	r.Ensure(seqLenTotal)
+
 	// Apply rotary embedding
 	for i := 0; i < batchSize; i++ {
 		for j := 0; j < seqLen; j++ {
@@ -86,10 +214,10 @@ func (r *RotaryEmbedding) Forward(positions, query, key *tensor.Tensor) (*tensor
 			keyOffset := (i*seqLen + j) * r.headDim
 			
 			// Apply to query
-			r.applyRotary(queryData[queryOffset:queryOffset+r.rotaryDim], pos)
+			r.applyRotary(queryData[queryOffset:queryOffset+r.rotaryDim], pos, seqLenTotal)
 			
 			// Apply to key
-			r.applyRotary(keyData[keyOffset:keyOffset+r.rotaryDim], pos)
+			r.applyRotary(keyData[keyOffset:keyOffset+r.rotaryDim], pos, seqLenTotal)
 		}
 	}
 	
@@ -111,19 +239,141 @@ func (r *RotaryEmbedding) Forward(positions, query, key *tensor.Tensor) (*tensor
 	return queryOut, keyOut, nil
 }
 
-// applyRotary applies rotary embedding to a slice of data
-func (r *RotaryEmbedding) applyRotary(data []float32, pos int) {
-    for i := 0; i < r.rotaryDim/2; i++ {
-        idx1 := i * 2
-        idx2 := i * 2 + 1
-        
-        cos := r.cosCache[pos*r.rotaryDim/2+i]
-        sin := r.sinCache[pos*r.rotaryDim/2+i]
-        
-        x1 := data[idx1]
-        x2 := data[idx2]
-        
-        data[idx1] = x1*cos - x2*sin
-        data[idx2] = x2*cos + x1*sin
+// applyRotary rotates the first rotaryDim dims of data (one head) for
+// position pos of a sequence of seqLen tokens (dynamic NTK and longrope
+// depend on seqLen, as in HF: the frequencies are chosen for the whole
+// sequence, not per position); the remaining dims pass through
+func (r *RotaryEmbedding) applyRotary(data []float32, pos, seqLen int) {
+    cosCache, sinCache, dynamic := r.tables(seqLen)
+    if dynamic != nil {
+        for i, inv := range dynamic {
+            sin, cos := math.Sincos(float64(pos) * inv)
+            r.rotate(data, i, float32(cos), float32(sin))
+        }
+        return
+    }
+    half := r.rotaryDim / 2
+    cosRow := cosCache[pos*half : (pos+1)*half]
+    sinRow := sinCache[pos*half : (pos+1)*half]
+    for i := 0; i < half; i++ {
+        r.rotate(data, i, cosRow[i], sinRow[i])
+    }
+}
+
+// tables snapshots, under the lock, the cos/sin table for a sequence of
+// seqLen tokens, or for dynamic NTK past the original context the scaled
+// frequencies (HF _compute_dynamic_ntk_parameters):
+// base' = base * (factor*seqLen/orig - (factor-1))^(dim/(dim-2))
+func (r *RotaryEmbedding) tables(seqLen int) (cos, sin []float32, dynamic []float64) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.dynamicFrom >= 0 && seqLen > r.dynamicFrom {
+        if seqLen != r.dynamicSeqLen {
+            dim := float64(r.rotaryDim)
+            f := r.scalingFactor
+            base := r.base * math.Pow(f*float64(seqLen)/float64(r.dynamicFrom)-(f-1), dim/(dim-2))
+            r.dynamicInvFreq = ropeInvFreq(base, r.rotaryDim)
+            r.dynamicSeqLen = seqLen
+        }
+        return nil, nil, r.dynamicInvFreq
+    }
+    if r.longInvFreq != nil && seqLen > r.longFrom { return r.longCos, r.longSin, nil }
+    return r.cosCache, r.sinCache, nil
+}
+
+// rotate applies the rotation of frequency i to its pair of dims
+func (r *RotaryEmbedding) rotate(data []float32, i int, cos, sin float32) {
+    i1, i2 := i, i+r.rotaryDim/2
+    if r.interleaved { i1, i2 = 2*i, 2*i+1 }
+    x1, x2 := data[i1], data[i2]
+    data[i1] = x1*cos - x2*sin
+    data[i2] = x2*cos + x1*sin
+}
+
+// llama3InvFreq rescales inverse frequencies as Llama 3.1 does: long
+// wavelengths are divided by the factor, short ones kept, and the band in
+// between interpolated smoothly (HF _compute_llama3_parameters)
+func llama3InvFreq(invFreq []float64, s RopeScaling) {
+    factor, low, high := s.Factor, s.LowFreqFactor, s.HighFreqFactor
+    if factor <= 0 { factor = 8 }
+    if low <= 0 { low = 1 }
+    if high <= 0 { high = 4 }
+    oldLen := float64(s.OriginalMaxPosition)
+    if oldLen <= 0 { oldLen = 8192 }
+    lowWavelen, highWavelen := oldLen/low, oldLen/high
+    for i, f := range invFreq {
+        wavelen := 2 * math.Pi / f
+        switch {
+        case wavelen < highWavelen:
+            // high frequency: unchanged
+        case wavelen > lowWavelen:
+            invFreq[i] = f / factor
+        default:
+            smooth := (oldLen/wavelen - low) / (high - low)
+            invFreq[i] = (1-smooth)*f/factor + smooth*f
+        }
+    }
+}
+
+// longropeInvFreq divides invFreq in place by the short factors and returns
+// the long-factor frequencies and the cos/sin scale
+// (HF _compute_longrope_parameters)
+func longropeInvFreq(invFreq []float64, maxPosition int, s RopeScaling) ([]float64, float64, error) {
+    if len(s.ShortFactor) != len(invFreq) || len(s.LongFactor) != len(invFreq) {
+        return nil, 0, fmt.Errorf("longrope needs %d short and long factors, got %d and %d",
+            len(invFreq), len(s.ShortFactor), len(s.LongFactor))
+    }
+    if s.OriginalMaxPosition <= 0 {
+        return nil, 0, fmt.Errorf("longrope needs original_max_position_embeddings")
+    }
+    long := make([]float64, len(invFreq))
+    for i, f := range invFreq {
+        long[i] = f / s.LongFactor[i]
+        invFreq[i] = f / s.ShortFactor[i]
+    }
+    mscale := s.AttentionFactor
+    if mscale <= 0 {
+        factor := s.Factor
+        if factor <= 0 { factor = float64(maxPosition) / float64(s.OriginalMaxPosition) }
+        mscale = 1
+        if factor > 1 {
+            mscale = math.Sqrt(1 + math.Log(factor)/math.Log(float64(s.OriginalMaxPosition)))
+        }
+    }
+    return long, mscale, nil
+}
+
+// yarnInvFreq blends interpolated (divided by the factor) and extrapolated
+// frequencies with a linear ramp between the beta_fast and beta_slow
+// correction dims, and returns the cos/sin attention factor
+// (HF _compute_yarn_parameters)
+func yarnInvFreq(invFreq []float64, base float64, dim int, s RopeScaling) float64 {
+    factor := s.Factor
+    if factor <= 0 { factor = 1 }
+    betaFast, betaSlow := s.BetaFast, s.BetaSlow
+    if betaFast <= 0 { betaFast = 32 }
+    if betaSlow <= 0 { betaSlow = 1 }
+    orig := float64(s.OriginalMaxPosition)
+
+    correctionDim := func(rotations float64) float64 {
+        return float64(dim) * math.Log(orig/(rotations*2*math.Pi)) / (2 * math.Log(base))
+    }
+    low := math.Max(math.Floor(correctionDim(betaFast)), 0)
+    high := math.Min(math.Ceil(correctionDim(betaSlow)), float64(dim-1))
+    if low == high { high += 0.001 }
+    for i, f := range invFreq {
+        ramp := math.Min(math.Max((float64(i)-low)/(high-low), 0), 1)
+        extrapolation := 1 - ramp
+        invFreq[i] = f/factor*(1-extrapolation) + f*extrapolation
+    }
+
+    if s.AttentionFactor > 0 { return s.AttentionFactor }
+    getMscale := func(scale, m float64) float64 {
+        if scale <= 1 { return 1 }
+        return 0.1*m*math.Log(scale) + 1
+    }
+    if s.Mscale > 0 && s.MscaleAllDim > 0 {
+        return getMscale(factor, s.Mscale) / getMscale(factor, s.MscaleAllDim)
     }
+    return getMscale(factor, 1)
 }
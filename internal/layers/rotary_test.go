@@ -0,0 +1,168 @@
+package layers
+
+import (
+    "reflect"
+    "sync"
+    "testing"
+)
+
+// ropeCosSin rotates a head whose first rotaryDim/2 dims are 1 and reads
+// back the cos/sin pair of every frequency at pos in a sequence of seqLen
+func ropeCosSin(r *RotaryEmbedding, pos, seqLen int) (cos, sin []float32) {
+    r.Ensure(seqLen)
+    half := r.rotaryDim / 2
+    data := make([]float32, r.headDim)
+    for i := 0; i < half; i++ { data[i] = 1 }
+    r.applyRotary(data, pos, seqLen)
+    return data[:half], data[half:r.rotaryDim]
+}
+
+// TestRotateHalfPartial rotates a head of 8 dims with rotary dim 4 (NeoX
+// rotary_pct 0.5) and compares with HF apply_rotary_pos_emb: dims pair as
+// (0, 2) and (1, 3), and dims 4..7 pass through unchanged
+func TestRotateHalfPartial(t *testing.T) {
+    r, err := NewRotaryEmbedding(8, 4, 64, 10000, RopeScaling{})
+    if err != nil { t.Fatal(err) }
+    r.Ensure(4)
+    data := []float32{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8}
+    r.applyRotary(data, 3, 4)
+    checkClose(t, "rotated", data, []float64{-0.14133525, 0.18791181, -0.28288575, 0.40581911, 0.5, 0.6, 0.7, 0.8})
+}
+
+// TestAdjacentPairPartial is TestRotateHalfPartial with the GPT-J layout
+// (HF GPTJ apply_rotary_pos_emb, rotate_every_two): frequency i rotates
+// dims (2i, 2i+1)
+func TestAdjacentPairPartial(t *testing.T) {
+    r, err := NewRotaryEmbedding(8, 4, 64, 10000, RopeScaling{})
+    if err != nil { t.Fatal(err) }
+    r.SetInterleaved(true)
+    r.Ensure(4)
+    data := []float32{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8}
+    r.applyRotary(data, 3, 4)
+    checkClose(t, "rotated", data, []float64{-0.12722325, -0.1838865, 0.28786681, 0.40881866, 0.5, 0.6, 0.7, 0.8})
+}
+
+// TestLongropeSequenceLength checks that a sequence switches to the long
+// factors as a whole once it is longer than the original context (HF
+// _longrope_frequency_update): position 5 rotates differently in a
+// sequence of 20 tokens than in one of 10
+func TestLongropeSequenceLength(t *testing.T) {
+    r, err := NewRotaryEmbedding(8, 8, 64, 10000, RopeScaling{
+        Type:                "longrope",
+        OriginalMaxPosition: 16,
+        ShortFactor:         []float64{1, 1.5, 2, 3},
+        LongFactor:          []float64{2, 4, 8, 16},
+    })
+    if err != nil { t.Fatal(err) }
+    // cos/sin scale sqrt(1 + ln(64/16)/ln 16) = sqrt(1.5)
+    tests := []struct {
+        pos, seqLen int
+        cos, sin    []float64
+    }{
+        {5, 10, []float64{0.34741381, 1.1573312, 1.2243622, 1.2247432}, []float64{-1.1744376, 0.40073003, 0.030615432, 0.0020412405}},
+        {5, 20, []float64{-0.98119653, 1.215189, 1.224721, 1.2247448}, []float64{0.73297569, 0.15269474, 0.0076546056, 0.00038273277}},
+        {15, 16, []float64{-0.93042388, 0.66173248, 1.2213019, 1.2247296}, []float64{0.7964367, 1.0305873, 0.091769775, 0.0061236988}},
+        {15, 17, []float64{0.42453983, 1.1396344, 1.2245296, 1.2247443}, []float64{1.1488107, 0.4485904, 0.022962621, 0.0011481981}},
+        {40, 41, []float64{0.49979641, 0.66173248, 1.2232143, 1.224741}, []float64{1.118125, 1.0305873, 0.061211731, 0.003061859}},
+    }
+    for _, tt := range tests {
+        cos, sin := ropeCosSin(r, tt.pos, tt.seqLen)
+        checkClose(t, "cos", cos, tt.cos)
+        checkClose(t, "sin", sin, tt.sin)
+    }
+}
+
+// TestRopeScaling checks cos/sin against values computed with the HF
+// rope_init_fn formulas (head dim 16, rope_theta 10000, 64 positions)
+func TestRopeScaling(t *testing.T) {
+    type row struct {
+        pos, seqLen int
+        cos, sin    []float64
+    }
+    tests := []struct {
+        name    string
+        scaling RopeScaling
+        rows    []row
+    }{
+        {"linear", RopeScaling{Type: "linear", Factor: 4}, []row{
+            {5, 6, []float64{0.31532236, 0.92288697, 0.99219767, 0.99921885, 0.99992188, 0.99999219, 0.99999922, 0.99999992}, []float64{0.94898462, 0.38507096, 0.12467473, 0.039518178, 0.012499674, 0.0039528368, 0.0012499997, 0.0003952847}},
+            {63, 64, []float64{-0.99911659, 0.26499461, -0.0042036608, 0.8785116, 0.98762249, 0.99875994, 0.99987597, 0.9999876}, []float64{-0.042024353, -0.96424989, 0.99999116, 0.47772101, 0.15684964, 0.049785284, 0.015749349, 0.0049805667}},
+        }},
+        // unscaled up to 64 tokens; a 100-token sequence gets an NTK base
+        // for all of its positions
+        {"dynamic", RopeScaling{Type: "dynamic", Factor: 2}, []row{
+            {5, 6, []float64{0.28366219, -0.010342319, 0.87758256, 0.98752602, 0.99875026, 0.999875, 0.9999875, 0.99999875}, []float64{-0.95892427, 0.99994652, 0.47942554, 0.1574559, 0.049979169, 0.01581073, 0.0049999792, 0.0015811382}},
+            {5, 100, []float64{0.28366219, 0.15049666, 0.91983988, 0.99345601, 0.99947186, 0.99995742, 0.99999657, 0.99999972}, []float64{-0.95892427, 0.98861052, 0.39229402, 0.11421536, 0.032496104, 0.0092286044, 0.0026204516, 0.00074406526}},
+            {99, 100, []float64{0.03982088, -0.98662043, -0.12752947, -0.64085678, 0.79997898, 0.98335147, 0.99865428, 0.99989148}, []float64{-0.99920683, 0.16303411, 0.99183478, 0.76766047, 0.60002803, 0.18171377, 0.051861724, 0.014731961}},
+        }},
+        // attention factor 0.1*ln 4 + 1
+        {"yarn", RopeScaling{Type: "yarn", Factor: 4, OriginalMaxPosition: 16}, []row{
+            {5, 6, []float64{0.32298611, 1.0508263, 1.1297455, 1.13774, 1.1385405, 1.1386205, 1.1386285, 1.1386293}, []float64{-1.0918594, 0.43845313, 0.14195832, 0.04499656, 0.014232497, 0.0045008163, 0.0014232864, 0.00045008279}},
+            {63, 64, []float64{1.1225709, 0.30173067, -0.004786412, 1.0002992, 1.124536, 1.1372175, 1.1384882, 1.1386153}, []float64{0.19055613, -1.0979233, 1.1386194, 0.54394721, 0.17859362, 0.05668699, 0.017932672, 0.0056710199}},
+        }},
+        {"llama3", RopeScaling{Type: "llama3", Factor: 8, LowFreqFactor: 1, HighFreqFactor: 4, OriginalMaxPosition: 32}, []row{
+            {5, 6, []float64{0.28366219, 0.88736409, 0.99804751, 0.99980469, 0.99998047, 0.99999805, 0.9999998, 0.99999998}, []float64{-0.95892427, 0.46106937, 0.062459318, 0.019762949, 0.0062499593, 0.0019764223, 0.00062499996, 0.00019764235}},
+            {63, 64, []float64{0.98589658, 0.97007272, 0.705619, 0.9691521, 0.99690082, 0.99968994, 0.99996899, 0.9999969}, []float64{0.1673557, -0.24281456, 0.70859144, 0.24646338, 0.07866863, 0.024900363, 0.0078749186, 0.0024902911}},
+        }},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r, err := NewRotaryEmbedding(16, 16, 64, 10000, tt.scaling)
+            if err != nil { t.Fatal(err) }
+            for _, row := range tt.rows {
+                cos, sin := ropeCosSin(r, row.pos, row.seqLen)
+                checkClose(t, "cos", cos, row.cos)
+                checkClose(t, "sin", sin, row.sin)
+            }
+        })
+    }
+}
+
+// TestRopeSharedLazyGrowth shares one table between goroutines that grow
+// it to different lengths while rotating (as the layers of a model do), and
+// checks every rotation against a table built eagerly to the maximum
+// position; dynamic NTK sequences also switch frequencies concurrently
+func TestRopeSharedLazyGrowth(t *testing.T) {
+    const maxPos, workers, steps = 1024, 8, 64
+    for _, scaling := range []RopeScaling{{}, {Type: "dynamic", Factor: 2, OriginalMaxPosition: 512}} {
+        newRope := func() *RotaryEmbedding {
+            r, err := NewRotaryEmbedding(8, 8, maxPos, 10000, scaling)
+            if err != nil { t.Fatal(err) }
+            return r
+        }
+        lazy := newRope()
+        lazy.Ensure(1)
+        if rows := len(lazy.cosCache) / 4; rows != ropeInitialRows { t.Errorf("%q: %d rows after Ensure(1), want %d", scaling.Type, rows, ropeInitialRows) }
+        lazy.Ensure(300)
+        if rows := len(lazy.cosCache) / 4; rows != 2*ropeInitialRows { t.Errorf("%q: %d rows after Ensure(300), want %d", scaling.Type, rows, 2*ropeInitialRows) }
+
+        eager := newRope()
+        eager.Ensure(maxPos)
+        seqLen := func(w, i int) int { return 1 + (w*271+i*97)%maxPos }
+        want := make([][]float32, workers*steps)
+        for w := 0; w < workers; w++ {
+            for i := 0; i < steps; i++ {
+                n := seqLen(w, i)
+                cos, sin := ropeCosSin(eager, n-1, n)
+                want[w*steps+i] = append(cos, sin...)
+            }
+        }
+
+        var wg sync.WaitGroup
+        for w := 0; w < workers; w++ {
+            wg.Add(1)
+            go func(w int) {
+                defer wg.Done()
+                for i := 0; i < steps; i++ {
+                    n := seqLen(w, i)
+                    cos, sin := ropeCosSin(lazy, n-1, n)
+                    if got := append(cos, sin...); !reflect.DeepEqual(got, want[w*steps+i]) {
+                        t.Errorf("%q: position %d of %d: got %v, want %v", scaling.Type, n-1, n, got, want[w*steps+i])
+                        return
+                    }
+                }
+            }(w)
+        }
+        wg.Wait()
+    }
+}
@@ -0,0 +1,474 @@
+package models
+
+import (
+    "fmt"
+    "math"
+
+    ggtensor "gorgonia.org/tensor"
+
+    "github.com/unixsysdev/nano-go-vllm/internal/config"
+    "github.com/unixsysdev/nano-go-vllm/internal/layers"
+    "github.com/unixsysdev/nano-go-vllm/internal/tensor"
+    "github.com/unixsysdev/nano-go-vllm/pkg/safetensors"
+)
+
+// DecoderModel is a Llama-style decoder-only transformer (pre-norm RMSNorm,
+// RoPE attention with GQA, gated MLP). Qwen, Llama, Mistral and Gemma all map
+// onto it with HF weight names; the differences are config-driven options.
+type DecoderModel struct {
+    config      *config.Config
+    weights     weightMap
+    embedTokens *layers.Embedding
+    layers      []*DecoderLayer
+    norm        *layers.RMSNorm
+    lmHead      *layers.Linear // nil when tied to embedTokens
+}
+
+// feedForward is the MLP slot of a layer: dense or mixture-of-experts
+type feedForward interface {
+    Forward(input *tensor.Tensor) (*tensor.Tensor, error)
+}
+
+// DecoderLayer is a single pre-norm transformer block
+type DecoderLayer struct {
+    inputLayernorm *layers.RMSNorm
+    selfAttn       *layers.Attention
+    preMLPNorm     *layers.RMSNorm // HF post_attention_layernorm (pre_feedforward_layernorm with sandwich norms)
+    mlp            feedForward     // *layers.MLP or *layers.MoE
+
+    // sandwich norms on the block outputs (Gemma 2/3), nil otherwise
+    attnOutNorm *layers.RMSNorm
+    mlpOutNorm  *layers.RMSNorm
+}
+
+// newDecoder adapts NewDecoderModel to the registry
+func newDecoder(cfg *config.Config) (CausalLM, error) {
+    return decoderWith(llamaWeights)(cfg)
+}
+
+// decoderWith returns a registry constructor for checkpoints whose layer
+// tensors follow wm
+func decoderWith(wm weightMap) Constructor {
+    return func(cfg *config.Config) (CausalLM, error) {
+        m, err := newDecoderModel(cfg, wm)
+        if err != nil { return nil, err }
+        return m, nil
+    }
+}
+
+// NewDecoderModel builds the model from config and loads weights with the
+// Llama tensor names from cfg.ModelPath
+func NewDecoderModel(cfg *config.Config) (*DecoderModel, error) {
+    return newDecoderModel(cfg, llamaWeights)
+}
+
+// newDecoderModel is NewDecoderModel for an arbitrary weight map
+func newDecoderModel(cfg *config.Config, wm weightMap) (*DecoderModel, error) {
+    embed, err := layers.NewEmbedding(cfg.VocabSize, cfg.HiddenSize)
+    if err != nil { return nil, fmt.Errorf("embedding: %v", err) }
+    if cfg.ScaleEmbeddings {
+        embed.SetScale(float32(math.Sqrt(float64(cfg.HiddenSize))))
+    }
+    m := &DecoderModel{config: cfg, weights: wm, embedTokens: embed}
+    ropes, err := newModelRopes(cfg)
+    if err != nil { return nil, fmt.Errorf("rotary embedding: %v", err) }
+    for i := 0; i < cfg.NumHiddenLayers; i++ {
+        l, err := newDecoderLayer(cfg, i, ropes)
+        if err != nil { return nil, fmt.Errorf("layer %d %v", i, err) }
+        m.layers = append(m.layers, l)
+    }
+    if m.norm, err = newNorm(cfg, cfg.HiddenSize); err != nil {
+        return nil, fmt.Errorf("final norm: %v", err)
+    }
+    if !cfg.TieWordEmbeddings {
+        if m.lmHead, err = layers.NewLinear(cfg.HiddenSize, cfg.VocabSize, false); err != nil {
+            return nil, fmt.Errorf("lm head: %v", err)
+        }
+    }
+    if err := m.loadWeights(cfg.ModelPath); err != nil {
+        return nil, fmt.Errorf("load weights: %v", err)
+    }
+    return m, nil
+}
+
+// newDecoderLayer builds layer i with the options cfg enables
+func newDecoderLayer(cfg *config.Config, i int, ropes modelRopes) (*DecoderLayer, error) {
+    l := &DecoderLayer{}
+    var err error
+    if l.inputLayernorm, err = newNorm(cfg, cfg.HiddenSize); err != nil { return nil, fmt.Errorf("input norm: %v", err) }
+    if l.preMLPNorm, err = newNorm(cfg, cfg.HiddenSize); err != nil { return nil, fmt.Errorf("post norm: %v", err) }
+    if cfg.SandwichNorm {
+        if l.attnOutNorm, err = newNorm(cfg, cfg.HiddenSize); err != nil { return nil, fmt.Errorf("attention output norm: %v", err) }
+        if l.mlpOutNorm, err = newNorm(cfg, cfg.HiddenSize); err != nil { return nil, fmt.Errorf("mlp output norm: %v", err) }
+    }
+
+    sliding := isSlidingLayer(cfg, i)
+    rope := ropes.global
+    if sliding && ropes.local != nil { rope = ropes.local }
+    if l.selfAttn, err = layers.NewAttention(cfg.HiddenSize, cfg.NumAttentionHeads, cfg.NumKeyValueHeads, cfg.HeadDim,
+        rope, cfg.AttentionBias); err != nil {
+        return nil, fmt.Errorf("attention: %v", err)
+    }
+    if sliding { l.selfAttn.SetSlidingWindow(cfg.SlidingWindow) }
+    if cfg.QueryPreAttnScalar > 0 {
+        l.selfAttn.SetScale(float32(1 / math.Sqrt(cfg.QueryPreAttnScalar)))
+    }
+    l.selfAttn.SetSoftCap(float32(cfg.AttnLogitSoftcapping))
+    if cfg.QKNorm {
+        qNorm, err := newNorm(cfg, cfg.HeadDim)
+        if err != nil { return nil, fmt.Errorf("q norm: %v", err) }
+        kNorm, err := newNorm(cfg, cfg.HeadDim)
+        if err != nil { return nil, fmt.Errorf("k norm: %v", err) }
+        l.selfAttn.SetQKNorm(qNorm, kNorm)
+    }
+
+    if isSparseLayer(cfg, i) {
+        l.mlp, err = newMoE(cfg)
+    } else {
+        l.mlp, err = layers.NewMLP(cfg.HiddenSize, cfg.IntermediateSize, cfg.HiddenAct)
+    }
+    if err != nil { return nil, fmt.Errorf("mlp: %v", err) }
+    return l, nil
+}
+
+// newNorm creates an RMSNorm with the config's eps and weight convention
+func newNorm(cfg *config.Config, dim int) (*layers.RMSNorm, error) {
+    n, err := layers.NewRMSNorm(dim, float32(cfg.RMSNormEps))
+    if err != nil { return nil, err }
+    n.SetWeightOffset(float32(cfg.NormWeightOffset))
+    return n, nil
+}
+
+// isSlidingLayer reports whether layer i uses the sliding window; without
+// layer_types every layer does (Mistral)
+func isSlidingLayer(cfg *config.Config, i int) bool {
+    if i < len(cfg.LayerTypes) { return cfg.LayerTypes[i] == "sliding_attention" }
+    return true
+}
+
+// Config returns the model configuration
+func (m *DecoderModel) Config() *config.Config { return m.config }
+
+// ResetKVCache clears the active KV cache of every layer
+func (m *DecoderModel) ResetKVCache() {
+    for _, l := range m.layers { l.selfAttn.ResetCache() }
+}
+
+// UseKVCache switches every layer to the KV cache of sequence id
+func (m *DecoderModel) UseKVCache(id int) {
+    for _, l := range m.layers { l.selfAttn.UseCache(id) }
+}
+
+// FreeKVCache releases the KV cache of sequence id
+func (m *DecoderModel) FreeKVCache(id int) {
+    for _, l := range m.layers { l.selfAttn.FreeCache(id) }
+}
+
+// Forward runs the decoder on inputIDs [T] at positions [T] and returns the
+// logits [len(rows), vocab] of the selected rows (nil: the last one)
+func (m *DecoderModel) Forward(inputIDs, positions *tensor.Tensor, rows []int) (*tensor.Tensor, error) {
+    logits, _, err := m.ForwardHidden(inputIDs, positions, rows)
+    return logits, err
+}
+
+// ForwardHidden is Forward that also returns the last-layer hidden states
+// [T, hidden] (after the final norm)
+func (m *DecoderModel) ForwardHidden(inputIDs, positions *tensor.Tensor, rows []int) (*tensor.Tensor, *tensor.Tensor, error) {
+    hidden, err := m.embedTokens.Forward(inputIDs)
+    if err != nil { return nil, nil, fmt.Errorf("embedding: %v", err) }
+    for i, l := range m.layers {
+        if hidden, err = l.Forward(hidden, positions); err != nil {
+            return nil, nil, fmt.Errorf("layer %d: %v", i, err)
+        }
+    }
+    normed, err := m.norm.Forward(hidden)
+    if err != nil { return nil, nil, fmt.Errorf("final norm: %v", err) }
+    selected, err := selectRows(normed, rows)
+    if err != nil { return nil, nil, err }
+    logits, err := m.logits(selected)
+    if err != nil { return nil, nil, fmt.Errorf("lm head: %v", err) }
+    layers.SoftCap(logits.Data().Data().([]float32), float32(m.config.FinalLogitSoftcapping))
+    return logits, normed, nil
+}
+
+// logits applies the lm_head, or the embedding matrix when they are tied
+func (m *DecoderModel) logits(normed *tensor.Tensor) (*tensor.Tensor, error) {
+    return headLogits(m.lmHead, m.embedTokens, normed)
+}
+
+// headLogits applies head, or embed's matrix when head is nil (tied). The
+// head output is copied out of the layer's reused buffer, since callers keep
+// logits across forwards (classifier-free guidance).
+func headLogits(head *layers.Linear, embed *layers.Embedding, normed *tensor.Tensor) (*tensor.Tensor, error) {
+    if head == nil { return embed.Attend(normed) }
+    out, err := head.Forward(normed)
+    if err != nil { return nil, err }
+    logits, err := tensor.NewTensor(out.Shape(), tensor.Float32, tensor.CPU)
+    if err != nil { return nil, err }
+    copy(logits.Data().Data().([]float32), out.Data().Data().([]float32))
+    return logits, nil
+}
+
+// Lookahead returns the last-layer hidden states [K, hidden] that each of
+// the candidate tokens would produce as the next token, in one batched pass
+// that leaves the KV cache unchanged (contrastive search)
+func (m *DecoderModel) Lookahead(candidates []int) (*tensor.Tensor, error) {
+    ids, err := tensor.NewTensor([]int{len(candidates)}, tensor.Int64, tensor.CPU)
+    if err != nil { return nil, err }
+    dense := ids.Data().(*ggtensor.Dense)
+    for i, id := range candidates { dense.Set(i, int64(id)) }
+    hidden, err := m.embedTokens.Forward(ids)
+    if err != nil { return nil, fmt.Errorf("embedding: %v", err) }
+    for i, l := range m.layers {
+        if hidden, err = l.forward(hidden, l.selfAttn.ForwardCandidates); err != nil {
+            return nil, fmt.Errorf("layer %d: %v", i, err)
+        }
+    }
+    return m.norm.Forward(hidden)
+}
+
+// Forward runs attention and MLP with residual connections
+func (l *DecoderLayer) Forward(hidden, positions *tensor.Tensor) (*tensor.Tensor, error) {
+    return l.forward(hidden, func(x *tensor.Tensor) (*tensor.Tensor, error) {
+        return l.selfAttn.Forward(x, positions)
+    })
+}
+
+// forward is the pre-norm block with a pluggable attention call
+func (l *DecoderLayer) forward(hidden *tensor.Tensor, attn func(*tensor.Tensor) (*tensor.Tensor, error)) (*tensor.Tensor, error) {
+    normed, err := l.inputLayernorm.Forward(hidden)
+    if err != nil { return nil, err }
+    attnOut, err := attn(normed)
+    if err != nil { return nil, err }
+    if l.attnOutNorm != nil {
+        if attnOut, err = l.attnOutNorm.Forward(attnOut); err != nil { return nil, err }
+    }
+    h, err := residualAdd(hidden, attnOut)
+    if err != nil { return nil, err }
+    normed, err = l.preMLPNorm.Forward(h)
+    if err != nil { return nil, err }
+    mlpOut, err := l.mlp.Forward(normed)
+    if err != nil { return nil, err }
+    if l.mlpOutNorm != nil {
+        if mlpOut, err = l.mlpOutNorm.Forward(mlpOut); err != nil { return nil, err }
+    }
+    return residualAdd(h, mlpOut)
+}
+
+// residualAdd returns a + b for two [T, hidden] tensors
+func residualAdd(a, b *tensor.Tensor) (*tensor.Tensor, error) {
+    out, err := tensor.NewTensor(a.Shape(), tensor.Float32, tensor.CPU)
+    if err != nil { return nil, err }
+    ad := a.Data().Data().([]float32)
+    bd := b.Data().Data().([]float32)
+    od := out.Data().Data().([]float32)
+    if len(ad) != len(bd) { return nil, fmt.Errorf("residual shape mismatch: %v vs %v", a.Shape(), b.Shape()) }
+    for i := range od { od[i] = ad[i] + bd[i] }
+    return out, nil
+}
+
+// selectRows gathers the given rows of t [T, D] into a new [len(rows), D]
+// tensor; nil rows selects the last one, and all rows return t itself
+func selectRows(t *tensor.Tensor, rows []int) (*tensor.Tensor, error) {
+    T, D := t.Shape()[0], t.Shape()[1]
+    if rows == nil { rows = []int{T - 1} }
+    if len(rows) == T {
+        all := true
+        for i, r := range rows { if r != i { all = false; break } }
+        if all { return t, nil }
+    }
+    out, err := tensor.NewTensor([]int{len(rows), D}, tensor.Float32, tensor.CPU)
+    if err != nil { return nil, err }
+    src := t.Data().Data().([]float32)
+    dst := out.Data().Data().([]float32)
+    for i, r := range rows {
+        if r < 0 || r >= T { return nil, fmt.Errorf("logit row %d out of range [0, %d)", r, T) }
+        copy(dst[i*D:(i+1)*D], src[r*D:(r+1)*D])
+    }
+    return out, nil
+}
+
+// loadWeights reads HF-named tensors from the safetensors shards in dir
+func (m *DecoderModel) loadWeights(dir string) error {
+    st, err := safetensors.OpenDir(dir)
+    if err != nil { return err }
+    read := newTensorReader(st)
+    cfg, wm := m.config, m.weights
+    H, I := cfg.HiddenSize, cfg.IntermediateSize
+    qDim := cfg.NumAttentionHeads * cfg.HeadDim
+    kvDim := cfg.NumKeyValueHeads * cfg.HeadDim
+
+    w, err := read("model.embed_tokens.weight", cfg.VocabSize*H)
+    if err != nil { return err }
+    if err := m.embedTokens.LoadWeights(w); err != nil { return err }
+
+    for i, l := range m.layers {
+        p := fmt.Sprintf("model.layers.%d.", i)
+        if w, err = read(p+"input_layernorm.weight", H); err != nil { return err }
+        if err := l.inputLayernorm.LoadWeights(w); err != nil { return err }
+        if cfg.SandwichNorm {
+            if w, err = read(p+"post_attention_layernorm.weight", H); err != nil { return err }
+            if err := l.attnOutNorm.LoadWeights(w); err != nil { return err }
+            if w, err = read(p+"pre_feedforward_layernorm.weight", H); err != nil { return err }
+            if err := l.preMLPNorm.LoadWeights(w); err != nil { return err }
+            if w, err = read(p+"post_feedforward_layernorm.weight", H); err != nil { return err }
+            if err := l.mlpOutNorm.LoadWeights(w); err != nil { return err }
+        } else {
+            if w, err = read(p+"post_attention_layernorm.weight", H); err != nil { return err }
+            if err := l.preMLPNorm.LoadWeights(w); err != nil { return err }
+        }
+
+        if err := loadAttention(read, l.selfAttn, wm, p, cfg.AttentionBias, H, qDim, kvDim); err != nil { return err }
+        if cfg.QKNorm {
+            qn, err := read(p+"self_attn.q_norm.weight", cfg.HeadDim)
+            if err != nil { return err }
+            kn, err := read(p+"self_attn.k_norm.weight", cfg.HeadDim)
+            if err != nil { return err }
+            if err := l.selfAttn.SetQKNormWeights(qn, kn); err != nil { return err }
+        }
+
+        switch mlp := l.mlp.(type) {
+        case *layers.MLP:
+            err = loadMLP(read, mlp, prefixed(p, wm.GateUp), p+wm.Gate, p+wm.Up, p+wm.Down, H, I)
+        case *layers.MoE:
+            err = loadMoE(read, mlp, cfg, wm, p)
+        }
+        if err != nil { return err }
+    }
+
+    if w, err = read("model.norm.weight", H); err != nil { return err }
+    if err := m.norm.LoadWeights(w); err != nil { return err }
+
+    // Tied checkpoints (tie_word_embeddings) compute logits from the
+    // embedding matrix and need no lm_head
+    if m.lmHead == nil { return nil }
+    if _, _, ok := st.Find("lm_head.weight"); !ok {
+        return fmt.Errorf("lm_head.weight not found and tie_word_embeddings is false")
+    }
+    if w, err = read("lm_head.weight", cfg.VocabSize*H); err != nil { return err }
+    return m.lmHead.LoadWeights(w, nil)
+}
+
+// tensorReader reads a named tensor, checking its element count if want > 0
+type tensorReader func(name string, want int) ([]float32, error)
+
+// newTensorReader reads tensors from the safetensors shards st
+func newTensorReader(st *safetensors.Multi) tensorReader {
+    return func(name string, want int) ([]float32, error) {
+        f, _, ok := st.Find(name)
+        if !ok { return nil, fmt.Errorf("tensor %s not found", name) }
+        w, _, err := f.ReadFloat32(name)
+        if err != nil { return nil, err }
+        if want > 0 && len(w) != want {
+            return nil, fmt.Errorf("tensor %s has %d elements, want %d", name, len(w), want)
+        }
+        return w, nil
+    }
+}
+
+// loadMLP loads a gated MLP from a fused gateUp tensor, or from separate
+// gate and up tensors when gateUp is ""
+func loadMLP(read tensorReader, mlp *layers.MLP, gateUp, gate, up, down string, H, I int) error {
+    // The fused projection (SiluAndMul) expects [gate | up], which is how
+    // fused checkpoints store it already
+    var w []float32
+    var err error
+    if gateUp != "" {
+        if w, err = read(gateUp+".weight", 2*I*H); err != nil { return err }
+    } else {
+        g, err := read(gate+".weight", I*H)
+        if err != nil { return err }
+        u, err := read(up+".weight", I*H)
+        if err != nil { return err }
+        w = append(g, u...)
+    }
+    if err := mlp.SetGateUpWeights(w); err != nil { return err }
+    d, err := read(down+".weight", H*I)
+    if err != nil { return err }
+    return mlp.SetDownWeights(d)
+}
+
+// loadAttention loads the q/k/v/o projections (and q/k/v biases) of layer
+// prefix p, splitting a fused qkv tensor if wm has one
+func loadAttention(read tensorReader, attn *layers.Attention, wm weightMap, p string, bias bool, H, qDim, kvDim int) error {
+    if wm.QKV != "" {
+        w, err := read(p+wm.QKV+".weight", (qDim+2*kvDim)*H)
+        if err != nil { return err }
+        var b []float32
+        if bias {
+            if b, err = read(p+wm.QKV+".bias", qDim+2*kvDim); err != nil { return err }
+        }
+        if err := attn.SetQKVWeights(w, b); err != nil { return err }
+    } else {
+        w, err := read(p+wm.Q+".weight", qDim*H)
+        if err != nil { return err }
+        if err := attn.SetQWeights(w); err != nil { return err }
+        if w, err = read(p+wm.K+".weight", kvDim*H); err != nil { return err }
+        if err := attn.SetKWeights(w); err != nil { return err }
+        if w, err = read(p+wm.V+".weight", kvDim*H); err != nil { return err }
+        if err := attn.SetVWeights(w); err != nil { return err }
+        if bias {
+            qb, err := read(p+wm.Q+".bias", qDim)
+            if err != nil { return err }
+            kb, err := read(p+wm.K+".bias", kvDim)
+            if err != nil { return err }
+            vb, err := read(p+wm.V+".bias", kvDim)
+            if err != nil { return err }
+            if err := attn.SetQKVBias(qb, kb, vb); err != nil { return err }
+        }
+    }
+    w, err := read(p+wm.O+".weight", H*qDim)
+    if err != nil { return err }
+    return attn.SetOWeights(w)
+}
+
+// modelRopes are the rotary embeddings shared by all layers: the global
+// one, and for Gemma 3 a separate one for the sliding layers
+type modelRopes struct {
+    global, local *layers.RotaryEmbedding
+}
+
+// newModelRopes builds the model's shared rotary embeddings. Sliding layers
+// may use their own RoPE base (Gemma 3); rope_scaling applies to the global
+// ones only.
+func newModelRopes(cfg *config.Config) (modelRopes, error) {
+    var ropes modelRopes
+    var err error
+    if ropes.global, err = newRope(cfg, cfg.RoPETheta, ropeScaling(cfg)); err != nil { return ropes, err }
+    if cfg.RopeLocalTheta > 0 {
+        if ropes.local, err = newRope(cfg, cfg.RopeLocalTheta, layers.RopeScaling{}); err != nil { return ropes, err }
+    }
+    return ropes, nil
+}
+
+// newRope builds the rotary embedding for the config's head dim, partial
+// rotary factor and pairing style
+func newRope(cfg *config.Config, theta float64, scaling layers.RopeScaling) (*layers.RotaryEmbedding, error) {
+    rotaryDim := cfg.HeadDim
+    if f := cfg.PartialRotaryFactor; f > 0 && f < 1 {
+        rotaryDim = int(float64(cfg.HeadDim)*f) &^ 1 // pairs need an even dim
+    }
+    if cfg.RotaryDim > 0 && cfg.RotaryDim < cfg.HeadDim { rotaryDim = cfg.RotaryDim &^ 1 }
+    r, err := layers.NewRotaryEmbedding(cfg.HeadDim, rotaryDim, cfg.MaxPositionEmbeddings, theta, scaling)
+    if err != nil { return nil, err }
+    r.SetInterleaved(cfg.RopeInterleaved)
+    return r, nil
+}
+
+// ropeScaling converts the config's rope_scaling block
+func ropeScaling(cfg *config.Config) layers.RopeScaling {
+    return layers.RopeScaling{
+        Type:                cfg.RopeScalingType,
+        Factor:              cfg.RopeScalingFactor,
+        LowFreqFactor:       cfg.RopeLowFreqFactor,
+        HighFreqFactor:      cfg.RopeHighFreqFactor,
+        OriginalMaxPosition: cfg.RopeOriginalMaxPosition,
+        ShortFactor:         cfg.RopeShortFactor,
+        LongFactor:          cfg.RopeLongFactor,
+        AttentionFactor:     cfg.RopeAttentionFactor,
+        BetaFast:            cfg.RopeBetaFast,
+        BetaSlow:            cfg.RopeBetaSlow,
+        Mscale:              cfg.RopeMscale,
+        MscaleAllDim:        cfg.RopeMscaleAllDim,
+    }
+}
@@ -0,0 +1,11 @@
+package models
+
+// Gemma, Gemma 2 and the text-only Gemma 3 run on the generic decoder:
+// GeGLU MLP, sqrt(hidden) embedding scaling, (1+w) RMSNorm, sandwich norms,
+// soft-capping and alternating local/global attention are enabled from
+// config.json (see config.parseGemma).
+func init() {
+    Register(newDecoder,
+        "GemmaForCausalLM", "Gemma2ForCausalLM", "Gemma3ForCausalLM",
+        "gemma", "gemma2", "gemma3_text")
+}
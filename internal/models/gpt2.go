@@ -0,0 +1,230 @@
+package models
+
+import (
+    "fmt"
+
+    ggtensor "gorgonia.org/tensor"
+
+    "github.com/unixsysdev/nano-go-vllm/internal/config"
+    "github.com/unixsysdev/nano-go-vllm/internal/layers"
+    "github.com/unixsysdev/nano-go-vllm/internal/tensor"
+    "github.com/unixsysdev/nano-go-vllm/pkg/safetensors"
+)
+
+func init() {
+    Register(newGPT2, "GPT2LMHeadModel", "gpt2")
+}
+
+// GPT2Model is a GPT-2 style decoder: learned absolute positions, pre-norm
+// LayerNorm with bias, fused biased QKV, GELU MLP and Conv1D ([in, out])
+// weights, with lm_head tied to the token embeddings
+type GPT2Model struct {
+    config *config.Config
+    wte    *layers.Embedding
+    wpe    *layers.Embedding
+    layers []*GPT2Layer
+    lnF    *layers.LayerNorm
+}
+
+// GPT2Layer is a single GPT-2 block
+type GPT2Layer struct {
+    ln1  *layers.LayerNorm
+    attn *layers.Attention
+    ln2  *layers.LayerNorm
+    mlp  *layers.FFN
+}
+
+// newGPT2 adapts NewGPT2Model to the registry
+func newGPT2(cfg *config.Config) (CausalLM, error) {
+    m, err := NewGPT2Model(cfg)
+    if err != nil { return nil, err }
+    return m, nil
+}
+
+// NewGPT2Model builds the model from config and loads weights from cfg.ModelPath
+func NewGPT2Model(cfg *config.Config) (*GPT2Model, error) {
+    H := cfg.HiddenSize
+    eps := float32(cfg.LayerNormEps)
+    m := &GPT2Model{config: cfg}
+    var err error
+    if m.wte, err = layers.NewEmbedding(cfg.VocabSize, H); err != nil { return nil, fmt.Errorf("token embedding: %v", err) }
+    if m.wpe, err = layers.NewEmbedding(cfg.MaxPositionEmbeddings, H); err != nil { return nil, fmt.Errorf("position embedding: %v", err) }
+    for i := 0; i < cfg.NumHiddenLayers; i++ {
+        l := &GPT2Layer{}
+        if l.ln1, err = layers.NewLayerNorm(H, eps); err != nil { return nil, fmt.Errorf("layer %d ln_1: %v", i, err) }
+        if l.ln2, err = layers.NewLayerNorm(H, eps); err != nil { return nil, fmt.Errorf("layer %d ln_2: %v", i, err) }
+        // learned absolute positions, no RoPE
+        if l.attn, err = layers.NewAttention(H, cfg.NumAttentionHeads, cfg.NumKeyValueHeads, cfg.HeadDim, nil, true); err != nil {
+            return nil, fmt.Errorf("layer %d attention: %v", i, err)
+        }
+        if l.mlp, err = layers.NewFFN(H, cfg.IntermediateSize, cfg.HiddenAct, true); err != nil {
+            return nil, fmt.Errorf("layer %d mlp: %v", i, err)
+        }
+        m.layers = append(m.layers, l)
+    }
+    if m.lnF, err = layers.NewLayerNorm(H, eps); err != nil { return nil, fmt.Errorf("ln_f: %v", err) }
+    if err := m.loadWeights(cfg.ModelPath); err != nil {
+        return nil, fmt.Errorf("load weights: %v", err)
+    }
+    return m, nil
+}
+
+// Config returns the model configuration
+func (m *GPT2Model) Config() *config.Config { return m.config }
+
+// ResetKVCache clears the active KV cache of every layer
+func (m *GPT2Model) ResetKVCache() {
+    for _, l := range m.layers { l.attn.ResetCache() }
+}
+
+// UseKVCache switches every layer to the KV cache of sequence id
+func (m *GPT2Model) UseKVCache(id int) {
+    for _, l := range m.layers { l.attn.UseCache(id) }
+}
+
+// FreeKVCache releases the KV cache of sequence id
+func (m *GPT2Model) FreeKVCache(id int) {
+    for _, l := range m.layers { l.attn.FreeCache(id) }
+}
+
+// Forward runs the model on inputIDs [T] at positions [T] and returns the
+// logits [len(rows), vocab] of the selected rows (nil: the last one)
+func (m *GPT2Model) Forward(inputIDs, positions *tensor.Tensor, rows []int) (*tensor.Tensor, error) {
+    logits, _, err := m.ForwardHidden(inputIDs, positions, rows)
+    return logits, err
+}
+
+// ForwardHidden is Forward that also returns the hidden states after ln_f
+func (m *GPT2Model) ForwardHidden(inputIDs, positions *tensor.Tensor, rows []int) (*tensor.Tensor, *tensor.Tensor, error) {
+    hidden, err := m.embed(inputIDs, positions)
+    if err != nil { return nil, nil, err }
+    for i, l := range m.layers {
+        if hidden, err = l.forward(hidden, func(x *tensor.Tensor) (*tensor.Tensor, error) {
+            return l.attn.Forward(x, positions)
+        }); err != nil {
+            return nil, nil, fmt.Errorf("layer %d: %v", i, err)
+        }
+    }
+    normed, err := m.lnF.Forward(hidden)
+    if err != nil { return nil, nil, fmt.Errorf("ln_f: %v", err) }
+    selected, err := selectRows(normed, rows)
+    if err != nil { return nil, nil, err }
+    logits, err := m.wte.Attend(selected)
+    if err != nil { return nil, nil, fmt.Errorf("lm head: %v", err) }
+    return logits, normed, nil
+}
+
+// Lookahead returns the hidden states [K, hidden] each candidate would
+// produce as the next token, leaving the KV cache unchanged
+func (m *GPT2Model) Lookahead(candidates []int) (*tensor.Tensor, error) {
+    K := len(candidates)
+    ids, err := tensor.NewTensor([]int{K}, tensor.Int64, tensor.CPU)
+    if err != nil { return nil, err }
+    pos, err := tensor.NewTensor([]int{K}, tensor.Int64, tensor.CPU)
+    if err != nil { return nil, err }
+    p := 0
+    if len(m.layers) > 0 { p = m.layers[0].attn.CacheLen() }
+    idDense := ids.Data().(*ggtensor.Dense)
+    posDense := pos.Data().(*ggtensor.Dense)
+    for i, id := range candidates {
+        idDense.Set(i, int64(id))
+        posDense.Set(i, int64(p))
+    }
+    hidden, err := m.embed(ids, pos)
+    if err != nil { return nil, err }
+    for i, l := range m.layers {
+        if hidden, err = l.forward(hidden, l.attn.ForwardCandidates); err != nil {
+            return nil, fmt.Errorf("layer %d: %v", i, err)
+        }
+    }
+    return m.lnF.Forward(hidden)
+}
+
+// embed returns wte[ids] + wpe[positions]
+func (m *GPT2Model) embed(inputIDs, positions *tensor.Tensor) (*tensor.Tensor, error) {
+    tok, err := m.wte.Forward(inputIDs)
+    if err != nil { return nil, fmt.Errorf("token embedding: %v", err) }
+    pos, err := m.wpe.Forward(positions)
+    if err != nil { return nil, fmt.Errorf("position embedding: %v", err) }
+    return residualAdd(tok, pos)
+}
+
+// forward is the pre-norm block with a pluggable attention call
+func (l *GPT2Layer) forward(hidden *tensor.Tensor, attn func(*tensor.Tensor) (*tensor.Tensor, error)) (*tensor.Tensor, error) {
+    normed, err := l.ln1.Forward(hidden)
+    if err != nil { return nil, err }
+    attnOut, err := attn(normed)
+    if err != nil { return nil, err }
+    h, err := residualAdd(hidden, attnOut)
+    if err != nil { return nil, err }
+    if normed, err = l.ln2.Forward(h); err != nil { return nil, err }
+    mlpOut, err := l.mlp.Forward(normed)
+    if err != nil { return nil, err }
+    return residualAdd(h, mlpOut)
+}
+
+// loadWeights reads GPT-2 tensors (with or without the "transformer."
+// prefix) and transposes the Conv1D weights into Linear layout
+func (m *GPT2Model) loadWeights(dir string) error {
+    st, err := safetensors.OpenDir(dir)
+    if err != nil { return err }
+    prefix := ""
+    if _, _, ok := st.Find("transformer.wte.weight"); ok { prefix = "transformer." }
+    read := tensorReader(func(name string, want int) ([]float32, error) {
+        f, _, ok := st.Find(prefix + name)
+        if !ok { return nil, fmt.Errorf("tensor %s%s not found", prefix, name) }
+        w, _, err := f.ReadFloat32(prefix + name)
+        if err != nil { return nil, err }
+        if want > 0 && len(w) != want {
+            return nil, fmt.Errorf("tensor %s%s has %d elements, want %d", prefix, name, len(w), want)
+        }
+        return w, nil
+    })
+    cfg := m.config
+    H, I := cfg.HiddenSize, cfg.IntermediateSize
+    qkv := (cfg.NumAttentionHeads + 2*cfg.NumKeyValueHeads) * cfg.HeadDim
+
+    w, err := read("wte.weight", cfg.VocabSize*H)
+    if err != nil { return err }
+    if err := m.wte.LoadWeights(w); err != nil { return err }
+    if w, err = read("wpe.weight", cfg.MaxPositionEmbeddings*H); err != nil { return err }
+    if err := m.wpe.LoadWeights(w); err != nil { return err }
+
+    for i, l := range m.layers {
+        p := fmt.Sprintf("h.%d.", i)
+        if err := loadLayerNorm(read, l.ln1, p+"ln_1", H); err != nil { return err }
+        if err := loadLayerNorm(read, l.ln2, p+"ln_2", H); err != nil { return err }
+
+        w, b, err := readConv1D(read, p+"attn.c_attn", H, qkv)
+        if err != nil { return err }
+        if err := l.attn.SetQKVWeights(w, b); err != nil { return err }
+        if w, b, err = readConv1D(read, p+"attn.c_proj", H, H); err != nil { return err }
+        if err := l.attn.SetOWeights(w); err != nil { return err }
+        if err := l.attn.SetOBias(b); err != nil { return err }
+
+        if w, b, err = readConv1D(read, p+"mlp.c_fc", H, I); err != nil { return err }
+        if err := l.mlp.SetFCWeights(w, b); err != nil { return err }
+        if w, b, err = readConv1D(read, p+"mlp.c_proj", I, H); err != nil { return err }
+        if err := l.mlp.SetProjWeights(w, b); err != nil { return err }
+    }
+    return loadLayerNorm(read, m.lnF, "ln_f", H)
+}
+
+// readConv1D reads a Conv1D weight [in, out] as a Linear weight [out, in]
+// together with its bias [out]
+func readConv1D(read tensorReader, name string, in, out int) ([]float32, []float32, error) {
+    w, err := read(name+".weight", in*out)
+    if err != nil { return nil, nil, err }
+    b, err := read(name+".bias", out)
+    if err != nil { return nil, nil, err }
+    return layers.TransposeConv1D(w, in, out), b, nil
+}
+
+// loadLayerNorm loads name.weight and name.bias
+func loadLayerNorm(read tensorReader, n *layers.LayerNorm, name string, H int) error {
+    w, err := read(name+".weight", H)
+    if err != nil { return err }
+    b, err := read(name+".bias", H)
+    if err != nil { return err }
+    return n.LoadWeights(w, b)
+}
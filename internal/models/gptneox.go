@@ -0,0 +1,200 @@
+package models
+
+import (
+    "fmt"
+
+    ggtensor "gorgonia.org/tensor"
+
+    "github.com/unixsysdev/nano-go-vllm/internal/config"
+    "github.com/unixsysdev/nano-go-vllm/internal/layers"
+    "github.com/unixsysdev/nano-go-vllm/internal/tensor"
+    "github.com/unixsysdev/nano-go-vllm/pkg/safetensors"
+)
+
+func init() {
+    Register(newGPTNeoX, "GPTNeoXForCausalLM", "gpt_neox")
+}
+
+// GPTNeoXModel is a GPT-NeoX / Pythia decoder: LayerNorm with bias, a fused
+// biased QKV packed per head, partial rotate-half RoPE (rotary_pct), a GELU
+// MLP and, by default, the parallel residual x + attn(ln1(x)) + mlp(ln2(x))
+type GPTNeoXModel struct {
+    config    *config.Config
+    embedIn   *layers.Embedding
+    layers    []*GPTNeoXLayer
+    finalNorm *layers.LayerNorm
+    embedOut  *layers.Linear // nil when tied to embedIn
+}
+
+// GPTNeoXLayer is a single GPT-NeoX block
+type GPTNeoXLayer struct {
+    ln1      *layers.LayerNorm
+    attn     *layers.Attention
+    ln2      *layers.LayerNorm
+    mlp      *layers.FFN
+    parallel bool
+}
+
+// newGPTNeoX adapts NewGPTNeoXModel to the registry
+func newGPTNeoX(cfg *config.Config) (CausalLM, error) {
+    m, err := NewGPTNeoXModel(cfg)
+    if err != nil { return nil, err }
+    return m, nil
+}
+
+// NewGPTNeoXModel builds the model from config and loads weights from cfg.ModelPath
+func NewGPTNeoXModel(cfg *config.Config) (*GPTNeoXModel, error) {
+    H := cfg.HiddenSize
+    eps := float32(cfg.LayerNormEps)
+    m := &GPTNeoXModel{config: cfg}
+    var err error
+    if m.embedIn, err = layers.NewEmbedding(cfg.VocabSize, H); err != nil { return nil, fmt.Errorf("token embedding: %v", err) }
+    ropes, err := newModelRopes(cfg)
+    if err != nil { return nil, fmt.Errorf("rotary embedding: %v", err) }
+    for i := 0; i < cfg.NumHiddenLayers; i++ {
+        l := &GPTNeoXLayer{parallel: cfg.ParallelResidual}
+        if l.ln1, err = layers.NewLayerNorm(H, eps); err != nil { return nil, fmt.Errorf("layer %d input norm: %v", i, err) }
+        if l.ln2, err = layers.NewLayerNorm(H, eps); err != nil { return nil, fmt.Errorf("layer %d post norm: %v", i, err) }
+        if l.attn, err = layers.NewAttention(H, cfg.NumAttentionHeads, cfg.NumKeyValueHeads, cfg.HeadDim, ropes.global, true); err != nil {
+            return nil, fmt.Errorf("layer %d attention: %v", i, err)
+        }
+        if l.mlp, err = layers.NewFFN(H, cfg.IntermediateSize, cfg.HiddenAct, true); err != nil {
+            return nil, fmt.Errorf("layer %d mlp: %v", i, err)
+        }
+        m.layers = append(m.layers, l)
+    }
+    if m.finalNorm, err = layers.NewLayerNorm(H, eps); err != nil { return nil, fmt.Errorf("final norm: %v", err) }
+    if !cfg.TieWordEmbeddings {
+        if m.embedOut, err = layers.NewLinear(H, cfg.VocabSize, false); err != nil { return nil, fmt.Errorf("lm head: %v", err) }
+    }
+    if err := m.loadWeights(cfg.ModelPath); err != nil {
+        return nil, fmt.Errorf("load weights: %v", err)
+    }
+    return m, nil
+}
+
+// Config returns the model configuration
+func (m *GPTNeoXModel) Config() *config.Config { return m.config }
+
+// ResetKVCache clears the active KV cache of every layer
+func (m *GPTNeoXModel) ResetKVCache() {
+    for _, l := range m.layers { l.attn.ResetCache() }
+}
+
+// UseKVCache switches every layer to the KV cache of sequence id
+func (m *GPTNeoXModel) UseKVCache(id int) {
+    for _, l := range m.layers { l.attn.UseCache(id) }
+}
+
+// FreeKVCache releases the KV cache of sequence id
+func (m *GPTNeoXModel) FreeKVCache(id int) {
+    for _, l := range m.layers { l.attn.FreeCache(id) }
+}
+
+// Forward runs the model on inputIDs [T] at positions [T] and returns the
+// logits [len(rows), vocab] of the selected rows (nil: the last one)
+func (m *GPTNeoXModel) Forward(inputIDs, positions *tensor.Tensor, rows []int) (*tensor.Tensor, error) {
+    logits, _, err := m.ForwardHidden(inputIDs, positions, rows)
+    return logits, err
+}
+
+// ForwardHidden is Forward that also returns the hidden states after the final norm
+func (m *GPTNeoXModel) ForwardHidden(inputIDs, positions *tensor.Tensor, rows []int) (*tensor.Tensor, *tensor.Tensor, error) {
+    hidden, err := m.embedIn.Forward(inputIDs)
+    if err != nil { return nil, nil, fmt.Errorf("embedding: %v", err) }
+    for i, l := range m.layers {
+        if hidden, err = l.forward(hidden, func(x *tensor.Tensor) (*tensor.Tensor, error) {
+            return l.attn.Forward(x, positions)
+        }); err != nil {
+            return nil, nil, fmt.Errorf("layer %d: %v", i, err)
+        }
+    }
+    normed, err := m.finalNorm.Forward(hidden)
+    if err != nil { return nil, nil, fmt.Errorf("final norm: %v", err) }
+    selected, err := selectRows(normed, rows)
+    if err != nil { return nil, nil, err }
+    logits, err := headLogits(m.embedOut, m.embedIn, selected)
+    if err != nil { return nil, nil, fmt.Errorf("lm head: %v", err) }
+    return logits, normed, nil
+}
+
+// Lookahead returns the hidden states [K, hidden] each candidate would
+// produce as the next token, leaving the KV cache unchanged
+func (m *GPTNeoXModel) Lookahead(candidates []int) (*tensor.Tensor, error) {
+    ids, err := tensor.NewTensor([]int{len(candidates)}, tensor.Int64, tensor.CPU)
+    if err != nil { return nil, err }
+    dense := ids.Data().(*ggtensor.Dense)
+    for i, id := range candidates { dense.Set(i, int64(id)) }
+    hidden, err := m.embedIn.Forward(ids)
+    if err != nil { return nil, fmt.Errorf("embedding: %v", err) }
+    for i, l := range m.layers {
+        if hidden, err = l.forward(hidden, l.attn.ForwardCandidates); err != nil {
+            return nil, fmt.Errorf("layer %d: %v", i, err)
+        }
+    }
+    return m.finalNorm.Forward(hidden)
+}
+
+// forward is the pre-norm block with a pluggable attention call: parallel
+// (both branches read x) or sequential like GPT-2
+func (l *GPTNeoXLayer) forward(hidden *tensor.Tensor, attn func(*tensor.Tensor) (*tensor.Tensor, error)) (*tensor.Tensor, error) {
+    normed, err := l.ln1.Forward(hidden)
+    if err != nil { return nil, err }
+    attnOut, err := attn(normed)
+    if err != nil { return nil, err }
+    h, err := residualAdd(hidden, attnOut)
+    if err != nil { return nil, err }
+    mlpIn := h
+    if l.parallel { mlpIn = hidden }
+    if normed, err = l.ln2.Forward(mlpIn); err != nil { return nil, err }
+    mlpOut, err := l.mlp.Forward(normed)
+    if err != nil { return nil, err }
+    return residualAdd(h, mlpOut)
+}
+
+// loadWeights reads the gpt_neox.* tensors; query_key_value is packed per
+// head and is split with SplitQKVPerHead
+func (m *GPTNeoXModel) loadWeights(dir string) error {
+    st, err := safetensors.OpenDir(dir)
+    if err != nil { return err }
+    read := newTensorReader(st)
+    cfg := m.config
+    H, I := cfg.HiddenSize, cfg.IntermediateSize
+    NH, HD := cfg.NumAttentionHeads, cfg.HeadDim
+
+    w, err := read("gpt_neox.embed_in.weight", cfg.VocabSize*H)
+    if err != nil { return err }
+    if err := m.embedIn.LoadWeights(w); err != nil { return err }
+
+    for i, l := range m.layers {
+        p := fmt.Sprintf("gpt_neox.layers.%d.", i)
+        if err := loadLayerNorm(read, l.ln1, p+"input_layernorm", H); err != nil { return err }
+        if err := loadLayerNorm(read, l.ln2, p+"post_attention_layernorm", H); err != nil { return err }
+
+        if w, err = read(p+"attention.query_key_value.weight", 3*NH*HD*H); err != nil { return err }
+        q, k, v, err := layers.SplitQKVPerHead(w, NH, HD)
+        if err != nil { return err }
+        if err := l.attn.SetQWeights(q); err != nil { return err }
+        if err := l.attn.SetKWeights(k); err != nil { return err }
+        if err := l.attn.SetVWeights(v); err != nil { return err }
+        b, err := read(p+"attention.query_key_value.bias", 3*NH*HD)
+        if err != nil { return err }
+        if q, k, v, err = layers.SplitQKVPerHead(b, NH, HD); err != nil { return err }
+        if err := l.attn.SetQKVBias(q, k, v); err != nil { return err }
+        if w, err = read(p+"attention.dense.weight", H*NH*HD); err != nil { return err }
+        if err := l.attn.SetOWeights(w); err != nil { return err }
+        if b, err = read(p+"attention.dense.bias", H); err != nil { return err }
+        if err := l.attn.SetOBias(b); err != nil { return err }
+
+        if w, err = read(p+"mlp.dense_h_to_4h.weight", I*H); err != nil { return err }
+        if b, err = read(p+"mlp.dense_h_to_4h.bias", I); err != nil { return err }
+        if err := l.mlp.SetFCWeights(w, b); err != nil { return err }
+        if w, err = read(p+"mlp.dense_4h_to_h.weight", H*I); err != nil { return err }
+        if b, err = read(p+"mlp.dense_4h_to_h.bias", H); err != nil { return err }
+        if err := l.mlp.SetProjWeights(w, b); err != nil { return err }
+    }
+    if err := loadLayerNorm(read, m.finalNorm, "gpt_neox.final_layer_norm", H); err != nil { return err }
+    if m.embedOut == nil { return nil }
+    if w, err = read("embed_out.weight", cfg.VocabSize*H); err != nil { return err }
+    return m.embedOut.LoadWeights(w, nil)
+}
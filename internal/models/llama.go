@@ -0,0 +1,8 @@
+package models
+
+// Llama (incl. Llama 3 with rope_type "llama3") and Mistral (sliding-window
+// attention) use the generic decoder: same HF weight names, separate
+// gate/up projections, GQA and optional tie_word_embeddings
+func init() {
+    Register(newDecoder, "LlamaForCausalLM", "MistralForCausalLM", "llama", "mistral")
+}
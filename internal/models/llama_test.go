@@ -0,0 +1,150 @@
+package models
+
+import (
+    "encoding/json"
+    "math"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    ggtensor "gorgonia.org/tensor"
+
+    "github.com/unixsysdev/nano-go-vllm/internal/config"
+    "github.com/unixsysdev/nano-go-vllm/internal/tensor"
+)
+
+// referenceLogits is testdata/<name>/logits.json, written by gen_tiny.py;
+// Source names the transformers version, or the fallback transcription
+type referenceLogits struct {
+    InputIDs []int       `json:"input_ids"`
+    Source   string      `json:"source"`
+    Logits   [][]float64 `json:"logits"`
+}
+
+// inputTensors builds the [T] token ids and their positions start, start+1, ...
+func inputTensors(t *testing.T, ids []int, start int) (*tensor.Tensor, *tensor.Tensor) {
+    t.Helper()
+    idT, err := tensor.NewTensor([]int{len(ids)}, tensor.Int64, tensor.CPU)
+    if err != nil { t.Fatal(err) }
+    posT, err := tensor.NewTensor([]int{len(ids)}, tensor.Int64, tensor.CPU)
+    if err != nil { t.Fatal(err) }
+    idDense := idT.Data().(*ggtensor.Dense)
+    posDense := posT.Data().(*ggtensor.Dense)
+    for i, id := range ids {
+        idDense.Set(i, int64(id))
+        posDense.Set(i, int64(start+i))
+    }
+    return idT, posT
+}
+
+// checkRows compares logits [rows, vocab] with the reference rows from first on
+func checkRows(t *testing.T, logits *tensor.Tensor, want [][]float64, first int) {
+    t.Helper()
+    V := logits.Shape()[1]
+    got := logits.Data().Data().([]float32)
+    for r := 0; r < logits.Shape()[0]; r++ {
+        for v := 0; v < V; v++ {
+            if d := math.Abs(float64(got[r*V+v]) - want[first+r][v]); d > 1e-4 {
+                t.Fatalf("position %d token %d: got %g, want %g", first+r, v, got[r*V+v], want[first+r][v])
+            }
+        }
+    }
+}
+
+// fixtures are the checkpoints in testdata
+var fixtures = []string{"llama", "mistral", "qwen2", "qwen3", "gemma", "gemma2", "mixtral", "qwen2_moe",
+    "gpt2", "gpt2_bare", "gpt_neox", "gpt_neox_sequential"}
+
+// loadFixture builds the model in testdata/name and reads its reference logits
+func loadFixture(t *testing.T, name string) (CausalLM, referenceLogits) {
+    t.Helper()
+    dir := filepath.Join("testdata", name)
+    b, err := os.ReadFile(filepath.Join(dir, "logits.json"))
+    if err != nil { t.Fatal(err) }
+    var ref referenceLogits
+    if err := json.Unmarshal(b, &ref); err != nil { t.Fatal(err) }
+    cfg, err := config.LoadConfig(dir)
+    if err != nil { t.Fatal(err) }
+    m, err := New(cfg)
+    if err != nil { t.Fatal(err) }
+    return m, ref
+}
+
+// TestReferenceLogits runs tiny randomly initialized Llama (tied embeddings,
+// llama3 rope scaling, GQA), Mistral (sliding window, GQA), Qwen2 (biased
+// q/k/v), Qwen3 (q_norm/k_norm), Gemma (GeGLU, (1+w) norms, embedding
+// scale), Gemma 2 (sandwich norms, local/global layers, soft-caps), Mixtral
+// (renormalized top-2 routing), Qwen2-MoE (shared expert, dense and sparse
+// layers), GPT-2 (Conv1D weights with and without the "transformer."
+// prefix, learned positions, tied head) and GPT-NeoX (per-head fused QKV,
+// partial rotary, parallel and sequential residual) checkpoints against the
+// reference logits of gen_tiny.py
+func TestReferenceLogits(t *testing.T) {
+    for _, name := range fixtures {
+        t.Run(name, func(t *testing.T) {
+            m, ref := loadFixture(t, name)
+            if !strings.HasPrefix(ref.Source, "transformers") {
+                t.Logf("reference logits from %s; rerun gen_tiny.py with transformers installed", ref.Source)
+            }
+
+            // whole prompt at once, logits for every position
+            T := len(ref.InputIDs)
+            ids, pos := inputTensors(t, ref.InputIDs, 0)
+            logits, err := m.Forward(ids, pos, AllRows(T))
+            if err != nil { t.Fatal(err) }
+            checkRows(t, logits, ref.Logits, 0)
+
+            // prefill then decode one token at a time on the KV cache
+            m.ResetKVCache()
+            const prefill = 4
+            ids, pos = inputTensors(t, ref.InputIDs[:prefill], 0)
+            if logits, err = m.Forward(ids, pos, nil); err != nil { t.Fatal(err) }
+            checkRows(t, logits, ref.Logits, prefill-1)
+            for p := prefill; p < T; p++ {
+                ids, pos = inputTensors(t, ref.InputIDs[p:p+1], p)
+                if logits, err = m.Forward(ids, pos, nil); err != nil { t.Fatal(err) }
+                checkRows(t, logits, ref.Logits, p)
+            }
+        })
+    }
+}
+
+// TestLookahead: the hidden state Lookahead gives each candidate is the one
+// decoding that candidate next produces, and the KV cache is left as it
+// was, so decoding afterwards still matches the reference logits
+func TestLookahead(t *testing.T) {
+    const prefill = 4
+    for _, name := range fixtures {
+        t.Run(name, func(t *testing.T) {
+            m, ref := loadFixture(t, name)
+            next := ref.InputIDs[prefill]
+            candidates := []int{next, 0, next + 1}
+            ids, pos := inputTensors(t, ref.InputIDs[:prefill], 0)
+            if _, err := m.Forward(ids, pos, nil); err != nil { t.Fatal(err) }
+            lookahead, err := m.Lookahead(candidates)
+            if err != nil { t.Fatal(err) }
+            H := lookahead.Shape()[1]
+            want := lookahead.Data().Data().([]float32)
+
+            for c, id := range candidates {
+                if c > 0 {
+                    m.ResetKVCache()
+                    ids, pos = inputTensors(t, ref.InputIDs[:prefill], 0)
+                    if _, err := m.Forward(ids, pos, nil); err != nil { t.Fatal(err) }
+                }
+                ids, pos = inputTensors(t, []int{id}, prefill)
+                logits, hidden, err := m.ForwardHidden(ids, pos, nil)
+                if err != nil { t.Fatal(err) }
+                // the first decode runs on the cache Lookahead just used
+                if c == 0 { checkRows(t, logits, ref.Logits, prefill) }
+                got := hidden.Data().Data().([]float32)
+                for j := 0; j < H; j++ {
+                    if d := math.Abs(float64(got[j] - want[c*H+j])); d > 1e-4 {
+                        t.Fatalf("candidate %d dim %d: decoded %g, lookahead %g", id, j, got[j], want[c*H+j])
+                    }
+                }
+            }
+        })
+    }
+}
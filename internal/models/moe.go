@@ -0,0 +1,77 @@
+package models
+
+import (
+    "fmt"
+
+    "github.com/unixsysdev/nano-go-vllm/internal/config"
+    "github.com/unixsysdev/nano-go-vllm/internal/layers"
+)
+
+// Sparse mixture-of-experts models run on the generic decoder with a
+// layers.MoE in place of the MLP on their sparse layers
+func init() {
+    Register(decoderWith(mixtralWeights), "MixtralForCausalLM", "mixtral")
+    Register(newDecoder, "Qwen2MoeForCausalLM", "Qwen3MoeForCausalLM", "qwen2_moe", "qwen3_moe")
+}
+
+// isSparseLayer reports whether layer i uses experts
+func isSparseLayer(cfg *config.Config, i int) bool {
+    if cfg.NumExperts == 0 { return false }
+    for _, d := range cfg.MLPOnlyLayers {
+        if d == i { return false }
+    }
+    step := cfg.DecoderSparseStep
+    if step <= 0 { step = 1 }
+    return (i+1)%step == 0
+}
+
+// newMoE creates a layer's expert block from config
+func newMoE(cfg *config.Config) (*layers.MoE, error) {
+    moe, err := layers.NewMoE(cfg.HiddenSize, cfg.MoEIntermediateSize, cfg.NumExperts, cfg.NumExpertsPerTok,
+        cfg.NormTopKProb, cfg.HiddenAct)
+    if err != nil { return nil, err }
+    if cfg.SharedExpertIntermediateSize > 0 {
+        if err := moe.SetSharedExpert(cfg.HiddenSize, cfg.SharedExpertIntermediateSize, cfg.HiddenAct); err != nil {
+            return nil, err
+        }
+    }
+    return moe, nil
+}
+
+// loadMoE loads router, experts and shared expert of layer prefix p:
+// <MoE>.gate, <MoE>.experts.N.<expert projections>, and for Qwen MoE
+// <MoE>.shared_expert.* and <MoE>.shared_expert_gate
+func loadMoE(read tensorReader, moe *layers.MoE, cfg *config.Config, wm weightMap, p string) error {
+    H, I := cfg.HiddenSize, cfg.MoEIntermediateSize
+    E := moe.NumExperts()
+    prefix := p + wm.MoE + "."
+    w, err := read(prefix+"gate.weight", E*H)
+    if err != nil { return err }
+    if err := moe.SetRouterWeights(w); err != nil { return err }
+    for e := 0; e < E; e++ {
+        ep := fmt.Sprintf("%sexperts.%d.", prefix, e)
+        if err := loadMLP(read, moe.Expert(e), "", ep+wm.ExpertGate, ep+wm.ExpertUp, ep+wm.ExpertDown, H, I); err != nil {
+            return err
+        }
+    }
+    if shared := moe.SharedExpert(); shared != nil {
+        S := cfg.SharedExpertIntermediateSize
+        sp := prefix + "shared_expert."
+        if err := loadMLP(read, shared, "", sp+"gate_proj", sp+"up_proj", sp+"down_proj", H, S); err != nil {
+            return err
+        }
+        if w, err = read(prefix+"shared_expert_gate.weight", H); err != nil { return err }
+        if err := moe.SetSharedGateWeights(w); err != nil { return err }
+    }
+    return nil
+}
+
+// ExpertLoad returns, per layer, how many tokens each expert has processed
+// (nil entries for dense layers)
+func (m *DecoderModel) ExpertLoad() [][]int64 {
+    load := make([][]int64, len(m.layers))
+    for i, l := range m.layers {
+        if moe, ok := l.mlp.(*layers.MoE); ok { load[i] = moe.Load() }
+    }
+    return load
+}
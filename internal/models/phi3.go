@@ -0,0 +1,7 @@
+package models
+
+// Phi-3 runs on the generic decoder with fused qkv_proj / gate_up_proj
+// tensors; its longrope rope_scaling is handled by layers.RotaryEmbedding
+func init() {
+    Register(decoderWith(phi3Weights), "Phi3ForCausalLM", "phi3")
+}
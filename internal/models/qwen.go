@@ -0,0 +1,6 @@
+package models
+
+// Qwen2 / Qwen3 checkpoints use the generic decoder
+func init() {
+    Register(newDecoder, "Qwen2ForCausalLM", "Qwen3ForCausalLM", "qwen2", "qwen3")
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+
+    "github.com/unixsysdev/nano-go-vllm/internal/config"
+    "github.com/unixsysdev/nano-go-vllm/internal/tensor"
+)
+
+// CausalLM is a decoder-only language model the engine can run
+type CausalLM interface {
+    // Forward runs inputIDs [T] at positions [T] on the active KV cache and
+    // returns logits [len(rows), vocab] for the given input rows only; nil
+    // rows means just the last one, AllRows(T) every position
+    Forward(inputIDs, positions *tensor.Tensor, rows []int) (*tensor.Tensor, error)
+    // ForwardHidden also returns the last-layer hidden states of every
+    // position [T, hidden]
+    ForwardHidden(inputIDs, positions *tensor.Tensor, rows []int) (*tensor.Tensor, *tensor.Tensor, error)
+    // Lookahead returns the hidden state each candidate would produce as
+    // the next token without extending the KV cache
+    Lookahead(candidates []int) (*tensor.Tensor, error)
+
+    // ResetKVCache clears the active KV cache
+    ResetKVCache()
+    // UseKVCache switches to the KV cache of sequence id
+    UseKVCache(id int)
+    // FreeKVCache releases the KV cache of sequence id
+    FreeKVCache(id int)
+
+    // Config returns the model configuration
+    Config() *config.Config
+}
+
+// AllRows selects the logits of all T positions (prompt logprobs)
+func AllRows(T int) []int {
+    rows := make([]int, T)
+    for i := range rows { rows[i] = i }
+    return rows
+}
+
+// ExpertLoadReporter is implemented by models that can have
+// mixture-of-experts layers; ExpertLoad returns per-layer token counts per
+// expert (nil for dense layers)
+type ExpertLoadReporter interface {
+    ExpertLoad() [][]int64
+}
+
+// Constructor builds a model and loads its weights from cfg.ModelPath
+type Constructor func(cfg *config.Config) (CausalLM, error)
+
+var registry = map[string]Constructor{}
+
+// Register makes a model family available under the given names, which are
+// matched against config.json's "architectures" and then "model_type"
+func Register(c Constructor, names ...string) {
+    for _, n := range names { registry[n] = c }
+}
+
+// New builds the model described by cfg
+func New(cfg *config.Config) (CausalLM, error) {
+    for _, a := range cfg.Architectures {
+        if c, ok := registry[a]; ok { return c(cfg) }
+    }
+    if c, ok := registry[cfg.ModelType]; ok { return c(cfg) }
+    arch := strings.Join(cfg.Architectures, ", ")
+    if arch == "" { arch = cfg.ModelType }
+    if arch == "" { arch = "unknown (config.json has no architectures or model_type)" }
+    return nil, fmt.Errorf("unsupported model architecture %s; supported: %s", arch, strings.Join(Supported(), ", "))
+}
+
+// Supported lists the registered architecture and model type names
+func Supported() []string {
+    names := make([]string, 0, len(registry))
+    for n := range registry { names = append(names, n) }
+    sort.Strings(names)
+    return names
+}
@@ -0,0 +1,535 @@
+"""Generates the tiny random Llama/Mistral/Mixtral/Qwen/Gemma/GPT-2/GPT-NeoX checkpoints in this
+directory and their reference logits.
+
+The logits come from transformers (AutoModelForCausalLM in float32 with
+eager attention, i.e. LlamaForCausalLM, MistralForCausalLM,
+MixtralForCausalLM, Qwen2ForCausalLM, Qwen3ForCausalLM, Qwen2MoeForCausalLM,
+GemmaForCausalLM, Gemma2ForCausalLM, GPT2LMHeadModel and GPTNeoXForCausalLM loading these exact safetensors) when torch and transformers are installed; logits.json
+records the version under "source".
+Without them the script falls back to a plain-Python transcription of the HF
+forward passes and marks the logits as such. With both available it also
+prints how far the transcription is from transformers.
+
+    python3 gen_tiny.py [--require-transformers]
+"""
+import json, math, os, random, struct, sys
+
+V, H, L, NH, NKV, HD, I = 32, 16, 2, 2, 1, 8, 32
+IDS = [3, 17, 9, 1, 28, 12, 5]
+
+FIXTURES = {
+    # Llama 3.2 style: tied embeddings, llama3 rope_scaling
+    "llama": dict(seed=1, tie=True, window=0, config={
+        "architectures": ["LlamaForCausalLM"], "model_type": "llama",
+        "rope_theta": 100.0, "max_position_embeddings": 128,
+        "rope_scaling": {"rope_type": "llama3", "factor": 4.0, "low_freq_factor": 1.0,
+                         "high_freq_factor": 4.0, "original_max_position_embeddings": 32},
+        "tie_word_embeddings": True}),
+    # Mistral: separate lm_head, sliding window attention
+    "mistral": dict(seed=2, tie=False, window=3, config={
+        "architectures": ["MistralForCausalLM"], "model_type": "mistral",
+        "rope_theta": 10000.0, "max_position_embeddings": 64, "sliding_window": 3,
+        "tie_word_embeddings": False}),
+    # Qwen2: biased q/k/v projections, tied embeddings
+    "qwen2": dict(seed=5, tie=True, window=0, bias=True, config={
+        "architectures": ["Qwen2ForCausalLM"], "model_type": "qwen2",
+        "rope_theta": 10000.0, "max_position_embeddings": 64, "use_sliding_window": False,
+        "tie_word_embeddings": True}),
+    # Qwen3: per-head q_norm/k_norm, no biases
+    "qwen3": dict(seed=6, tie=False, window=0, qk_norm=True, config={
+        "architectures": ["Qwen3ForCausalLM"], "model_type": "qwen3",
+        "rope_theta": 10000.0, "max_position_embeddings": 64, "attention_bias": False,
+        "tie_word_embeddings": False}),
+    # Gemma: GeGLU (tanh), (1 + w) RMSNorm, sqrt(hidden) embedding scale
+    "gemma": dict(seed=7, tie=True, window=0, gemma=1, config={
+        "architectures": ["GemmaForCausalLM"], "model_type": "gemma",
+        "rope_theta": 10000.0, "max_position_embeddings": 64,
+        "hidden_activation": "gelu_pytorch_tanh", "tie_word_embeddings": True}),
+    # Gemma 2: sandwich norms, sliding/global layers, query_pre_attn_scalar
+    # and both soft-caps (small enough to bite)
+    "gemma2": dict(seed=8, tie=True, window=3, gemma=2, config={
+        "architectures": ["Gemma2ForCausalLM"], "model_type": "gemma2",
+        "rope_theta": 10000.0, "max_position_embeddings": 64, "sliding_window": 3,
+        "hidden_activation": "gelu_pytorch_tanh", "query_pre_attn_scalar": 12,
+        "attn_logit_softcapping": 2.0, "final_logit_softcapping": 3.0,
+        "tie_word_embeddings": True}),
+    # Mixtral: every MLP is top-2 of 4 experts, renormalized
+    "mixtral": dict(seed=9, tie=False, window=0, moe=("block_sparse_moe", "w1", "w3", "w2"), config={
+        "architectures": ["MixtralForCausalLM"], "model_type": "mixtral",
+        "rope_theta": 10000.0, "max_position_embeddings": 64,
+        "num_local_experts": 4, "num_experts_per_tok": 2, "tie_word_embeddings": False}),
+    # Qwen2-MoE: unnormalized top-2 of 4 small experts plus a sigmoid-gated
+    # shared expert; with step 2 and layer 3 dense-only, layer 1 is the
+    # only sparse one
+    "qwen2_moe": dict(seed=10, tie=False, window=0, bias=True, layers=4,
+                      moe=("mlp", "gate_proj", "up_proj", "down_proj"), config={
+        "architectures": ["Qwen2MoeForCausalLM"], "model_type": "qwen2_moe",
+        "rope_theta": 10000.0, "max_position_embeddings": 64, "use_sliding_window": False,
+        "num_experts": 4, "num_experts_per_tok": 2, "moe_intermediate_size": 8,
+        "shared_expert_intermediate_size": 16, "norm_topk_prob": False,
+        "decoder_sparse_step": 2, "mlp_only_layers": [3], "tie_word_embeddings": False}),
+}
+
+
+GPT2 = {
+    # save_pretrained layout: "transformer." prefix, n_inner null (4 * n_embd)
+    "gpt2": dict(seed=11, prefix="transformer.", act="gelu_new", inner=None),
+    # original hub layout: bare names, explicit n_inner, exact GELU
+    "gpt2_bare": dict(seed=12, prefix="", act="gelu", inner=24),
+}
+
+
+NEOX = {
+    # Pythia style: parallel residual, half of head_dim rotated
+    "gpt_neox": dict(seed=3, parallel=True),
+    "gpt_neox_sequential": dict(seed=4, parallel=False),
+}
+
+
+def write_checkpoint(name, T, cfg):
+    os.makedirs(name, exist_ok=True)
+    # transformers refuses safetensors without a format in the metadata
+    hdr, data = {"__metadata__": {"format": "pt"}}, b""
+    for k, (v, s) in T.items():
+        b = struct.pack("<%df" % len(v), *v)
+        hdr[k] = {"dtype": "F32", "shape": s, "data_offsets": [len(data), len(data) + len(b)]}
+        data += b
+    h = json.dumps(hdr).encode()
+    with open(os.path.join(name, "model.safetensors"), "wb") as f:
+        f.write(struct.pack("<Q", len(h)) + h + data)
+    with open(os.path.join(name, "config.json"), "w") as f:
+        json.dump(cfg, f, indent=1)
+
+
+def hf_logits(name):
+    """Logits of the checkpoint in name from transformers, with the version,
+    or None when torch or transformers is not installed."""
+    try:
+        import torch
+        import transformers
+    except ImportError:
+        return None
+    model = transformers.AutoModelForCausalLM.from_pretrained(
+        name, torch_dtype=torch.float32, attn_implementation="eager")
+    model.eval()
+    with torch.no_grad():
+        logits = model(torch.tensor([IDS])).logits[0]
+    return logits.tolist(), "transformers " + transformers.__version__
+
+
+def write_logits(name, logits):
+    """Writes the reference logits: transformers' when available, else the
+    transcription's."""
+    source = "python transcription (transformers not installed)"
+    hf = hf_logits(name)
+    if hf is not None:
+        ref, source = hf
+        diff = max(abs(a - b) for ra, rb in zip(ref, logits) for a, b in zip(ra, rb))
+        print(f"{name}: transcription differs from {source} by {diff:.2e}")
+        logits = ref
+    elif "--require-transformers" in sys.argv:
+        sys.exit("gen_tiny.py: transformers is not installed")
+    else:
+        print(f"{name}: {source}")
+    with open(os.path.join(name, "logits.json"), "w") as f:
+        json.dump({"input_ids": IDS, "source": source, "logits": logits}, f)
+
+
+def rand(*shape, scale=0.3):
+    n = 1
+    for s in shape:
+        n *= s
+    return [random.gauss(0, scale) for _ in range(n)], list(shape)
+
+
+def matvec(x, w, rows, cols, bias=None):
+    return [sum(x[k] * w[o * cols + k] for k in range(cols)) + (bias[o] if bias else 0) for o in range(rows)]
+
+
+def rotate_half(v, pos, inv, dim):
+    """RoPE on the first dim entries of v, pairing (i, i + dim/2)"""
+    out = list(v)
+    for i, f in enumerate(inv):
+        c, s = math.cos(pos * f), math.sin(pos * f)
+        a, b = v[i], v[i + dim // 2]
+        out[i] = a * c - b * s
+        out[i + dim // 2] = b * c + a * s
+    return out
+
+
+def attend(qs, ks, vs, nh, nkv, window=0):
+    """causal softmax attention over per-position q [nh*HD], k/v [nkv*HD]"""
+    outs = []
+    group = nh // nkv
+    for t in range(len(qs)):
+        o = []
+        keys = range(max(t - window + 1, 0) if window else 0, t + 1)
+        for h in range(nh):
+            kv = h // group
+            q = qs[t][h * HD:(h + 1) * HD]
+            sc = [sum(q[d] * ks[s][kv * HD + d] for d in range(HD)) / math.sqrt(HD) for s in keys]
+            mx = max(sc)
+            e = [math.exp(a - mx) for a in sc]
+            z = sum(e)
+            o += [sum(e[j] / z * vs[s][kv * HD + d] for j, s in enumerate(keys)) for d in range(HD)]
+        outs.append(o)
+    return outs
+
+
+def generate_gpt2(name, seed, prefix, act, inner):
+    random.seed(seed)
+    NHX, FF, P = 2, inner or 4 * H, 16  # heads, MLP width, n_positions
+    T = {}
+
+    def ln_params(p):
+        T[p + ".weight"] = [1 + random.gauss(0, 0.1) for _ in range(H)], [H]
+        T[p + ".bias"] = rand(H, scale=0.1)
+
+    def conv1d(p, n_in, n_out):
+        # Conv1D stores [in, out], the transpose of nn.Linear
+        T[p + ".weight"] = rand(n_in, n_out)
+        T[p + ".bias"] = rand(n_out, scale=0.1)
+
+    T[prefix + "wte.weight"] = rand(V, H)
+    T[prefix + "wpe.weight"] = rand(P, H)
+    for l in range(L):
+        p = f"{prefix}h.{l}."
+        ln_params(p + "ln_1")
+        conv1d(p + "attn.c_attn", H, 3 * H)
+        conv1d(p + "attn.c_proj", H, H)
+        ln_params(p + "ln_2")
+        conv1d(p + "mlp.c_fc", H, FF)
+        conv1d(p + "mlp.c_proj", FF, H)
+    ln_params(prefix + "ln_f")
+    write_checkpoint(name, T, {
+        "architectures": ["GPT2LMHeadModel"], "model_type": "gpt2",
+        "vocab_size": V, "n_embd": H, "n_layer": L, "n_head": NHX, "n_inner": inner,
+        "n_positions": P, "activation_function": act, "layer_norm_epsilon": 1e-5,
+        "bos_token_id": 0, "eos_token_id": 0})
+
+    W = {k: v for k, (v, s) in T.items()}
+
+    def ln(x, p):
+        m = sum(x) / len(x)
+        inv = 1 / math.sqrt(sum((a - m) ** 2 for a in x) / len(x) + 1e-5)
+        return [(a - m) * inv * w + b for a, w, b in zip(x, W[p + ".weight"], W[p + ".bias"])]
+
+    def conv(x, p, n_in, n_out):
+        w = W[p + ".weight"]
+        return [sum(x[k] * w[k * n_out + o] for k in range(n_in)) + W[p + ".bias"][o] for o in range(n_out)]
+
+    def gelu(v):
+        if act == "gelu_new":
+            return 0.5 * v * (1 + math.tanh(math.sqrt(2 / math.pi) * (v + 0.044715 * v ** 3)))
+        return 0.5 * v * (1 + math.erf(v / math.sqrt(2)))
+
+    wte, wpe = W[prefix + "wte.weight"], W[prefix + "wpe.weight"]
+    xs = [[a + b for a, b in zip(wte[t * H:(t + 1) * H], wpe[i * H:(i + 1) * H])] for i, t in enumerate(IDS)]
+    for l in range(L):
+        p = f"{prefix}h.{l}."
+        qkv = [conv(ln(x, p + "ln_1"), p + "attn.c_attn", H, 3 * H) for x in xs]
+        attn = attend([r[:H] for r in qkv], [r[H:2 * H] for r in qkv], [r[2 * H:] for r in qkv], NHX, NHX)
+        xs = [[a + b for a, b in zip(x, conv(o, p + "attn.c_proj", H, H))] for x, o in zip(xs, attn)]
+        mlp = [conv([gelu(a) for a in conv(ln(x, p + "ln_2"), p + "mlp.c_fc", H, FF)], p + "mlp.c_proj", FF, H) for x in xs]
+        xs = [[a + b for a, b in zip(x, m)] for x, m in zip(xs, mlp)]
+    # lm_head is tied to wte
+    write_logits(name, [matvec(ln(x, prefix + "ln_f"), wte, V, H) for x in xs])
+
+
+def generate_neox(name, seed, parallel):
+    random.seed(seed)
+    NHX, RD = 2, HD // 2  # heads, rotary dims (rotary_pct 0.5)
+    T = {}
+
+    def ln_params(p):
+        T[p + ".weight"] = [1 + random.gauss(0, 0.1) for _ in range(H)], [H]
+        T[p + ".bias"] = rand(H, scale=0.1)
+
+    T["gpt_neox.embed_in.weight"] = rand(V, H)
+    for l in range(L):
+        p = f"gpt_neox.layers.{l}."
+        ln_params(p + "input_layernorm")
+        ln_params(p + "post_attention_layernorm")
+        T[p + "attention.query_key_value.weight"] = rand(3 * NHX * HD, H)
+        T[p + "attention.query_key_value.bias"] = rand(3 * NHX * HD, scale=0.1)
+        T[p + "attention.dense.weight"] = rand(H, NHX * HD)
+        T[p + "attention.dense.bias"] = rand(H, scale=0.1)
+        T[p + "mlp.dense_h_to_4h.weight"] = rand(I, H)
+        T[p + "mlp.dense_h_to_4h.bias"] = rand(I, scale=0.1)
+        T[p + "mlp.dense_4h_to_h.weight"] = rand(H, I)
+        T[p + "mlp.dense_4h_to_h.bias"] = rand(H, scale=0.1)
+    ln_params("gpt_neox.final_layer_norm")
+    T["embed_out.weight"] = rand(V, H)
+    write_checkpoint(name, T, {
+        "architectures": ["GPTNeoXForCausalLM"], "model_type": "gpt_neox",
+        "vocab_size": V, "hidden_size": H, "num_hidden_layers": L, "num_attention_heads": NHX,
+        "intermediate_size": I, "hidden_act": "gelu", "max_position_embeddings": 64,
+        "rotary_pct": RD / HD, "rotary_emb_base": 10000, "layer_norm_eps": 1e-5,
+        "use_parallel_residual": parallel, "tie_word_embeddings": False, "eos_token_id": 0})
+
+    W = {k: v for k, (v, s) in T.items()}
+
+    def ln(x, p):
+        m = sum(x) / len(x)
+        inv = 1 / math.sqrt(sum((a - m) ** 2 for a in x) / len(x) + 1e-5)
+        return [(a - m) * inv * w + b for a, w, b in zip(x, W[p + ".weight"], W[p + ".bias"])]
+
+    def dense(x, p, rows, cols):
+        return matvec(x, W[p + ".weight"], rows, cols, W[p + ".bias"])
+
+    def gelu(v):
+        return 0.5 * v * (1 + math.erf(v / math.sqrt(2)))
+
+    inv = [1.0 / 10000 ** (2 * i / RD) for i in range(RD // 2)]
+    emb = W["gpt_neox.embed_in.weight"]
+    xs = [emb[t * H:(t + 1) * H] for t in IDS]
+    for l in range(L):
+        p = f"gpt_neox.layers.{l}."
+        qkv = [dense(ln(x, p + "input_layernorm"), p + "attention.query_key_value", 3 * NHX * HD, H) for x in xs]
+        # query_key_value rows are packed per head: [heads, 3, head_dim]
+        qs, ks, vs = [], [], []
+        for t, row in enumerate(qkv):
+            q, k, v = [], [], []
+            for h in range(NHX):
+                base = 3 * h * HD
+                q += rotate_half(row[base:base + RD], t, inv, RD) + row[base + RD:base + HD]
+                k += rotate_half(row[base + HD:base + HD + RD], t, inv, RD) + row[base + HD + RD:base + 2 * HD]
+                v += row[base + 2 * HD:base + 3 * HD]
+            qs.append(q); ks.append(k); vs.append(v)
+        attn = [dense(o, p + "attention.dense", H, NHX * HD) for o in attend(qs, ks, vs, NHX, NHX)]
+        if parallel:
+            mlp_in = xs
+        else:
+            xs = [[a + b for a, b in zip(x, o)] for x, o in zip(xs, attn)]
+            mlp_in = xs
+        mlp = [dense([gelu(a) for a in dense(ln(x, p + "post_attention_layernorm"), p + "mlp.dense_h_to_4h", I, H)],
+                     p + "mlp.dense_4h_to_h", H, I) for x in mlp_in]
+        if parallel:
+            xs = [[a + b + c for a, b, c in zip(x, o, m)] for x, o, m in zip(xs, attn, mlp)]
+        else:
+            xs = [[a + b for a, b in zip(x, m)] for x, m in zip(xs, mlp)]
+    write_logits(name, [matvec(ln(x, "gpt_neox.final_layer_norm"), W["embed_out.weight"], V, H) for x in xs])
+
+
+def inv_freqs(cfg):
+    base = cfg["rope_theta"]
+    inv = [1.0 / base ** (2 * i / HD) for i in range(HD // 2)]
+    rs = cfg.get("rope_scaling")
+    if not rs:
+        return inv
+    factor, low, high = rs["factor"], rs["low_freq_factor"], rs["high_freq_factor"]
+    old = rs["original_max_position_embeddings"]
+    out = []
+    for f in inv:
+        wavelen = 2 * math.pi / f
+        if wavelen < old / high:
+            out.append(f)
+        elif wavelen > old / low:
+            out.append(f / factor)
+        else:
+            s = (old / wavelen - low) / (high - low)
+            out.append((1 - s) * f / factor + s * f)
+    return out
+
+
+def generate(name, seed, tie, window, config, bias=False, qk_norm=False, gemma=0, moe=None, layers=L):
+    """Llama-style decoder; bias adds Qwen2 q/k/v biases, qk_norm Qwen3's
+    per-head norms, gemma 1 or 2 the Gemma / Gemma 2 options and moe the
+    (block, gate, up, down) names of a Mixtral / Qwen2-MoE expert block."""
+    random.seed(seed)
+    T = {}
+    E = config.get("num_local_experts", config.get("num_experts", 0))
+    MI = config.get("moe_intermediate_size", I)
+    S = config.get("shared_expert_intermediate_size", 0)
+
+    def sparse(l):
+        # HF: every decoder_sparse_step-th layer that is not in mlp_only_layers
+        return bool(moe) and l not in config.get("mlp_only_layers", []) and \
+            (l + 1) % config.get("decoder_sparse_step", 1) == 0
+
+    def r(*shape, scale=0.3):
+        n = 1
+        for s in shape:
+            n *= s
+        T_shape = list(shape)
+        return [random.gauss(0, scale) for _ in range(n)], T_shape
+
+    def norm(n):
+        # Gemma stores w for a (1 + w) scale
+        return [(0 if gemma else 1) + random.gauss(0, 0.1) for _ in range(n)], [n]
+
+    T["model.embed_tokens.weight"] = r(V, H)
+    for l in range(layers):
+        p = f"model.layers.{l}."
+        T[p + "input_layernorm.weight"] = norm(H)
+        T[p + "self_attn.q_proj.weight"] = r(NH * HD, H)
+        T[p + "self_attn.k_proj.weight"] = r(NKV * HD, H)
+        T[p + "self_attn.v_proj.weight"] = r(NKV * HD, H)
+        if bias:
+            T[p + "self_attn.q_proj.bias"] = r(NH * HD, scale=0.1)
+            T[p + "self_attn.k_proj.bias"] = r(NKV * HD, scale=0.1)
+            T[p + "self_attn.v_proj.bias"] = r(NKV * HD, scale=0.1)
+        if qk_norm:
+            T[p + "self_attn.q_norm.weight"] = norm(HD)
+            T[p + "self_attn.k_norm.weight"] = norm(HD)
+        T[p + "self_attn.o_proj.weight"] = r(H, NH * HD)
+        T[p + "post_attention_layernorm.weight"] = norm(H)
+        if gemma == 2:
+            T[p + "pre_feedforward_layernorm.weight"] = norm(H)
+            T[p + "post_feedforward_layernorm.weight"] = norm(H)
+        if sparse(l):
+            b, (wg, wu, wd) = p + moe[0] + ".", moe[1:]
+            T[b + "gate.weight"] = r(E, H)
+            for e in range(E):
+                T[f"{b}experts.{e}.{wg}.weight"] = r(MI, H)
+                T[f"{b}experts.{e}.{wu}.weight"] = r(MI, H)
+                T[f"{b}experts.{e}.{wd}.weight"] = r(H, MI)
+            if S:
+                T[b + "shared_expert.gate_proj.weight"] = r(S, H)
+                T[b + "shared_expert.up_proj.weight"] = r(S, H)
+                T[b + "shared_expert.down_proj.weight"] = r(H, S)
+                T[b + "shared_expert_gate.weight"] = r(1, H)
+        else:
+            T[p + "mlp.gate_proj.weight"] = r(I, H)
+            T[p + "mlp.up_proj.weight"] = r(I, H)
+            T[p + "mlp.down_proj.weight"] = r(H, I)
+    T["model.norm.weight"] = norm(H)
+    if not tie:
+        T["lm_head.weight"] = r(V, H)
+
+    cfg = dict(config, vocab_size=V, hidden_size=H, num_hidden_layers=layers,
+               num_attention_heads=NH, num_key_value_heads=NKV, head_dim=HD,
+               intermediate_size=I, hidden_act="gelu_pytorch_tanh" if gemma else "silu",
+               rms_norm_eps=1e-5, eos_token_id=0)
+    write_checkpoint(name, T, cfg)
+
+    W = {k: v for k, (v, s) in T.items()}
+
+    def linear(x, name, rows, cols):
+        w = W[name]
+        return [sum(x[k] * w[o * cols + k] for k in range(cols)) for o in range(rows)]
+
+    def rms(x, name):
+        inv = 1 / math.sqrt(sum(a * a for a in x) / len(x) + 1e-5)
+        return [a * inv * (1 + w if gemma else w) for a, w in zip(x, W[name])]
+
+    def softcap(v, cap):
+        return cap * math.tanh(v / cap) if cap else v
+
+    def act(g):
+        if gemma:
+            return 0.5 * g * (1 + math.tanh(math.sqrt(2 / math.pi) * (g + 0.044715 * g ** 3)))
+        return g / (1 + math.exp(-g))
+
+    inv = inv_freqs(cfg)
+
+    def rope(v, pos):
+        # rotate-half pairing (i, i + HD/2)
+        out = list(v)
+        for i, f in enumerate(inv):
+            c, s = math.cos(pos * f), math.sin(pos * f)
+            a, b = v[i], v[i + HD // 2]
+            out[i] = a * c - b * s
+            out[i + HD // 2] = b * c + a * s
+        return out
+
+    def proj(x, name, rows):
+        out = linear(x, name + ".weight", rows, H)
+        return [a + b for a, b in zip(out, W[name + ".bias"])] if bias else out
+
+    def head(v, h, norm_name):
+        # Qwen3 RMS-normalizes every head before rope
+        v = v[h * HD:(h + 1) * HD]
+        return rms(v, norm_name) if qk_norm else v
+
+    def gated(x, gate, up, down, n):
+        g = linear(x, gate, n, H)
+        u = linear(x, up, n, H)
+        return linear([act(gi) * ui for gi, ui in zip(g, u)], down, H, n)
+
+    def experts(x, b):
+        # softmax router, top-k experts (renormalized for Mixtral and
+        # norm_topk_prob), plus the sigmoid-gated shared expert
+        logits = linear(x, b + "gate.weight", E, H)
+        mx = max(logits)
+        e = [math.exp(a - mx) for a in logits]
+        probs = [a / sum(e) for a in e]
+        top = sorted(range(E), key=lambda i: -probs[i])[:config["num_experts_per_tok"]]
+        total = sum(probs[i] for i in top)
+        out = [0.0] * H
+        for i in top:
+            w = probs[i] / total if config.get("norm_topk_prob", config["model_type"] == "mixtral") else probs[i]
+            ep = f"{b}experts.{i}."
+            y = gated(x, ep + moe[1] + ".weight", ep + moe[2] + ".weight", ep + moe[3] + ".weight", MI)
+            out = [o + w * a for o, a in zip(out, y)]
+        if S:
+            sp = b + "shared_expert."
+            y = gated(x, sp + "gate_proj.weight", sp + "up_proj.weight", sp + "down_proj.weight", S)
+            g = 1 / (1 + math.exp(-linear(x, b + "shared_expert_gate.weight", 1, H)[0]))
+            out = [o + g * a for o, a in zip(out, y)]
+        return out
+
+    def add(xs, ys):
+        return [[a + b for a, b in zip(x, y)] for x, y in zip(xs, ys)]
+
+    # scores are divided by sqrt(query_pre_attn_scalar), head_dim by default
+    scale = math.sqrt(cfg.get("query_pre_attn_scalar", HD))
+    attn_cap = cfg.get("attn_logit_softcapping")
+    emb = W["model.embed_tokens.weight"]
+    # Gemma scales the embeddings by sqrt(hidden_size)
+    xs = [emb[t * H:(t + 1) * H] for t in IDS]
+    if gemma:
+        xs = [[a * math.sqrt(H) for a in x] for x in xs]
+    group = NH // NKV
+    for l in range(layers):
+        p = f"model.layers.{l}."
+        # Gemma 2 alternates sliding (even) and global (odd) layers
+        win = window if gemma != 2 or l % 2 == 0 else 0
+        hn = [rms(x, p + "input_layernorm.weight") for x in xs]
+        qs = [proj(x, p + "self_attn.q_proj", NH * HD) for x in hn]
+        ks = [proj(x, p + "self_attn.k_proj", NKV * HD) for x in hn]
+        vs = [proj(x, p + "self_attn.v_proj", NKV * HD) for x in hn]
+        qs = [sum((rope(head(q, h, p + "self_attn.q_norm.weight"), t) for h in range(NH)), []) for t, q in enumerate(qs)]
+        ks = [sum((rope(head(k, h, p + "self_attn.k_norm.weight"), t) for h in range(NKV)), []) for t, k in enumerate(ks)]
+        outs = []
+        for t in range(len(xs)):
+            o = []
+            lo = t - win + 1 if win else 0
+            for h in range(NH):
+                kv = h // group
+                q = qs[t][h * HD:(h + 1) * HD]
+                keys = range(max(lo, 0), t + 1)
+                sc = [softcap(sum(q[d] * ks[s][kv * HD + d] for d in range(HD)) / scale, attn_cap) for s in keys]
+                mx = max(sc)
+                e = [math.exp(a - mx) for a in sc]
+                z = sum(e)
+                o += [sum(e[j] / z * vs[s][kv * HD + d] for j, s in enumerate(keys)) for d in range(HD)]
+            outs.append(linear(o, p + "self_attn.o_proj.weight", H, NH * HD))
+        if gemma == 2:
+            # sandwich norms around attention and MLP
+            outs = [rms(o, p + "post_attention_layernorm.weight") for o in outs]
+            xs = add(xs, outs)
+            hn = [rms(x, p + "pre_feedforward_layernorm.weight") for x in xs]
+        else:
+            xs = add(xs, outs)
+            hn = [rms(x, p + "post_attention_layernorm.weight") for x in xs]
+        if sparse(l):
+            ms = [experts(x, p + moe[0] + ".") for x in hn]
+        else:
+            ms = [gated(x, p + "mlp.gate_proj.weight", p + "mlp.up_proj.weight", p + "mlp.down_proj.weight", I) for x in hn]
+        if gemma == 2:
+            ms = [rms(m, p + "post_feedforward_layernorm.weight") for m in ms]
+        xs = add(xs, ms)
+    head_w = "model.embed_tokens.weight" if tie else "lm_head.weight"
+    final_cap = cfg.get("final_logit_softcapping")
+    write_logits(name, [[softcap(v, final_cap) for v in linear(rms(x, "model.norm.weight"), head_w, V, H)] for x in xs])
+
+
+if __name__ == "__main__":
+    os.chdir(os.path.dirname(os.path.abspath(__file__)))
+    for name, fx in FIXTURES.items():
+        generate(name, **fx)
+    for name, fx in GPT2.items():
+        generate_gpt2(name, **fx)
+    for name, fx in NEOX.items():
+        generate_neox(name, **fx)
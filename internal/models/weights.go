@@ -0,0 +1,44 @@
+package models
+
+// weightMap names the tensors of a decoder layer, relative to
+// "model.layers.N.". Fused projections are used when their name is set:
+// a fused qkv is split on load, a fused gate_up is kept as is.
+type weightMap struct {
+    QKV        string // fused [q; k; v] projection ("" = separate Q, K, V)
+    Q, K, V, O string
+
+    GateUp         string // fused [gate; up] projection ("" = separate Gate, Up)
+    Gate, Up, Down string
+
+    // mixture-of-experts block and per-expert projection names
+    MoE                              string
+    ExpertGate, ExpertUp, ExpertDown string
+}
+
+// llamaWeights are the HF Llama/Qwen/Mistral/Gemma names
+var llamaWeights = weightMap{
+    Q: "self_attn.q_proj", K: "self_attn.k_proj", V: "self_attn.v_proj", O: "self_attn.o_proj",
+    Gate: "mlp.gate_proj", Up: "mlp.up_proj", Down: "mlp.down_proj",
+    MoE: "mlp", ExpertGate: "gate_proj", ExpertUp: "up_proj", ExpertDown: "down_proj",
+}
+
+// mixtralWeights stores its experts as block_sparse_moe.experts.N.w1/w3/w2
+var mixtralWeights = withMoE(llamaWeights, "block_sparse_moe", "w1", "w3", "w2")
+
+// phi3Weights uses fused qkv_proj and gate_up_proj tensors
+var phi3Weights = weightMap{
+    QKV: "self_attn.qkv_proj", O: "self_attn.o_proj",
+    GateUp: "mlp.gate_up_proj", Down: "mlp.down_proj",
+}
+
+// withMoE returns wm with the expert block renamed
+func withMoE(wm weightMap, block, gate, up, down string) weightMap {
+    wm.MoE, wm.ExpertGate, wm.ExpertUp, wm.ExpertDown = block, gate, up, down
+    return wm
+}
+
+// prefixed returns p+name, or "" when name is unset
+func prefixed(p, name string) string {
+    if name == "" { return "" }
+    return p + name
+}
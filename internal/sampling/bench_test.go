@@ -0,0 +1,182 @@
+package sampling
+
+import (
+    "math"
+    "math/rand"
+    "sort"
+    "testing"
+
+    "github.com/unixsysdev/nano-go-vllm/internal/tensor"
+)
+
+// benchVocab is a Qwen2-sized vocabulary
+const benchVocab = 151936
+
+func benchLogits(n int, seed int64) []float32 {
+    r := rand.New(rand.NewSource(seed))
+    logits := make([]float32, n)
+    for i := range logits { logits[i] = float32(r.NormFloat64() * 3) }
+    return logits
+}
+
+// The sort* functions are the previous full-vocabulary implementations,
+// kept as benchmark baselines.
+
+func sortTopKFilter(logits []float32, k int) {
+    idx := make([]int, len(logits))
+    for i := range idx { idx[i] = i }
+    sort.Slice(idx, func(i, j int) bool { return logits[idx[i]] > logits[idx[j]] })
+    thresh := logits[idx[k-1]]
+    for i := range logits {
+        if logits[i] < thresh { logits[i] = -1e30 }
+    }
+}
+
+func sortTopPFilter(probs []float32, p float32) []float32 {
+    idx := make([]int, len(probs))
+    for i := range idx { idx[i] = i }
+    sort.Slice(idx, func(i, j int) bool { return probs[idx[i]] > probs[idx[j]] })
+    var cum float32
+    cutoff := len(idx)
+    for i, id := range idx {
+        cum += probs[id]
+        if cum >= p { cutoff = i + 1; break }
+    }
+    for _, id := range idx[cutoff:] { probs[id] = 0 }
+    return renormalize(probs)
+}
+
+func sortTypicalFilter(probs []float32, mass float32) []float32 {
+    ent := entropy(probs)
+    idx := make([]int, 0, len(probs))
+    shifted := make([]float64, len(probs))
+    for i, v := range probs {
+        if v <= 0 { continue }
+        shifted[i] = math.Abs(-math.Log(float64(v)) - ent)
+        idx = append(idx, i)
+    }
+    sort.Slice(idx, func(i, j int) bool { return shifted[idx[i]] < shifted[idx[j]] })
+    var cum float32
+    last := 0
+    for _, id := range idx {
+        cum += probs[id]
+        if cum < mass { last++ } else { break }
+    }
+    if last > len(idx)-1 { last = len(idx) - 1 }
+    thresh := shifted[idx[last]]
+    for _, id := range idx {
+        if shifted[id] > thresh { probs[id] = 0 }
+    }
+    return renormalize(probs)
+}
+
+func sortSample(logits []float32, p SamplingParams) int {
+    work := append([]float32(nil), logits...)
+    if p.TopK > 0 { sortTopKFilter(work, p.TopK) }
+    probs := softmax(work)
+    if p.TopP > 0 && p.TopP < 1 { probs = sortTopPFilter(probs, p.TopP) }
+    if p.TypicalP > 0 && p.TypicalP < 1 { probs = sortTypicalFilter(probs, p.TypicalP) }
+    return sampleFromProbs(probs)
+}
+
+func BenchmarkTopK(b *testing.B) {
+    src := benchLogits(benchVocab, 1)
+    work := make([]float32, len(src))
+    idx := make([]int, len(src))
+    b.Run("select", func(b *testing.B) {
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+            copy(work, src)
+            topKFilter(work, 50, idx)
+        }
+    })
+    b.Run("sort", func(b *testing.B) {
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+            copy(work, src)
+            sortTopKFilter(work, 50)
+        }
+    })
+}
+
+func BenchmarkTopP(b *testing.B) {
+    probs := softmax(benchLogits(benchVocab, 2))
+    work := make([]float32, len(probs))
+    idx := make([]int, len(probs))
+    b.Run("select", func(b *testing.B) {
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+            copy(work, probs)
+            topPFilter(work, 0.9, idx)
+        }
+    })
+    b.Run("sort", func(b *testing.B) {
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+            copy(work, probs)
+            sortTopPFilter(work, 0.9)
+        }
+    })
+}
+
+func BenchmarkTypical(b *testing.B) {
+    probs := softmax(benchLogits(benchVocab, 3))
+    work := make([]float32, len(probs))
+    idx := make([]int, len(probs))
+    scores := make([]float32, len(probs))
+    b.Run("select", func(b *testing.B) {
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+            copy(work, probs)
+            typicalFilter(work, 0.9, idx, scores)
+        }
+    })
+    b.Run("sort", func(b *testing.B) {
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+            copy(work, probs)
+            sortTypicalFilter(work, 0.9)
+        }
+    })
+}
+
+func BenchmarkMirostat(b *testing.B) {
+    logits := benchLogits(benchVocab, 4)
+    for _, v := range []int{1, 2} {
+        p := SamplingParams{Mirostat: v}
+        st := NewState()
+        b.Run(map[int]string{1: "v1", 2: "v2"}[v], func(b *testing.B) {
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ { sampleMirostat(logits, p, st) }
+        })
+    }
+}
+
+// BenchmarkSampleBatch samples a batch of 8 rows with top-k 50 and top-p 0.9
+func BenchmarkSampleBatch(b *testing.B) {
+    const batch = 8
+    logits, err := tensor.NewTensor([]int{batch, benchVocab}, tensor.Float32, tensor.CPU)
+    if err != nil { b.Fatal(err) }
+    data := logits.Data().Data().([]float32)
+    copy(data, benchLogits(batch*benchVocab, 5))
+    p := &SamplingParams{TopK: 50, TopP: 0.9}
+    temps := make([]float32, batch)
+    params := make([]*SamplingParams, batch)
+    states := make([]*State, batch)
+    for i := range params {
+        temps[i], params[i], states[i] = 0.8, p, NewState()
+    }
+    s := NewSampler()
+    b.Run("select", func(b *testing.B) {
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+            if _, err := s.Sample(logits, temps, nil, params, states); err != nil { b.Fatal(err) }
+        }
+    })
+    b.Run("sort", func(b *testing.B) {
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+            for r := 0; r < batch; r++ { sortSample(data[r*benchVocab:(r+1)*benchVocab], *p) }
+        }
+    })
+}
@@ -0,0 +1,39 @@
+package sampling
+
+// applyLogitBias adds per-token biases (OpenAI logit_bias semantics)
+func applyLogitBias(logits []float32, bias map[int]float32) {
+    for id, b := range bias {
+        if id < 0 || id >= len(logits) { continue }
+        logits[id] += b
+    }
+}
+
+// applyAllowedTokens masks every token outside the whitelist. keep is a
+// cleared scratch mask of len(logits).
+func applyAllowedTokens(logits []float32, allowed []int, keep []bool) {
+    for _, id := range allowed {
+        if id >= 0 && id < len(logits) { keep[id] = true }
+    }
+    for i, ok := range keep {
+        if !ok { logits[i] = -1e30 }
+    }
+}
+
+// applyBadWords bans the last token of every bad-word sequence whose prefix
+// ends the generated tokens. Single-token sequences are always banned.
+func applyBadWords(logits []float32, prev []int, badWords [][]int) {
+    for _, seq := range badWords {
+        n := len(seq)
+        if n == 0 { continue }
+        last := seq[n-1]
+        if last < 0 || last >= len(logits) { continue }
+        prefix := seq[:n-1]
+        if len(prefix) > len(prev) { continue }
+        tail := prev[len(prev)-len(prefix):]
+        match := true
+        for j, id := range prefix {
+            if tail[j] != id { match = false; break }
+        }
+        if match { logits[last] = -1e30 }
+    }
+}
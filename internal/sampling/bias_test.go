@@ -0,0 +1,82 @@
+package sampling
+
+import (
+    "reflect"
+    "testing"
+
+    "github.com/unixsysdev/nano-go-vllm/internal/tensor"
+)
+
+// banned returns the ids applyBadWords masks after the generated tokens prev
+func banned(prev []int, badWords [][]int) []int {
+    logits := make([]float32, 6)
+    applyBadWords(logits, prev, badWords)
+    var ids []int
+    for id, l := range logits {
+        if l == -1e30 { ids = append(ids, id) }
+    }
+    return ids
+}
+
+func TestBadWords(t *testing.T) {
+    tests := []struct {
+        name     string
+        prev     []int
+        badWords [][]int
+        want     []int
+    }{
+        {"single token always", nil, [][]int{{4}}, []int{4}},
+        {"prefix not generated yet", nil, [][]int{{1, 2}}, nil},
+        {"prefix generated", []int{3, 1}, [][]int{{1, 2}}, []int{2}},
+        {"prefix earlier, not at the end", []int{1, 3}, [][]int{{1, 2}}, nil},
+        {"longer prefix", []int{0, 1, 5}, [][]int{{1, 5, 3}, {0, 5, 2}}, []int{3}},
+        {"prefix longer than the output", []int{5}, [][]int{{1, 5, 3}}, nil},
+        {"out of range and empty", []int{1}, [][]int{{}, {1, 9}, {-1}}, nil},
+    }
+    for _, tt := range tests {
+        if got := banned(tt.prev, tt.badWords); !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%s: banned %v, want %v", tt.name, got, tt.want)
+        }
+    }
+}
+
+// TestBadWordsSkipPrompt: a bad-word prefix at the end of the prompt does
+// not count, only one in the completion does
+func TestBadWordsSkipPrompt(t *testing.T) {
+    logits, err := tensor.NewTensor([]int{1, 4}, tensor.Float32, tensor.CPU)
+    if err != nil { t.Fatal(err) }
+    copy(logits.Data().Data().([]float32), []float32{0, 1, 5, 2})
+    params := []*SamplingParams{{TopK: 1, BadWordsIDs: [][]int{{1, 2}}}}
+    for _, tt := range []struct {
+        context []int
+        want    int
+    }{
+        {[]int{0, 1}, 2},    // prompt [0 1], nothing generated
+        {[]int{0, 1, 1}, 3}, // prompt [0 1], generated [1]
+        {[]int{0, 1, 1, 3}, 2},
+    } {
+        st := NewState()
+        st.NumPromptTokens = 2
+        tok, err := NewSampler().Sample(logits, []float32{1}, [][]int{tt.context}, params, []*State{st})
+        if err != nil { t.Fatal(err) }
+        if tok[0] != tt.want { t.Errorf("context %v: sampled %d, want %d", tt.context, tok[0], tt.want) }
+    }
+}
+
+func TestLogitBiasAndAllowedTokens(t *testing.T) {
+    logits := []float32{1, 2, 3, 4}
+    applyLogitBias(logits, map[int]float32{0: 5, 3: -100, 7: 1, -1: 1})
+    if want := []float32{6, 2, 3, -96}; !reflect.DeepEqual(logits, want) { t.Errorf("biased %v, want %v", logits, want) }
+
+    applyAllowedTokens(logits, []int{1, 3, 9, -2}, make([]bool, len(logits)))
+    if want := []float32{-1e30, 2, -1e30, -96}; !reflect.DeepEqual(logits, want) { t.Errorf("whitelisted %v, want %v", logits, want) }
+
+    // a bias cannot bring back a token outside the whitelist
+    in, err := tensor.NewTensor([]int{1, 4}, tensor.Float32, tensor.CPU)
+    if err != nil { t.Fatal(err) }
+    copy(in.Data().Data().([]float32), []float32{1, 2, 3, 4})
+    params := []*SamplingParams{{TopK: 1, LogitBias: map[int]float32{0: 100, 2: 10}, AllowedTokenIDs: []int{1, 2}}}
+    tok, err := NewSampler().Sample(in, []float32{1}, nil, params, nil)
+    if err != nil { t.Fatal(err) }
+    if tok[0] != 2 { t.Errorf("sampled %d, want 2 (biased and allowed)", tok[0]) }
+}
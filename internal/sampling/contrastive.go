@@ -0,0 +1,65 @@
+package sampling
+
+import (
+    "fmt"
+    "math"
+)
+
+// Lookahead gives contrastive search access to model hidden states. The
+// engine implements it per sequence.
+type Lookahead interface {
+    // Context returns the last-layer hidden state of every token so far
+    Context() [][]float32
+    // Candidates returns the hidden state each candidate would produce as
+    // the next token, without committing any of them
+    Candidates(ids []int) ([][]float32, error)
+}
+
+// contrastive reports whether the params select contrastive search
+func (p SamplingParams) contrastive() bool {
+    return p.PenaltyAlpha > 0 && p.TopK > 1
+}
+
+// sampleContrastive picks, among the top-k tokens, the one maximizing
+// (1-α)·p(v) − α·max_j cos(h_v, h_j) over the context hidden states h_j
+// (Su et al. 2022; HF contrastive search). It is deterministic.
+func sampleContrastive(logits []float32, p SamplingParams, la Lookahead, st *State) (int, error) {
+    probs, idx := st.scratch(len(logits))
+    softmaxInto(probs, logits)
+    k := p.TopK
+    if k > len(probs) { k = len(probs) }
+    sortTop(probs, fillIndex(idx), k)
+    cand := idx[:k]
+    // Masked tokens never compete
+    for len(cand) > 1 && probs[cand[len(cand)-1]] == 0 { cand = cand[:len(cand)-1] }
+
+    hidden, err := la.Candidates(cand)
+    if err != nil { return 0, fmt.Errorf("contrastive lookahead: %v", err) }
+    if len(hidden) != len(cand) { return 0, fmt.Errorf("contrastive lookahead: got %d hidden states for %d candidates", len(hidden), len(cand)) }
+    context := la.Context()
+
+    alpha := float64(p.PenaltyAlpha)
+    best, bestScore := cand[0], math.Inf(-1)
+    for i, id := range cand {
+        // Degeneration penalty: max similarity to any previous token
+        degeneration := 0.0
+        for j, h := range context {
+            if c := cosine(hidden[i], h); j == 0 || c > degeneration { degeneration = c }
+        }
+        score := (1-alpha)*float64(probs[id]) - alpha*degeneration
+        if score > bestScore { best, bestScore = id, score }
+    }
+    return best, nil
+}
+
+// cosine returns the cosine similarity of a and b
+func cosine(a, b []float32) float64 {
+    var dot, na, nb float64
+    for i := range a {
+        dot += float64(a[i]) * float64(b[i])
+        na += float64(a[i]) * float64(a[i])
+        nb += float64(b[i]) * float64(b[i])
+    }
+    if na == 0 || nb == 0 { return 0 }
+    return dot / math.Sqrt(na*nb)
+}
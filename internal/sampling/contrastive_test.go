@@ -0,0 +1,75 @@
+package sampling
+
+import (
+    "math"
+    "reflect"
+    "testing"
+)
+
+// fakeLookahead serves fixed hidden states per token id and records what
+// sampleContrastive asks for
+type fakeLookahead struct {
+    context   [][]float32
+    hidden    map[int][]float32
+    requested []int
+}
+
+func (f *fakeLookahead) Context() [][]float32 { return f.context }
+
+func (f *fakeLookahead) Candidates(ids []int) ([][]float32, error) {
+    f.requested = append([]int(nil), ids...)
+    out := make([][]float32, len(ids))
+    for i, id := range ids { out[i] = f.hidden[id] }
+    return out, nil
+}
+
+// TestContrastive: candidates are the top-k by probability, masked tokens
+// excluded, and the pick maximizes (1-α)·p − α·max cosine to the context
+func TestContrastive(t *testing.T) {
+    // p(3) = 0.5, p(0) = 0.3, p(2) = 0.2, token 1 masked
+    logits := []float32{float32(math.Log(0.3)), -1e30, float32(math.Log(0.2)), float32(math.Log(0.5))}
+    hidden := map[int][]float32{
+        3: {1, 0},   // max cosine 1
+        0: {1, 1},   // 0.7071
+        2: {1, 0.1}, // 0.9950
+    }
+    context := [][]float32{{1, 0}, {0, 1}}
+    tests := []struct {
+        alpha   float32
+        context [][]float32
+        want    int
+    }{
+        // 0.9·0.5 − 0.1 = 0.35 beats 0.27 − 0.0707 and 0.18 − 0.0995
+        {0.1, context, 3},
+        // −0.25 loses to 0.15 − 0.3536 = −0.2036
+        {0.5, context, 0},
+        {0.9, context, 0},
+        // no context, no penalty: the most likely token
+        {0.9, nil, 3},
+    }
+    for _, tt := range tests {
+        la := &fakeLookahead{context: tt.context, hidden: hidden}
+        p := SamplingParams{PenaltyAlpha: tt.alpha, TopK: 4}
+        got, err := sampleContrastive(append([]float32(nil), logits...), p, la, NewState())
+        if err != nil { t.Fatal(err) }
+        if got != tt.want { t.Errorf("α %g, context %v: picked %d, want %d", tt.alpha, tt.context, got, tt.want) }
+        if want := []int{3, 0, 2}; !reflect.DeepEqual(la.requested, want) {
+            t.Errorf("α %g: looked ahead at %v, want %v", tt.alpha, la.requested, want)
+        }
+    }
+}
+
+func TestCosine(t *testing.T) {
+    for _, tt := range []struct {
+        a, b []float32
+        want float64
+    }{
+        {[]float32{1, 0}, []float32{2, 0}, 1},
+        {[]float32{1, 0}, []float32{0, 3}, 0},
+        {[]float32{1, 1}, []float32{-1, -1}, -1},
+        {[]float32{1, 0}, []float32{1, 1}, math.Sqrt2 / 2},
+        {[]float32{0, 0}, []float32{1, 1}, 0},
+    } {
+        if got := cosine(tt.a, tt.b); math.Abs(got-tt.want) > 1e-6 { t.Errorf("cosine(%v, %v) = %g, want %g", tt.a, tt.b, got, tt.want) }
+    }
+}
@@ -0,0 +1,72 @@
+package sampling
+
+import "math"
+
+// DRY defaults (as in text-generation-webui / llama.cpp)
+const (
+    defaultDRYBase          = 1.75
+    defaultDRYAllowedLength = 2
+)
+
+// DefaultDRYSequenceBreakers are used when a request enables DRY without
+// naming its own breakers
+var DefaultDRYSequenceBreakers = []string{"\n", ":", "\"", "*"}
+
+// applyDRY penalizes tokens that would extend a repeat of earlier context.
+// For every earlier position i, the longest common suffix of context[:i+1]
+// and context is its match length n (capped at the last sequence breaker);
+// context[i+1] then continues the repeat and gets the penalty for the
+// longest n seen. The suffix lengths come from the Z-array of the reversed
+// context, so one step is O(len(context)).
+func applyDRY(logits []float32, context []int, p SamplingParams) {
+    n := len(context)
+    if n < 2 { return }
+    base := float64(p.DRYBase)
+    if base <= 0 { base = defaultDRYBase }
+    allowed := p.DRYAllowedLength
+    if allowed <= 0 { allowed = defaultDRYAllowedLength }
+
+    // A repeat may not include a breaker, so it is at most the run of
+    // non-breaker tokens at the end of the context
+    breakers := make(map[int]bool, len(p.DRYSequenceBreakerIDs))
+    for _, id := range p.DRYSequenceBreakerIDs { breakers[id] = true }
+    maxLen := 0
+    for maxLen < n && !breakers[context[n-1-maxLen]] { maxLen++ }
+    if maxLen < allowed { return }
+
+    rev := make([]int, n)
+    for i, id := range context { rev[n-1-i] = id }
+    z := zArray(rev)
+
+    // z[j] is the match length for the earlier suffix ending at n-1-j,
+    // whose continuation token is context[n-j]
+    best := make(map[int]int)
+    for j := 1; j < n; j++ {
+        m := z[j]
+        if m > maxLen { m = maxLen }
+        if m < allowed { continue }
+        next := context[n-j]
+        if m > best[next] { best[next] = m }
+    }
+    for id, m := range best {
+        if id < 0 || id >= len(logits) { continue }
+        pen := float64(p.DRYMultiplier) * math.Pow(base, float64(m-allowed))
+        logits[id] -= float32(math.Min(pen, 1e30))
+    }
+}
+
+// zArray returns z where z[j] is the length of the longest common prefix of
+// s and s[j:] (z[0] = len(s))
+func zArray(s []int) []int {
+    n := len(s)
+    z := make([]int, n)
+    if n == 0 { return z }
+    z[0] = n
+    l, r := 0, 0
+    for j := 1; j < n; j++ {
+        if j < r { z[j] = min(r-j, z[j-l]) }
+        for j+z[j] < n && s[z[j]] == s[j+z[j]] { z[j]++ }
+        if j+z[j] > r { l, r = j, j+z[j] }
+    }
+    return z
+}
@@ -0,0 +1,64 @@
+package sampling
+
+import (
+    "math"
+    "testing"
+)
+
+func TestDRY(t *testing.T) {
+    tests := []struct {
+        name    string
+        context []int
+        p       SamplingParams
+        want    map[int]float64 // penalty per token, 0 elsewhere
+    }{
+        // "1 2 3" at the end repeats positions 0-2, continued by 4: n = 3,
+        // penalty 2^(3-2)
+        {"match length", []int{1, 2, 3, 4, 1, 2, 3},
+            SamplingParams{DRYMultiplier: 1, DRYBase: 2, DRYAllowedLength: 2}, map[int]float64{4: 2}},
+        {"allowed length equal", []int{1, 2, 3, 4, 1, 2, 3},
+            SamplingParams{DRYMultiplier: 1, DRYBase: 2, DRYAllowedLength: 3}, map[int]float64{4: 1}},
+        {"allowed length above", []int{1, 2, 3, 4, 1, 2, 3},
+            SamplingParams{DRYMultiplier: 1, DRYBase: 2, DRYAllowedLength: 4}, nil},
+        // defaults base 1.75 and allowed length 2: 0.5 * 1.75^1
+        {"defaults", []int{1, 2, 3, 4, 1, 2, 3},
+            SamplingParams{DRYMultiplier: 0.5}, map[int]float64{4: 0.875}},
+        // 4 follows "1 2 3" (n = 3) and "5 2 3" (n = 2): the longest counts
+        {"longest per token", []int{1, 2, 3, 4, 5, 2, 3, 4, 6, 1, 2, 3},
+            SamplingParams{DRYMultiplier: 1, DRYBase: 2, DRYAllowedLength: 2}, map[int]float64{4: 2}},
+        // two continuations of "0 1 2", each n = 3
+        {"several tokens", []int{0, 1, 2, 4, 9, 0, 1, 2, 5, 0, 1, 2},
+            SamplingParams{DRYMultiplier: 1, DRYBase: 2, DRYAllowedLength: 2}, map[int]float64{4: 2, 5: 2}},
+        // a run of ten 1s: the suffix of nine repeats, n = 9, 2^7
+        {"run", []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+            SamplingParams{DRYMultiplier: 1, DRYBase: 2, DRYAllowedLength: 2}, map[int]float64{1: 128}},
+        // breaker 2 right before the final 3 leaves a run of one
+        {"breaker inside the repeat", []int{1, 2, 3, 4, 1, 2, 3},
+            SamplingParams{DRYMultiplier: 1, DRYBase: 2, DRYAllowedLength: 2, DRYSequenceBreakerIDs: []int{2}}, nil},
+        // breaker 1 caps n at the "2 3" after it
+        {"breaker caps the length", []int{1, 2, 3, 4, 1, 2, 3},
+            SamplingParams{DRYMultiplier: 1, DRYBase: 2, DRYAllowedLength: 2, DRYSequenceBreakerIDs: []int{1}}, map[int]float64{4: 1}},
+        {"no repeat", []int{1, 2, 3, 4, 5},
+            SamplingParams{DRYMultiplier: 1, DRYBase: 2, DRYAllowedLength: 1}, nil},
+        {"single token", []int{1},
+            SamplingParams{DRYMultiplier: 1, DRYBase: 2, DRYAllowedLength: 1}, nil},
+    }
+    for _, tt := range tests {
+        logits := make([]float32, 10)
+        applyDRY(logits, tt.context, tt.p)
+        for id, l := range logits {
+            if want := -tt.want[id]; math.Abs(float64(l)-want) > 1e-6 {
+                t.Errorf("%s: token %d: logit %g, want %g", tt.name, id, l, want)
+            }
+        }
+    }
+}
+
+func TestZArray(t *testing.T) {
+    s := []int{1, 1, 2, 1, 1, 2, 1}
+    want := []int{7, 1, 0, 4, 1, 0, 1}
+    got := zArray(s)
+    for i := range want {
+        if got[i] != want[i] { t.Fatalf("zArray(%v) = %v, want %v", s, got, want) }
+    }
+}
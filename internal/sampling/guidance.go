@@ -0,0 +1,44 @@
+package sampling
+
+import "math"
+
+// ApplyGuidance combines conditional and unconditional logits for
+// classifier-free guidance: on log-probabilities,
+// out = uncond + scale*(cond - uncond) (HF ClassifierFreeGuidanceLogitsProcessor).
+// The result is written into cond.
+func ApplyGuidance(cond, uncond []float32, scale float32) {
+    logSoftmax(cond)
+    logSoftmax(uncond)
+    for i := range cond {
+        cond[i] = uncond[i] + scale*(cond[i]-uncond[i])
+    }
+}
+
+// TokenLogprobs returns, for each row of logits [len(ids), vocab], the
+// log-probability of ids[row]; logits are left untouched
+func TokenLogprobs(logits []float32, vocab int, ids []int) []float32 {
+    out := make([]float32, len(ids))
+    for r, id := range ids {
+        row := logits[r*vocab : (r+1)*vocab]
+        max := row[0]
+        for _, v := range row {
+            if v > max { max = v }
+        }
+        var sum float64
+        for _, v := range row { sum += math.Exp(float64(v - max)) }
+        out[r] = row[id] - max - float32(math.Log(sum))
+    }
+    return out
+}
+
+// logSoftmax replaces logits with their log-probabilities
+func logSoftmax(logits []float32) {
+    max := logits[0]
+    for _, v := range logits {
+        if v > max { max = v }
+    }
+    var sum float64
+    for _, v := range logits { sum += math.Exp(float64(v - max)) }
+    lse := max + float32(math.Log(sum))
+    for i := range logits { logits[i] -= lse }
+}
@@ -0,0 +1,26 @@
+package sampling
+
+import (
+    "math"
+    "testing"
+)
+
+// TestApplyGuidance checks out = uncond + scale*(cond - uncond) on the
+// log-probabilities of cond [1 2 3] and uncond [0 0 5], computed by hand
+func TestApplyGuidance(t *testing.T) {
+    for _, tt := range []struct {
+        scale float32
+        want  []float64
+    }{
+        {1.5, []float64{-1.1047160, 0.3952840, -0.6047160}},
+        {1, []float64{-2.4076060, -1.4076060, -0.4076060}}, // cond alone
+        {0, []float64{-5.0133859, -5.0133859, -0.0133859}}, // uncond alone
+        {-1, []float64{-7.6191658, -8.6191658, 0.3808342}},
+    } {
+        cond, uncond := []float32{1, 2, 3}, []float32{0, 0, 5}
+        ApplyGuidance(cond, uncond, tt.scale)
+        for i, w := range tt.want {
+            if math.Abs(float64(cond[i])-w) > 1e-5 { t.Errorf("scale %g: token %d: %g, want %g", tt.scale, i, cond[i], w) }
+        }
+    }
+}
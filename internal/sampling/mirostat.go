@@ -0,0 +1,87 @@
+package sampling
+
+import (
+    "math"
+    "math/rand"
+)
+
+// Mirostat defaults used when the corresponding params are left at zero
+const (
+    defaultMirostatTau = 5.0
+    defaultMirostatEta = 0.1
+    mirostatM          = 100 // tokens used to estimate the Zipf exponent (v1)
+)
+
+// sampleMirostat draws a token with Mirostat v1 or v2 and updates st.MirostatMu
+func sampleMirostat(logits []float32, p SamplingParams, st *State) int {
+    tau, eta := p.MirostatTau, p.MirostatEta
+    if tau <= 0 { tau = defaultMirostatTau }
+    if eta <= 0 { eta = defaultMirostatEta }
+    if !st.mirostatInit {
+        st.MirostatMu = 2 * tau
+        st.mirostatInit = true
+    }
+
+    probs, idx := st.scratch(len(logits))
+    softmaxInto(probs, logits)
+    fillIndex(idx)
+
+    var keep int
+    if p.Mirostat == 1 {
+        // Only the top mirostatM need sorting for the Zipf estimate; the
+        // top-k survivors are then selected in any order
+        sortTop(probs, idx, min(mirostatM, len(idx)))
+        keep = mirostatV1K(probs, idx, st.MirostatMu)
+        if keep < 1 { keep = 1 }
+        if keep > len(idx) { keep = len(idx) }
+        if keep > mirostatM { selectTop(probs, idx, keep) }
+    } else {
+        // v2: keep tokens whose surprise -log2(p) does not exceed μ, i.e.
+        // p >= 2^-μ, and at least the most likely one
+        thresh := float32(math.Exp2(-float64(st.MirostatMu)))
+        best := argmax(probs)
+        idx[0], keep = best, 1
+        for id, v := range probs {
+            if v >= thresh && id != best { idx[keep] = id; keep++ }
+        }
+    }
+
+    // Renormalize over the survivors and sample
+    var sum float32
+    for _, id := range idx[:keep] { sum += probs[id] }
+    r := rand.Float32() * sum
+    chosen := idx[keep-1]
+    var cum float32
+    for _, id := range idx[:keep] {
+        cum += probs[id]
+        if r < cum { chosen = id; break }
+    }
+
+    // Observed surprise is measured on the truncated distribution
+    observed := -math.Log2(float64(probs[chosen] / sum))
+    st.MirostatMu -= eta * (float32(observed) - tau)
+    return chosen
+}
+
+// mirostatV1K estimates the Zipf exponent from the top-m probs and returns
+// the top-k size expected to yield surprise μ (Basu et al., Algorithm 1)
+func mirostatV1K(probs []float32, idx []int, mu float32) int {
+    n := len(idx)
+    var sumTiBi, sumTiSq float64
+    for i := 0; i < mirostatM-1 && i < n-1; i++ {
+        p0, p1 := float64(probs[idx[i]]), float64(probs[idx[i+1]])
+        if p0 <= 0 || p1 <= 0 { break }
+        ti := math.Log(float64(i+2) / float64(i+1))
+        bi := math.Log(p0 / p1)
+        sumTiBi += ti * bi
+        sumTiSq += ti * ti
+    }
+    if sumTiSq == 0 { return 1 }
+    sHat := sumTiBi / sumTiSq
+    epsHat := sHat - 1
+    if sHat <= 0 || epsHat == 0 { return 1 }
+    k := math.Pow((epsHat*math.Pow(2, float64(mu)))/(1-math.Pow(float64(n), -epsHat)), 1/sHat)
+    if math.IsNaN(k) || k < 1 { return 1 }
+    if k > float64(n) { return n }
+    return int(math.Round(k))
+}
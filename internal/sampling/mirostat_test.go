@@ -0,0 +1,89 @@
+package sampling
+
+import (
+    "math"
+    "testing"
+)
+
+// zipfLogits has p_i proportional to (i+1)^-s, the distribution Mirostat v1
+// assumes, so the exponent estimate is exact
+func zipfLogits(n int, s float64) []float32 {
+    logits := make([]float32, n)
+    for i := range logits { logits[i] = float32(-s * math.Log(float64(i+1))) }
+    return logits
+}
+
+// checkMu checks μ' = μ - η(surprise - τ), with the surprise of chosen
+// measured on the mass of the kept tokens
+func checkMu(t *testing.T, step int, probs []float32, chosen int, kept float64, mu, got float32) {
+    t.Helper()
+    surprise := -math.Log2(float64(probs[chosen]) / kept)
+    if want := float64(mu) - 0.1*(surprise-5); math.Abs(float64(got)-want) > 1e-4 {
+        t.Fatalf("step %d: μ = %g after token %d, want %g", step, got, chosen, want)
+    }
+}
+
+func TestMirostatV1K(t *testing.T) {
+    probs := softmax(zipfLogits(200, 1.5))
+    idx := make([]int, len(probs))
+    fillIndex(idx)
+    // ŝ = 1.5: k = (0.5 * 2^10 / (1 - 200^-0.5))^(1/1.5) = 67.2
+    if k := mirostatV1K(probs, idx, 10); k != 67 { t.Errorf("k = %d at μ 10, want 67", k) }
+    if k := mirostatV1K(probs, idx, 0); k != 1 { t.Errorf("k = %d at μ 0, want 1", k) }
+    if k := mirostatV1K(probs, idx, 30); k != 200 { t.Errorf("k = %d at μ 30, want the whole vocabulary", k) }
+}
+
+// TestMirostatMuUpdate runs both versions on a Zipf distribution: every
+// token comes from the truncated set for the current μ, and μ moves by the
+// surprise of that token on the renormalized set
+func TestMirostatMuUpdate(t *testing.T) {
+    logits := zipfLogits(200, 1.5)
+    probs := softmax(logits)
+    idx := make([]int, len(probs))
+    fillIndex(idx)
+    for _, version := range []int{1, 2} {
+        p := SamplingParams{Mirostat: version, MirostatTau: 5, MirostatEta: 0.1}
+        st := NewState()
+        mu := float32(10) // 2τ before the first token
+        for step := 0; step < 50; step++ {
+            // v1 keeps the top k (ids are already in rank order), v2 every
+            // token with surprise at most μ
+            var keep int
+            if version == 1 {
+                keep = mirostatV1K(probs, idx, mu)
+            } else {
+                for keep < len(probs) && -math.Log2(float64(probs[keep])) <= float64(mu) { keep++ }
+                keep = max(keep, 1)
+            }
+            var kept float64
+            for _, v := range probs[:keep] { kept += float64(v) }
+
+            tok := sampleMirostat(append([]float32(nil), logits...), p, st)
+            if tok >= keep { t.Fatalf("v%d step %d: token %d outside the top %d", version, step, tok, keep) }
+            checkMu(t, step, probs, tok, kept, mu, st.MirostatMu)
+            mu = st.MirostatMu
+        }
+    }
+}
+
+// TestMirostatMuZero: μ that has fallen to 0 is a valid state (greedy
+// truncation), not a cue to re-initialize it to 2τ
+func TestMirostatMuZero(t *testing.T) {
+    // two equally likely tokens
+    logits := []float32{0, 0, -1e30, -1e30}
+    for _, version := range []int{1, 2} {
+        p := SamplingParams{Mirostat: version, MirostatTau: 5, MirostatEta: 0.1}
+        st := NewState()
+        sampleMirostat(append([]float32(nil), logits...), p, st)
+        // v2 keeps both (1 bit of surprise), v1 estimates no Zipf slope and
+        // keeps one (0 bits)
+        want := float32(10.4)
+        if version == 1 { want = 10.5 }
+        if math.Abs(float64(st.MirostatMu-want)) > 1e-5 { t.Errorf("v%d: first μ = %g, want %g", version, st.MirostatMu, want) }
+
+        st.MirostatMu = 0
+        sampleMirostat(append([]float32(nil), logits...), p, st)
+        // only the argmax survives μ = 0: 0 bits, μ = 0 + 0.1*5
+        if math.Abs(float64(st.MirostatMu-0.5)) > 1e-5 { t.Errorf("v%d: μ = %g after μ 0, want 0.5", version, st.MirostatMu) }
+    }
+}
@@ -0,0 +1,54 @@
+package sampling
+
+import "testing"
+
+// TestRepetitionPenaltyParity checks the penalties against the HF
+// RepetitionPenaltyLogitsProcessor (penalty 2): every distinct token of the
+// penalized history has a positive logit divided and a negative one
+// multiplied, once regardless of its count. The expected logits are
+// precomputed from that rule for the history below.
+func TestRepetitionPenaltyParity(t *testing.T) {
+    logits := []float32{2, -1, 0.5, 3, -2, 1}
+    prompt := []int{0, 1}
+    completion := []int{3, 1, 4}
+    tests := []struct {
+        name   string
+        params SamplingParams
+        want   []float32
+    }{
+        // HF with input_ids = completion only: tokens 3, 1, 4
+        {"completion", SamplingParams{RepetitionPenalty: 2},
+            []float32{2, -2, 0.5, 1.5, -4, 1}},
+        // HF with input_ids = prompt + completion: tokens 0, 1, 3, 4
+        {"include prompt", SamplingParams{RepetitionPenalty: 2, PenaltyIncludePrompt: true},
+            []float32{1, -2, 0.5, 1.5, -4, 1}},
+        // HF on input_ids[-2:] = [1, 4]
+        {"include prompt, last 2", SamplingParams{RepetitionPenalty: 2, PenaltyIncludePrompt: true, PenaltyLastN: 2},
+            []float32{2, -2, 0.5, 3, -4, 1}},
+        // the window never reaches into the prompt without PenaltyIncludePrompt
+        {"completion, last 4", SamplingParams{RepetitionPenalty: 2, PenaltyLastN: 4},
+            []float32{2, -2, 0.5, 1.5, -4, 1}},
+        {"completion, last 1", SamplingParams{RepetitionPenalty: 2, PenaltyLastN: 1},
+            []float32{2, -1, 0.5, 3, -4, 1}},
+        // frequency penalty scales with the count: token 1 appears twice
+        {"frequency, include prompt", SamplingParams{FrequencyPenalty: 0.5, PenaltyIncludePrompt: true},
+            []float32{1.5, -2, 0.5, 2.5, -2.5, 1}},
+    }
+    history := append(append([]int(nil), prompt...), completion...)
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            st := NewState()
+            // grow the history one token at a time so the window counts are
+            // built incrementally, as during decoding
+            var counts map[int]int
+            for n := len(prompt); n <= len(history); n++ {
+                counts = st.windowCounts(history[:n], tt.params.penaltyStart(n, n-len(prompt)))
+            }
+            got := append([]float32(nil), logits...)
+            applyPenalties(got, counts, tt.params)
+            for i := range tt.want {
+                if got[i] != tt.want[i] { t.Fatalf("got %v, want %v", got, tt.want) }
+            }
+        })
+    }
+}
@@ -4,7 +4,6 @@ import (
     "fmt"
     "math"
     "math/rand"
-    "sort"
 
     "github.com/unixsysdev/nano-go-vllm/internal/tensor"
 )
@@ -19,6 +18,70 @@ type SamplingParams struct {
     RepetitionPenalty float32
     PresencePenalty   float32
     FrequencyPenalty  float32
+    // PenaltyIncludePrompt makes the penalties above count prompt tokens too
+    // (HF transformers behaviour); PenaltyLastN > 0 limits them to the most
+    // recent N tokens.
+    PenaltyIncludePrompt bool
+    PenaltyLastN         int
+    // Truncation warpers, applied after top-k/top-p with Hugging Face semantics.
+    // A zero value disables each of them.
+    MinP          float32 // keep tokens with prob >= MinP * max prob
+    TypicalP      float32 // locally typical sampling mass (0 or 1 = disabled)
+    EpsilonCutoff float32 // drop tokens with prob < EpsilonCutoff
+    EtaCutoff     float32 // entropy-dependent epsilon: min(eta, sqrt(eta)*exp(-H))
+    // Mirostat adaptive sampling (1 or 2; 0 = off). Replaces the truncation
+    // filters above and keeps per-sequence state in State.MirostatMu.
+    Mirostat    int
+    MirostatTau float32 // target surprise τ in bits (default 5)
+    MirostatEta float32 // learning rate η (default 0.1)
+    // Structured output; at most one may be set. Grammar is GBNF source (see
+    // internal/grammar), JSONSchema a JSON Schema document, and JSONObject
+    // accepts any JSON object. Regex must match the whole output and Choices
+    // forces it to be exactly one of the listed strings. Generation stops
+    // once the output is complete and cannot be extended.
+    Grammar    string
+    JSONSchema string
+    JSONObject bool
+    Regex      string
+    Choices    []string
+    // LogitBias is added to the logits of the given token ids (OpenAI
+    // logit_bias); -100 effectively bans a token. AllowedTokenIDs, when
+    // non-empty, restricts sampling to those ids.
+    LogitBias       map[int]float32
+    AllowedTokenIDs []int
+    // BadWords are phrases that must not be generated. The engine tokenizes
+    // them into BadWordsIDs; the last token of a phrase is banned whenever
+    // the completion ends with the rest of it.
+    BadWords    []string
+    BadWordsIDs [][]int
+    // DRY ("don't repeat yourself") penalizes the token that would extend a
+    // repeat of earlier context by DRYMultiplier * DRYBase^(n - DRYAllowedLength)
+    // for a repeat of length n >= DRYAllowedLength. Repeats never span a
+    // sequence breaker; the engine tokenizes DRYSequenceBreakers into
+    // DRYSequenceBreakerIDs. DRYMultiplier 0 disables it.
+    DRYMultiplier         float32
+    DRYBase               float32 // default 1.75
+    DRYAllowedLength      int     // default 2
+    DRYSequenceBreakers   []string
+    DRYSequenceBreakerIDs []int
+    // PenaltyAlpha > 0 with TopK > 1 selects contrastive search: the top-k
+    // token maximizing (1-α)·p − α·(max cosine similarity of its hidden
+    // state to the context). Needs State.Lookahead; temperature and the
+    // truncation filters are ignored.
+    PenaltyAlpha float32
+    // Classifier-free guidance: a GuidanceScale other than 0 or 1 runs a
+    // second sequence on NegativePrompt (or, when empty, the last prompt
+    // token) in lockstep and samples from uncond + scale*(cond - uncond)
+    // over log-probabilities.
+    NegativePrompt string
+    GuidanceScale  float32
+    // Watermark, when set, biases a pseudo-random green list of tokens so
+    // the output can later be attributed with Watermark.Detect
+    Watermark *Watermark
+    // PromptLogprobs requests the log-probability the model assigns to each
+    // prompt token after the first given the tokens before it, from the raw
+    // logits of the prefill (vLLM prompt_logprobs)
+    PromptLogprobs bool
 }
 
 // Sampler represents a token sampler
@@ -29,8 +92,11 @@ func NewSampler() *Sampler {
 	return &Sampler{}
 }
 
-// Sample samples tokens from logits
-func (s *Sampler) Sample(logits *tensor.Tensor, temperatures []float32, prevTokens [][]int, params []*SamplingParams) ([]int, error) {
+// Sample samples tokens from logits. prevTokens holds each row's full token
+// history; State.NumPromptTokens splits off the prompt. states holds optional
+// per-row sequence state (may be nil) and is updated in place by stateful
+// modes like Mirostat.
+func (s *Sampler) Sample(logits *tensor.Tensor, temperatures []float32, prevTokens [][]int, params []*SamplingParams, states []*State) ([]int, error) {
     shape := logits.Shape()
     if len(shape) != 2 {
         return nil, fmt.Errorf("logits must be 2D tensor")
@@ -43,66 +109,133 @@ func (s *Sampler) Sample(logits *tensor.Tensor, temperatures []float32, prevToke
     
     for i := 0; i < batchSize; i++ {
         offset := i * vocabSize
-        // Work on a copy to avoid mutating upstream values
-        logitSlice := make([]float32, vocabSize)
-        copy(logitSlice, logitsData[offset:offset+vocabSize])
-        
-        // Apply temperature
-        temp := temperatures[i]
-        if temp > 0 {
-            for j := range logitSlice {
-                logitSlice[j] /= temp
-            }
-        }
         // Penalties / filters configured per sample
         p := DefaultParams
         if params != nil && i < len(params) && params[i] != nil {
-            p.TopP = params[i].TopP
-            p.TopK = params[i].TopK
-            p.RepetitionPenalty = params[i].RepetitionPenalty
-            p.PresencePenalty = params[i].PresencePenalty
-            p.FrequencyPenalty = params[i].FrequencyPenalty
+            p = *params[i]
         }
 
-        // Apply repetition / presence / frequency penalties on logits
-        if prevTokens != nil && i < len(prevTokens) {
-            applyPenalties(logitSlice, prevTokens[i], p)
+        st := NewState()
+        if states != nil && i < len(states) && states[i] != nil { st = states[i] }
+        // Work on a copy (reused per sequence) to avoid mutating upstream values
+        logitSlice := st.logitBuffer(vocabSize)
+        copy(logitSlice, logitsData[offset:offset+vocabSize])
+
+        // prevTokens holds the full context (prompt + completion); the
+        // per-token penalties and bad words only look at the completion
+        var context []int
+        if prevTokens != nil && i < len(prevTokens) { context = prevTokens[i] }
+        prev := context
+        if st.NumPromptTokens <= len(context) { prev = context[st.NumPromptTokens:] }
+
+        // Apply repetition / presence / frequency penalties on the raw
+        // logits (before temperature, as HF logits processors do) over the
+        // completion, or the whole context with PenaltyIncludePrompt, limited
+        // to the last PenaltyLastN tokens
+        if p.hasPenalties() {
+            lo := p.penaltyStart(len(context), len(prev))
+            applyPenalties(logitSlice, st.windowCounts(context, lo), p)
+        }
+        if p.DRYMultiplier > 0 {
+            applyDRY(logitSlice, context, p)
         }
 
-        // Top-k filter
-        if p.TopK > 0 {
-            topKFilter(logitSlice, p.TopK)
+        // Logit bias, whitelist and bad words, before any truncation
+        if len(p.LogitBias) > 0 {
+            applyLogitBias(logitSlice, p.LogitBias)
+        }
+        if len(p.AllowedTokenIDs) > 0 {
+            applyAllowedTokens(logitSlice, p.AllowedTokenIDs, st.maskBuffer(vocabSize))
         }
-        // Softmax -> probs
-        probs := softmax(logitSlice)
-        // Top-p filter (nucleus)
-        if p.TopP > 0 && p.TopP < 1 {
-            probs = topPFilter(probs, p.TopP)
+        if len(p.BadWordsIDs) > 0 {
+            applyBadWords(logitSlice, prev, p.BadWordsIDs)
+        }
+        if p.Watermark != nil && len(context) > 0 {
+            p.Watermark.apply(logitSlice, context[len(context)-1])
+        }
+
+        // Structured-output constraints mask before temperature, so every
+        // banned token is still exactly -1e30. A dead end ends only this
+        // sequence (with EOS) instead of failing the whole batch.
+        if st.Constraint != nil && !st.applyConstraint(logitSlice) {
+            st.stalled = true
+            tokens[i] = st.EOSTokenID
+            continue
+        }
+
+        // Apply temperature (contrastive search is deterministic and skips it)
+        contrastive := p.contrastive() && st.Lookahead != nil
+        temp := temperatures[i]
+        if temp > 0 && !contrastive {
+            for j := range logitSlice {
+                logitSlice[j] /= temp
+            }
+        }
+
+        // Contrastive search and Mirostat replace the static truncation filters
+        if contrastive {
+            tok, err := sampleContrastive(logitSlice, p, st.Lookahead, st)
+            if err != nil { return nil, fmt.Errorf("row %d: %v", i, err) }
+            tokens[i] = tok
+        } else if p.Mirostat == 1 || p.Mirostat == 2 {
+            tokens[i] = sampleMirostat(logitSlice, p, st)
+        } else {
+            tokens[i] = sampleFiltered(logitSlice, p, st)
+        }
+        if st.Constraint != nil {
+            if err := st.Constraint.Accept(tokens[i]); err != nil {
+                return nil, fmt.Errorf("constraint (row %d): %v", i, err)
+            }
         }
-        // Sample token
-        tokens[i] = sampleFromProbs(probs)
     }
     
     return tokens, nil
 }
 
+// sampleFiltered applies top-k and the probability-space warpers, then samples
+func sampleFiltered(logitSlice []float32, p SamplingParams, st *State) int {
+    probs, idx := st.scratch(len(logitSlice))
+    // Top-k filter
+    if p.TopK > 0 {
+        topKFilter(logitSlice, p.TopK, idx)
+    }
+    // Softmax -> probs
+    softmaxInto(probs, logitSlice)
+    // Top-p filter (nucleus)
+    if p.TopP > 0 && p.TopP < 1 {
+        probs = topPFilter(probs, p.TopP, idx)
+    }
+    // Remaining warpers in HF order: min-p, typical, epsilon, eta
+    if p.MinP > 0 {
+        probs = minPFilter(probs, p.MinP)
+    }
+    if p.TypicalP > 0 && p.TypicalP < 1 {
+        probs = typicalFilter(probs, p.TypicalP, idx, st.scoreBuffer(len(probs)))
+    }
+    if p.EpsilonCutoff > 0 {
+        probs = epsilonFilter(probs, p.EpsilonCutoff)
+    }
+    if p.EtaCutoff > 0 {
+        probs = etaFilter(probs, p.EtaCutoff)
+    }
+    // Sample token
+    return sampleFromProbs(probs)
+}
+
 // DefaultParams is used for Sampler when not provided per-sequence (simple path)
-var DefaultParams = struct{
-    TopP float32
-    TopK int
-    RepetitionPenalty float32
-    PresencePenalty   float32
-    FrequencyPenalty  float32
-}{TopP: 0.95, TopK: 50, RepetitionPenalty: 1.0, PresencePenalty: 0.0, FrequencyPenalty: 0.0}
+var DefaultParams = SamplingParams{TopP: 0.95, TopK: 50, RepetitionPenalty: 1.0, PresencePenalty: 0.0, FrequencyPenalty: 0.0}
 
-func topKFilter(logits []float32, k int) {
+// topKFilter masks every logit below the k-th largest (ties are kept).
+// idx is a scratch buffer of len(logits).
+func topKFilter(logits []float32, k int, idx []int) {
     if k <= 0 || k >= len(logits) { return }
-    // Find kth largest threshold
-    // Copy indices and sort by logit desc
-    idx := make([]int, len(logits))
-    for i := range idx { idx[i] = i }
-    sort.Slice(idx, func(i, j int) bool { return logits[idx[i]] > logits[idx[j]] })
-    thresh := logits[idx[k-1]]
+    idx = fillIndex(idx[:len(logits)])
+    selectTop(logits, idx, k)
+    // idx[:k] are the k largest; the threshold is their minimum
+    thresh := logits[idx[0]]
+    for _, id := range idx[1:k] {
+        if logits[id] < thresh { thresh = logits[id] }
+    }
     for i := range logits {
         if logits[i] < thresh {
             logits[i] = -1e30
@@ -110,20 +243,29 @@ func topKFilter(logits []float32, k int) {
     }
 }
 
-func topPFilter(probs []float32, p float32) []float32 {
-    // Sort indices by prob desc
-    idx := make([]int, len(probs))
-    for i := range idx { idx[i] = i }
-    sort.Slice(idx, func(i, j int) bool { return probs[idx[i]] > probs[idx[j]] })
-    var cum float32
-    cutoff := len(probs)
-    for i, id := range idx {
-        cum += probs[id]
-        if cum >= p { cutoff = i+1; break }
+// topPFilter keeps the smallest set of most likely tokens whose mass reaches
+// p. Only tokens with nonzero prob (e.g. top-k survivors) are considered, and
+// they are sorted a prefix at a time, so the full vocabulary is rarely
+// sorted. idx is a scratch buffer of len(probs).
+func topPFilter(probs []float32, p float32, idx []int) []float32 {
+    cand := idx[:0]
+    for i, v := range probs {
+        if v > 0 { cand = append(cand, i) }
+    }
+    cutoff := len(cand)
+    for m := topPInitial; ; m *= 4 {
+        if m > len(cand) { m = len(cand) }
+        sortTop(probs, cand, m)
+        var cum float32
+        for i, id := range cand[:m] {
+            cum += probs[id]
+            if cum >= p { cutoff = i + 1; break }
+        }
+        if cutoff <= m || m == len(cand) { break }
     }
     // Zero out tail and renormalize
     var sum float32
-    for i, id := range idx {
+    for i, id := range cand {
         if i >= cutoff { probs[id] = 0 } else { sum += probs[id] }
     }
     if sum > 0 {
@@ -133,11 +275,118 @@ func topPFilter(probs []float32, p float32) []float32 {
     return probs
 }
 
-func applyPenalties(logits []float32, prev []int, cfg struct{TopP float32; TopK int; RepetitionPenalty, PresencePenalty, FrequencyPenalty float32}) {
-    if len(prev) == 0 { return }
-    // Count frequencies
-    counts := make(map[int]int)
-    for _, id := range prev { counts[id]++ }
+// minPFilter drops tokens whose prob is below minP scaled by the top prob
+func minPFilter(probs []float32, minP float32) []float32 {
+    var top float32
+    for _, v := range probs { if v > top { top = v } }
+    thresh := minP * top
+    for i, v := range probs {
+        if v < thresh { probs[i] = 0 }
+    }
+    return renormalize(probs)
+}
+
+// typicalFilter keeps the tokens whose surprisal is closest to the entropy
+// until their cumulative mass reaches mass (Meister et al., HF TypicalLogitsWarper).
+// Like topPFilter it sorts the most typical tokens a prefix at a time. idx
+// and scores are scratch buffers of len(probs).
+func typicalFilter(probs []float32, mass float32, idx []int, scores []float32) []float32 {
+    ent := entropy(probs)
+    cand := idx[:0]
+    for i, v := range probs {
+        if v <= 0 { continue }
+        // negated distance from the entropy: the most typical is the largest
+        scores[i] = -float32(math.Abs(-math.Log(float64(v)) - ent))
+        cand = append(cand, i)
+    }
+    if len(cand) == 0 { return probs }
+    // last = number of sorted tokens strictly below the target mass
+    last := len(cand) - 1
+    for m := topPInitial; ; m *= 4 {
+        if m > len(cand) { m = len(cand) }
+        sortTop(scores, cand, m)
+        var cum float32
+        found := false
+        for i, id := range cand[:m] {
+            cum += probs[id]
+            if cum >= mass { last, found = i, true; break }
+        }
+        if found || m == len(cand) { break }
+    }
+    thresh := scores[cand[last]]
+    for _, id := range cand {
+        if scores[id] < thresh { probs[id] = 0 }
+    }
+    return renormalize(probs)
+}
+
+// epsilonFilter drops tokens with prob below eps, always keeping the most likely one
+func epsilonFilter(probs []float32, eps float32) []float32 {
+    return cutoffFilter(probs, eps)
+}
+
+// etaFilter drops tokens below min(eta, sqrt(eta)*exp(-H)) where H is the entropy
+func etaFilter(probs []float32, eta float32) []float32 {
+    thresh := math.Min(float64(eta), math.Sqrt(float64(eta))*math.Exp(-entropy(probs)))
+    return cutoffFilter(probs, float32(thresh))
+}
+
+// cutoffFilter zeroes probs below thresh except for the argmax, then renormalizes
+func cutoffFilter(probs []float32, thresh float32) []float32 {
+    best := argmax(probs)
+    for i, v := range probs {
+        if v < thresh && i != best { probs[i] = 0 }
+    }
+    return renormalize(probs)
+}
+
+// entropy returns the Shannon entropy (nats) of a distribution
+func entropy(probs []float32) float64 {
+    var h float64
+    for _, v := range probs {
+        if v > 0 { h -= float64(v) * math.Log(float64(v)) }
+    }
+    return h
+}
+
+// argmax returns the index of the largest value
+func argmax(v []float32) int {
+    best := 0
+    for i := range v {
+        if v[i] > v[best] { best = i }
+    }
+    return best
+}
+
+// renormalize rescales probs to sum to 1
+func renormalize(probs []float32) []float32 {
+    var sum float32
+    for _, v := range probs { sum += v }
+    if sum > 0 {
+        inv := 1 / sum
+        for i := range probs { probs[i] *= inv }
+    }
+    return probs
+}
+
+// hasPenalties reports whether any per-token penalty is active
+func (p SamplingParams) hasPenalties() bool {
+    return (p.RepetitionPenalty != 0 && p.RepetitionPenalty != 1.0) || p.PresencePenalty != 0 || p.FrequencyPenalty != 0
+}
+
+// penaltyStart returns the first index of a token history of length n,
+// ending in completionLen completion tokens, that the penalties count
+func (p SamplingParams) penaltyStart(n, completionLen int) int {
+    lo := n - completionLen
+    if p.PenaltyIncludePrompt { lo = 0 }
+    if p.PenaltyLastN > 0 && n-p.PenaltyLastN > lo { lo = n - p.PenaltyLastN }
+    return lo
+}
+
+// applyPenalties applies the penalties to every token in counts. The
+// repetition penalty follows HF RepetitionPenaltyLogitsProcessor: once per
+// distinct token, multiply negative logits and divide positive ones.
+func applyPenalties(logits []float32, counts map[int]int, cfg SamplingParams) {
     for id, c := range counts {
         if id < 0 || id >= len(logits) { continue }
         // repetition penalty: divide or multiply logits
@@ -161,29 +410,27 @@ func applyPenalties(logits []float32, prev []int, cfg struct{TopP float32; TopK
 
 // softmax computes softmax probabilities
 func softmax(logits []float32) []float32 {
-	// Find max for numerical stability
-	max := logits[0]
-	for _, v := range logits {
-		if v > max {
-			max = v
-		}
-	}
-	
+    return softmaxInto(make([]float32, len(logits)), logits)
+}
+
+// softmaxInto writes softmax(logits) into dst and returns it
+func softmaxInto(dst, logits []float32) []float32 {
+    // Find max for numerical stability
+    max := logits[0]
+    for _, v := range logits {
+        if v > max { max = v }
+    }
     // Compute exp and sum
-    expVals := make([]float32, len(logits))
     var sum float32
     for i, v := range logits {
         val := float32(math.Exp(float64(v - max)))
-        expVals[i] = val
+        dst[i] = val
         sum += val
     }
-	
-	// Normalize
-	for i := range expVals {
-		expVals[i] /= sum
-	}
-	
-	return expVals
+    // Normalize
+    inv := 1 / sum
+    for i := range dst { dst[i] *= inv }
+    return dst
 }
 
 // sampleFromProbs samples from probability distribution
@@ -193,12 +440,18 @@ func sampleFromProbs(probs []float32) int {
 	
 	// Find the token
 	var cumSum float32
+	last := len(probs) - 1
 	for i, p := range probs {
+		if p > 0 {
+			last = i
+		}
 		cumSum += p
 		if r < cumSum {
 			return i
 		}
 	}
 	
-	return len(probs) - 1
+	// Rounding left r past the total: fall back to the last token with mass
+	// (never a masked one)
+	return last
 }
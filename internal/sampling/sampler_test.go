@@ -0,0 +1,117 @@
+package sampling
+
+import (
+    "math"
+    "testing"
+
+    "github.com/unixsysdev/nano-go-vllm/internal/tensor"
+)
+
+// testProbs is the distribution the warper cases below are worked out on.
+// Its entropy is H = 1.26906 nats; the surprisals -ln p are
+// 0.693, 1.386, 1.897, 2.659, 3.507, so |surprisal - H| is
+// 0.576, 0.117, 0.628, 1.390, 2.238.
+var testProbs = []float32{0.5, 0.25, 0.15, 0.07, 0.03}
+
+func TestTruncationWarpers(t *testing.T) {
+    tests := []struct {
+        name   string
+        filter func([]float32) []float32
+        want   []float32
+    }{
+        // threshold 0.2 * 0.5 = 0.1 keeps the first three
+        {"min_p 0.2", func(p []float32) []float32 { return minPFilter(p, 0.2) },
+            []float32{0.5 / 0.9, 0.25 / 0.9, 0.15 / 0.9, 0, 0}},
+        // threshold 0.6 * 0.5 = 0.3 keeps only the top token
+        {"min_p 0.6", func(p []float32) []float32 { return minPFilter(p, 0.6) },
+            []float32{1, 0, 0, 0, 0}},
+        // most typical first: ids 1, 0, 2, ...; mass 0.25 < 0.5 then 0.75
+        // reaches it, so ids 1 and 0 survive
+        {"typical 0.5", func(p []float32) []float32 { return typicalFilter(p, 0.5, make([]int, len(p)), make([]float32, len(p))) },
+            []float32{0.5 / 0.75, 0.25 / 0.75, 0, 0, 0}},
+        // 0.25, 0.75 < 0.8, then 0.9 reaches it: ids 1, 0, 2
+        {"typical 0.8", func(p []float32) []float32 { return typicalFilter(p, 0.8, make([]int, len(p)), make([]float32, len(p))) },
+            []float32{0.5 / 0.9, 0.25 / 0.9, 0.15 / 0.9, 0, 0}},
+        {"epsilon 0.05", func(p []float32) []float32 { return epsilonFilter(p, 0.05) },
+            []float32{0.5 / 0.97, 0.25 / 0.97, 0.15 / 0.97, 0.07 / 0.97, 0}},
+        // the argmax survives any cutoff
+        {"epsilon 0.9", func(p []float32) []float32 { return epsilonFilter(p, 0.9) },
+            []float32{1, 0, 0, 0, 0}},
+        // min(0.1, sqrt(0.1)*exp(-H)) = min(0.1, 0.0889) drops 0.07 and 0.03
+        {"eta 0.1", func(p []float32) []float32 { return etaFilter(p, 0.1) },
+            []float32{0.5 / 0.9, 0.25 / 0.9, 0.15 / 0.9, 0, 0}},
+        // min(0.02, sqrt(0.02)*exp(-H) = 0.0397) keeps everything
+        {"eta 0.02", func(p []float32) []float32 { return etaFilter(p, 0.02) },
+            []float32{0.5, 0.25, 0.15, 0.07, 0.03}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := tt.filter(append([]float32(nil), testProbs...))
+            for i := range tt.want {
+                if math.Abs(float64(got[i]-tt.want[i])) > 1e-6 {
+                    t.Fatalf("got %v, want %v", got, tt.want)
+                }
+            }
+        })
+    }
+}
+
+func TestTopKFilterKeepsTies(t *testing.T) {
+    logits := []float32{1, 3, 2, 3, 0}
+    topKFilter(logits, 2, make([]int, len(logits)))
+    want := []float32{-1e30, 3, -1e30, 3, -1e30}
+    for i := range want {
+        if logits[i] != want[i] { t.Fatalf("got %v, want %v", logits, want) }
+    }
+}
+
+func TestTopPFilter(t *testing.T) {
+    // 0.5 + 0.25 = 0.75 < 0.8, adding 0.15 reaches it
+    probs := topPFilter(append([]float32(nil), testProbs...), 0.8, make([]int, len(testProbs)))
+    want := []float32{0.5 / 0.9, 0.25 / 0.9, 0.15 / 0.9, 0, 0}
+    for i := range want {
+        if math.Abs(float64(probs[i]-want[i])) > 1e-6 { t.Fatalf("got %v, want %v", probs, want) }
+    }
+}
+
+// onlyToken is a constraint that allows a single token
+type onlyToken int
+
+func (c onlyToken) Allowed(allowed []bool) { allowed[c] = true }
+func (c onlyToken) Accept(id int) error   { return nil }
+func (c onlyToken) Done() bool            { return false }
+
+func TestConstraintDeadEndEmitsEOS(t *testing.T) {
+    logits, err := tensor.NewTensor([]int{2, 4}, tensor.Float32, tensor.CPU)
+    if err != nil { t.Fatal(err) }
+    copy(logits.Data().Data().([]float32), []float32{1, 2, 3, 4, 1, 2, 3, 4})
+    // row 0: the constraint allows only token 2, the whitelist only token 1
+    dead := &State{Constraint: onlyToken(2), EOSTokenID: 3}
+    ok := &State{Constraint: onlyToken(2), EOSTokenID: 3}
+    params := []*SamplingParams{{AllowedTokenIDs: []int{1}}, {}}
+    toks, err := NewSampler().Sample(logits, []float32{1, 1}, nil, params, []*State{dead, ok})
+    if err != nil { t.Fatal(err) }
+    if toks[0] != 3 || !dead.Stalled() { t.Errorf("dead end: got token %d, stalled %v; want EOS 3", toks[0], dead.Stalled()) }
+    if toks[1] != 2 || ok.Stalled() { t.Errorf("live row: got token %d, stalled %v; want 2", toks[1], ok.Stalled()) }
+}
+
+// TestFiltersMatchSort checks the partial-selection filters against the
+// full-sort baselines in bench_test.go on a large random distribution
+func TestFiltersMatchSort(t *testing.T) {
+    probs := softmax(benchLogits(5000, 7))
+    idx := make([]int, len(probs))
+    scores := make([]float32, len(probs))
+    for _, tt := range []struct {
+        name      string
+        got, want []float32
+    }{
+        {"top_p", topPFilter(append([]float32(nil), probs...), 0.9, idx), sortTopPFilter(append([]float32(nil), probs...), 0.9)},
+        {"typical", typicalFilter(append([]float32(nil), probs...), 0.9, idx, scores), sortTypicalFilter(append([]float32(nil), probs...), 0.9)},
+    } {
+        for i := range tt.want {
+            if (tt.got[i] == 0) != (tt.want[i] == 0) || math.Abs(float64(tt.got[i]-tt.want[i])) > 1e-6 {
+                t.Fatalf("%s: token %d: got %g, want %g", tt.name, i, tt.got[i], tt.want[i])
+            }
+        }
+    }
+}
@@ -0,0 +1,58 @@
+package sampling
+
+import (
+    "math/rand"
+    "sort"
+)
+
+// topPInitial is the first candidate count tried by top-p before falling
+// back to a larger partial sort
+const topPInitial = 256
+
+// selectTop partially orders idx so that idx[:k] hold the indices of the k
+// largest vals (in no particular order). It is an iterative quickselect with
+// a three-way partition, so the long runs of equal masked logits (-1e30)
+// that top-k and constraints leave behind do not degrade it. O(len(idx)).
+func selectTop(vals []float32, idx []int, k int) {
+    lo, hi := 0, len(idx)
+    for hi-lo > 1 {
+        pivot := vals[idx[lo+rand.Intn(hi-lo)]]
+        // [lo,lt) > pivot, [lt,i) == pivot, (gt,hi) < pivot
+        lt, i, gt := lo, lo, hi-1
+        for i <= gt {
+            v := vals[idx[i]]
+            switch {
+            case v > pivot:
+                idx[lt], idx[i] = idx[i], idx[lt]
+                lt++
+                i++
+            case v < pivot:
+                idx[i], idx[gt] = idx[gt], idx[i]
+                gt--
+            default:
+                i++
+            }
+        }
+        switch {
+        case k <= lt:
+            hi = lt
+        case k <= gt+1:
+            return
+        default:
+            lo = gt + 1
+        }
+    }
+}
+
+// sortTop orders idx[:k] by descending vals after selecting them
+func sortTop(vals []float32, idx []int, k int) {
+    if k < len(idx) { selectTop(vals, idx, k) }
+    top := idx[:k]
+    sort.Slice(top, func(i, j int) bool { return vals[top[i]] > vals[top[j]] })
+}
+
+// fillIndex fills idx with 0..len(idx)-1
+func fillIndex(idx []int) []int {
+    for i := range idx { idx[i] = i }
+    return idx
+}
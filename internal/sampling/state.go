@@ -0,0 +1,119 @@
+package sampling
+
+// State carries per-sequence sampler state that must persist across steps.
+// It lives on the engine Sequence, so it survives preemption and re-prefill.
+type State struct {
+    // NumPromptTokens is the length of the prompt at the start of the token
+    // history passed to Sample
+    NumPromptTokens int
+
+    // MirostatMu is the running maximum-surprise target μ (bits)
+    MirostatMu   float32
+    mirostatInit bool
+
+    // Constraint, when set, restricts sampling to tokens it allows and is
+    // advanced with every sampled token
+    Constraint Constraint
+    mask       []bool
+    // EOSTokenID is emitted when the constraint, together with the other
+    // masks, leaves no token to sample; the sequence is then Stalled
+    EOSTokenID int
+    stalled    bool
+
+    // Lookahead, when set, enables contrastive search (PenaltyAlpha)
+    Lookahead Lookahead
+
+    // counts holds token counts over history[countLo:countHi], slid forward
+    // incrementally as the sequence grows
+    counts           map[int]int
+    countLo, countHi int
+
+    // scratch buffers reused across steps to avoid per-token allocations
+    logits []float32
+    probs  []float32
+    idx    []int
+    scores []float32
+}
+
+// NewState creates an empty per-sequence sampler state
+func NewState() *State {
+    return &State{}
+}
+
+// Constraint restricts which tokens a sequence may sample next (grammars,
+// JSON schemas, regexes). Implementations keep their own parse state.
+type Constraint interface {
+    // Allowed sets allowed[id] = true for every token that may follow.
+    // The slice is cleared by the caller.
+    Allowed(allowed []bool)
+    // Accept advances the constraint past a sampled token.
+    Accept(tokenID int) error
+    // Done reports whether the output is complete and nothing may follow.
+    Done() bool
+}
+
+// Stalled reports whether the constraint reached a dead end (no token left
+// to sample), in which case EOS was emitted and the sequence must finish
+func (st *State) Stalled() bool { return st.stalled }
+
+// applyConstraint masks every logit the constraint disallows and reports
+// whether any token is left (also counting the whitelist and bad-word masks)
+func (st *State) applyConstraint(logits []float32) bool {
+    mask := st.maskBuffer(len(logits))
+    st.Constraint.Allowed(mask)
+    live := false
+    for i, ok := range mask {
+        if !ok {
+            logits[i] = -1e30
+        } else if logits[i] > -1e30 {
+            live = true
+        }
+    }
+    return live
+}
+
+// windowCounts returns token counts over history[lo:]. The window only moves
+// forward as a sequence grows, so each step adds the new tokens and drops
+// the ones that slid out; anything else rebuilds from scratch.
+func (st *State) windowCounts(history []int, lo int) map[int]int {
+    hi := len(history)
+    lo = min(lo, hi)
+    if st.counts == nil || lo < st.countLo || hi < st.countHi {
+        st.counts = make(map[int]int)
+        st.countLo, st.countHi = lo, lo
+    }
+    for _, id := range history[st.countLo:min(lo, st.countHi)] {
+        if st.counts[id]--; st.counts[id] <= 0 { delete(st.counts, id) }
+    }
+    if lo > st.countHi { st.countHi = lo }
+    for _, id := range history[st.countHi:hi] { st.counts[id]++ }
+    st.countLo, st.countHi = lo, hi
+    return st.counts
+}
+
+// logitBuffer returns a reusable logits buffer of length n
+func (st *State) logitBuffer(n int) []float32 {
+    if cap(st.logits) < n { st.logits = make([]float32, n) }
+    return st.logits[:n]
+}
+
+// maskBuffer returns a reusable, cleared token mask of length n
+func (st *State) maskBuffer(n int) []bool {
+    if cap(st.mask) < n { st.mask = make([]bool, n) }
+    mask := st.mask[:n]
+    for i := range mask { mask[i] = false }
+    return mask
+}
+
+// scoreBuffer returns a reusable per-token score buffer of length n
+func (st *State) scoreBuffer(n int) []float32 {
+    if cap(st.scores) < n { st.scores = make([]float32, n) }
+    return st.scores[:n]
+}
+
+// scratch returns reusable probability and index buffers of length n
+func (st *State) scratch(n int) ([]float32, []int) {
+    if cap(st.probs) < n { st.probs = make([]float32, n) }
+    if cap(st.idx) < n { st.idx = make([]int, n) }
+    return st.probs[:n], st.idx[:n]
+}
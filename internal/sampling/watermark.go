@@ -0,0 +1,69 @@
+package sampling
+
+import "math"
+
+// Watermark defaults (Kirchenbauer et al. 2023)
+const (
+    defaultWatermarkGamma = 0.25
+    defaultWatermarkDelta = 2.0
+)
+
+// Watermark configures a green-list watermark (Kirchenbauer et al., "A
+// Watermark for Large Language Models"). For every step the vocabulary is
+// split pseudo-randomly, seeded by Key and the previous token, into a green
+// fraction Gamma and the rest; green tokens get +Delta on their logits.
+// Detection recomputes the split and tests for an excess of green tokens.
+type Watermark struct {
+    Key   uint64
+    Gamma float32 // green-list fraction (default 0.25)
+    Delta float32 // logit bias for green tokens (default 2)
+}
+
+// WatermarkScore is the result of Detect
+type WatermarkScore struct {
+    Scored int     // number of tokens tested
+    Green  int     // how many of them were green
+    Z      float64 // one-proportion z-score; > 4 is strong evidence
+}
+
+func (w Watermark) gamma() float64 {
+    if w.Gamma <= 0 || w.Gamma >= 1 { return defaultWatermarkGamma }
+    return float64(w.Gamma)
+}
+
+// green reports whether tok is on the green list that follows prev
+func (w Watermark) green(prev, tok int) bool {
+    h := splitmix64(splitmix64(w.Key^uint64(prev)) ^ uint64(tok))
+    return float64(h>>11)/(1<<53) < w.gamma()
+}
+
+// apply biases the green list of the token following prev
+func (w Watermark) apply(logits []float32, prev int) {
+    delta := w.Delta
+    if delta == 0 { delta = defaultWatermarkDelta }
+    for id := range logits {
+        if w.green(prev, id) { logits[id] += delta }
+    }
+}
+
+// Detect scores a token sequence: every token after the first is tested
+// against the green list of its predecessor
+func (w Watermark) Detect(tokenIDs []int) WatermarkScore {
+    var s WatermarkScore
+    for i := 1; i < len(tokenIDs); i++ {
+        s.Scored++
+        if w.green(tokenIDs[i-1], tokenIDs[i]) { s.Green++ }
+    }
+    if s.Scored == 0 { return s }
+    g, t := w.gamma(), float64(s.Scored)
+    s.Z = (float64(s.Green) - g*t) / math.Sqrt(t*g*(1-g))
+    return s
+}
+
+// splitmix64 is a fast, well-mixed 64-bit hash
+func splitmix64(x uint64) uint64 {
+    x += 0x9e3779b97f4a7c15
+    x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+    x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+    return x ^ (x >> 31)
+}
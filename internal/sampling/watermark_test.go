@@ -0,0 +1,70 @@
+package sampling
+
+import (
+    "math"
+    "math/rand"
+    "testing"
+
+    "github.com/unixsysdev/nano-go-vllm/internal/tensor"
+)
+
+// TestWatermarkGreenFraction: every green list holds about Gamma of the
+// vocabulary, and apply biases exactly those tokens
+func TestWatermarkGreenFraction(t *testing.T) {
+    const V = 1000
+    for _, gamma := range []float32{0.1, 0.25, 0.5, 0} {
+        w := Watermark{Key: 42, Gamma: gamma}
+        var green int
+        for prev := 0; prev < 100; prev++ {
+            for tok := 0; tok < V; tok++ {
+                if w.green(prev, tok) { green++ }
+            }
+        }
+        // 100k draws: the standard deviation of the fraction is below 0.002
+        if f := float64(green) / (100 * V); math.Abs(f-w.gamma()) > 0.01 {
+            t.Errorf("gamma %g: green fraction %.4f, want %.2f", gamma, f, w.gamma())
+        }
+    }
+
+    w := Watermark{Key: 42}
+    logits := make([]float32, V)
+    w.apply(logits, 7)
+    for id, l := range logits {
+        want := float32(0)
+        if w.green(7, id) { want = defaultWatermarkDelta }
+        if l != want { t.Fatalf("token %d: logit %g, want %g", id, l, want) }
+    }
+}
+
+// TestWatermarkDetect samples from a flat distribution with and without the
+// watermark: only the watermarked text, checked with the right key, scores
+// high
+func TestWatermarkDetect(t *testing.T) {
+    const V, n = 1000, 200
+    w := &Watermark{Key: 0x5eed, Gamma: 0.25, Delta: 2}
+    logits, err := tensor.NewTensor([]int{1, V}, tensor.Float32, tensor.CPU)
+    if err != nil { t.Fatal(err) }
+    sampler := NewSampler()
+    params := []*SamplingParams{{Watermark: w}}
+    marked := []int{1}
+    for len(marked) < n+1 {
+        tok, err := sampler.Sample(logits, []float32{1}, [][]int{marked}, params, nil)
+        if err != nil { t.Fatal(err) }
+        marked = append(marked, tok[0])
+    }
+    // green mass is 0.25e^2 / (0.25e^2 + 0.75) = 0.71, z about 15
+    if s := w.Detect(marked); s.Scored != n || s.Z < 8 {
+        t.Errorf("watermarked text: %d of %d green, z = %.2f", s.Green, s.Scored, s.Z)
+    }
+    if s := (Watermark{Key: 0x5eee, Gamma: 0.25}).Detect(marked); s.Z > 4 {
+        t.Errorf("watermarked text with the wrong key: z = %.2f", s.Z)
+    }
+
+    rng := rand.New(rand.NewSource(1))
+    plain := make([]int, n+1)
+    for i := range plain { plain[i] = rng.Intn(V) }
+    if s := w.Detect(plain); s.Z > 4 || s.Z < -4 {
+        t.Errorf("unwatermarked text: %d of %d green, z = %.2f", s.Green, s.Scored, s.Z)
+    }
+    if s := w.Detect(plain[:1]); s.Scored != 0 || s.Z != 0 { t.Errorf("single token scored: %+v", s) }
+}
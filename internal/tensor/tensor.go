@@ -38,6 +38,20 @@ func NewTensor(shape []int, dtype Dtype, dev Device) (*Tensor, error) {
 	}, nil
 }
 
+// FromFloat32 wraps data as a CPU float32 tensor of the given shape without
+// copying; the tensor and the slice share memory
+func FromFloat32(shape []int, data []float32) (*Tensor, error) {
+    n := 1
+    for _, d := range shape { n *= d }
+    if len(data) != n {
+        return nil, fmt.Errorf("shape %v needs %d elements, got %d", shape, n, len(data))
+    }
+    return &Tensor{
+        data:   ggtensor.New(ggtensor.WithShape(shape...), ggtensor.WithBacking(data)),
+        device: CPU,
+    }, nil
+}
+
 // Data returns the underlying tensor data
 func (t *Tensor) Data() ggtensor.Tensor {
     t.mu.Lock()
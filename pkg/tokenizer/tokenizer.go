@@ -9,6 +9,7 @@ import (
     "path/filepath"
     "regexp"
     "sort"
+    "strconv"
     "strings"
     "unicode/utf8"
 )
@@ -20,6 +21,14 @@ type Tokenizer interface {
     GetEOS() int
 }
 
+// VocabProvider is implemented by tokenizers that can expose their vocabulary
+// as raw bytes (needed to mask tokens during constrained decoding).
+type VocabProvider interface {
+    // TokenBytes returns the decoded bytes of every token id. Special tokens
+    // and unused ids map to nil.
+    TokenBytes() ([][]byte, error)
+}
+
 // bpeTokenizer implements a minimal HF-compatible ByteLevel BPE
 type bpeTokenizer struct {
     vocab       map[string]int
@@ -31,9 +40,14 @@ type bpeTokenizer struct {
 
     byteEncoder map[byte]rune
     byteDecoder map[rune]byte
+    // metaspace marks a SentencePiece-style vocabulary (Llama, Mistral,
+    // Gemma): "▁" stands for a space and "<0xXX>" for a raw byte, instead
+    // of the ByteLevel rune mapping
+    metaspace bool
 
     added       map[string]int
     addedSorted []string
+    special     map[int]bool
 
     pattern *regexp.Regexp
     bpeCache map[string][]string
@@ -45,6 +59,11 @@ func NewTokenizer(modelPath string) (Tokenizer, error) {
     if ext, err := newExternal(modelPath); err == nil {
         return ext, nil
     }
+    return newBPE(modelPath)
+}
+
+// newBPE loads the native ByteLevel BPE tokenizer from tokenizer.json
+func newBPE(modelPath string) (*bpeTokenizer, error) {
     tokPath := filepath.Join(modelPath, "tokenizer.json")
     data, err := os.ReadFile(tokPath)
     if err != nil {
@@ -56,6 +75,7 @@ func NewTokenizer(modelPath string) (Tokenizer, error) {
             Vocab map[string]int    `json:"vocab"`
             MergesRaw []interface{} `json:"merges"`
             UnkToken string         `json:"unk_token"`
+            ByteFallback bool       `json:"byte_fallback"`
         } `json:"model"`
         PreTokenizer struct {
             Type string `json:"type"`
@@ -66,6 +86,7 @@ func NewTokenizer(modelPath string) (Tokenizer, error) {
             Content string `json:"content"`
             Special bool `json:"special"`
         } `json:"added_tokens"`
+        Decoder json.RawMessage `json:"decoder"`
     }
     if err := json.Unmarshal(data, &cfg); err != nil {
         return nil, fmt.Errorf("parse tokenizer.json: %w", err)
@@ -121,7 +142,11 @@ func NewTokenizer(modelPath string) (Tokenizer, error) {
 
     // Added tokens sorted by length desc for greedy longest match
     added := make(map[string]int)
-    for _, a := range cfg.AddedTokens { added[a.Content] = a.ID }
+    special := make(map[int]bool)
+    for _, a := range cfg.AddedTokens {
+        added[a.Content] = a.ID
+        if a.Special { special[a.ID] = true }
+    }
     var addedSorted []string
     for s := range added { addedSorted = append(addedSorted, s) }
     sort.Slice(addedSorted, func(i,j int) bool { return len(addedSorted[i]) > len(addedSorted[j]) })
@@ -138,8 +163,10 @@ func NewTokenizer(modelPath string) (Tokenizer, error) {
         unkID: unkID,
         byteEncoder: bt,
         byteDecoder: bd,
+        metaspace: cfg.Model.ByteFallback || isMetaspace(cfg.Decoder),
         added: added,
         addedSorted: addedSorted,
+        special: special,
         pattern: pat,
         bpeCache: make(map[string][]string),
     }, nil
@@ -203,22 +230,76 @@ func (t *bpeTokenizer) Decode(tokenIDs []int) (string, error) {
     buf := make([]byte, 0, len(tokenIDs)*4)
     for _, id := range tokenIDs {
         if id < 0 || id >= len(t.idToToken) { continue }
-        tok := t.idToToken[id]
-        for _, r := range tok {
-            if b, ok := t.byteDecoder[r]; ok {
-                buf = append(buf, b)
-            } else {
-                var tmp [4]byte
-                n := utf8.EncodeRune(tmp[:], r)
-                buf = append(buf, tmp[:n]...)
-            }
-        }
+        buf = t.appendPiece(buf, t.idToToken[id])
     }
     return string(buf), nil
 }
 
+// appendPiece appends the raw bytes a vocabulary entry stands for
+func (t *bpeTokenizer) appendPiece(buf []byte, tok string) []byte {
+    if t.metaspace {
+        // <0xXX> byte-fallback pieces are single raw bytes
+        if len(tok) == 6 && strings.HasPrefix(tok, "<0x") && tok[5] == '>' {
+            if b, err := strconv.ParseUint(tok[3:5], 16, 8); err == nil { return append(buf, byte(b)) }
+        }
+        return append(buf, strings.ReplaceAll(tok, "\u2581", " ")...)
+    }
+    for _, r := range tok {
+        if b, ok := t.byteDecoder[r]; ok {
+            buf = append(buf, b)
+        } else {
+            var tmp [4]byte
+            n := utf8.EncodeRune(tmp[:], r)
+            buf = append(buf, tmp[:n]...)
+        }
+    }
+    return buf
+}
+
+// isMetaspace reports whether a tokenizer.json decoder (possibly nested in
+// a Sequence) is SentencePiece style: Metaspace, ByteFallback, or a
+// Replace of "▁" with a space
+func isMetaspace(raw json.RawMessage) bool {
+    var dec struct {
+        Type     string            `json:"type"`
+        Decoders []json.RawMessage `json:"decoders"`
+        Pattern  struct {
+            String string `json:"String"`
+        } `json:"pattern"`
+    }
+    if len(raw) == 0 || json.Unmarshal(raw, &dec) != nil { return false }
+    switch dec.Type {
+    case "Metaspace", "ByteFallback":
+        return true
+    case "Replace":
+        return dec.Pattern.String == "\u2581"
+    case "Sequence":
+        for _, d := range dec.Decoders {
+            if isMetaspace(d) { return true }
+        }
+    }
+    return false
+}
+
 func (t *bpeTokenizer) GetEOS() int { return t.eosID }
 
+// TokenBytes maps every id to its raw bytes (ByteLevel runes -> bytes, or
+// "▁" -> space and <0xXX> -> byte for SentencePiece-style vocabularies)
+func (t *bpeTokenizer) TokenBytes() ([][]byte, error) {
+    out := make([][]byte, len(t.idToToken))
+    for id, tok := range t.idToToken {
+        if tok == "" || t.special[id] { continue }
+        if _, ok := t.added[tok]; ok {
+            // non-special added tokens are stored as plain text
+            out[id] = []byte(tok)
+            continue
+        }
+        b, _ := t.Decode([]int{id})
+        out[id] = []byte(b)
+    }
+    return out, nil
+}
+
 // --- BPE internals ---
 func (t *bpeTokenizer) applyBPE(token string) []string {
     if token == "" { return nil }
@@ -286,7 +367,7 @@ func (t *bpeTokenizer) tokensToIDs(tokens []string) []int {
 }
 
 // External tokenizer uses Python tokenizers library via scripts/tokenizer_adapter.py
-type external struct { modelPath string; py string; script string; eos int }
+type external struct { modelPath string; py string; script string; eos int; vocab [][]byte }
 
 func pickPython() (string, error) {
     // Try venv python first
@@ -341,6 +422,16 @@ func (e *external) Decode(tokenIDs []int) (string, error) {
 
 func (e *external) GetEOS() int { return e.eos }
 
+// TokenBytes reads the vocabulary natively from tokenizer.json; shelling out
+// per token would be far too slow for ~150k entries
+func (e *external) TokenBytes() ([][]byte, error) {
+    if e.vocab != nil { return e.vocab, nil }
+    bpe, err := newBPE(e.modelPath)
+    if err != nil { return nil, err }
+    if e.vocab, err = bpe.TokenBytes(); err != nil { return nil, err }
+    return e.vocab, nil
+}
+
 // bpeMerge performs BPE merges on slice of symbols using rank map
 func bpeMerge(sym []string, ranks map[[2]string]int) []string {
     if len(sym) <= 1 { return sym }
@@ -388,8 +479,8 @@ func bytesToUnicode() (map[byte]rune, map[rune]byte) {
             n++
         }
     }
-    sort.Ints(bs)
-    // map in order of bs
+    // bs and cs are parallel (GPT-2 bytes_to_unicode); sorting bs alone
+    // would pair bytes with the wrong runes
     be := make(map[byte]rune)
     bd := make(map[rune]byte)
     for i, b := range bs {
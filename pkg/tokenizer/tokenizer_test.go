@@ -0,0 +1,42 @@
+package tokenizer
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestTokenBytes(t *testing.T) {
+    tests := []struct {
+        name string
+        json string
+        want map[int]string
+    }{
+        {"byte level", `{
+            "model": {"type": "BPE", "vocab": {"a": 0, "Ġb": 1, "Ċ": 2}, "merges": []},
+            "pre_tokenizer": {"type": "ByteLevel"},
+            "decoder": {"type": "ByteLevel"}}`,
+            map[int]string{0: "a", 1: " b", 2: "\n"}},
+        {"sentencepiece", `{
+            "model": {"type": "BPE", "byte_fallback": true,
+                "vocab": {"<unk>": 0, "<0x0A>": 1, "▁the": 2, "Ġ": 3, "<0xE2>": 4}, "merges": []},
+            "decoder": {"type": "Sequence", "decoders": [
+                {"type": "Replace", "pattern": {"String": "▁"}, "content": " "},
+                {"type": "ByteFallback"}, {"type": "Fuse"}]},
+            "added_tokens": [{"id": 0, "content": "<unk>", "special": true}]}`,
+            map[int]string{0: "", 1: "\n", 2: " the", 3: "Ġ", 4: "\xe2"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            dir := t.TempDir()
+            if err := os.WriteFile(filepath.Join(dir, "tokenizer.json"), []byte(tt.json), 0o644); err != nil { t.Fatal(err) }
+            tok, err := newBPE(dir)
+            if err != nil { t.Fatal(err) }
+            got, err := tok.TokenBytes()
+            if err != nil { t.Fatal(err) }
+            for id, want := range tt.want {
+                if string(got[id]) != want { t.Errorf("token %d: got %q, want %q", id, got[id], want) }
+            }
+        })
+    }
+}